@@ -0,0 +1,25 @@
+-- Briefings produced by the llm-analyzer and published to Telegram by the telegram-bot
+
+-- Briefings table: one generated summary of a chat over a period
+CREATE TABLE briefings (
+    id BIGSERIAL PRIMARY KEY,
+    chat_id BIGINT NOT NULL REFERENCES chats(id),
+    period_start TIMESTAMPTZ NOT NULL,
+    period_end TIMESTAMPTZ NOT NULL,
+    title VARCHAR(255),
+    summary TEXT NOT NULL,
+
+    -- Highlights with references to the source messages, e.g.
+    -- [{"text": "Barbecue moved to Saturday", "message_ids": [1021, 1034]}]
+    -- message_ids are telegram_message_id values within chat_id
+    items JSONB,
+
+    -- Publishing state, maintained by the telegram-bot
+    published_at TIMESTAMPTZ,
+    sent_message_ids BIGINT[] NOT NULL DEFAULT '{}', -- Telegram ids of the parts already posted, in order
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_briefings_chat_id ON briefings(chat_id);
+CREATE INDEX idx_briefings_unpublished ON briefings(created_at) WHERE published_at IS NULL;
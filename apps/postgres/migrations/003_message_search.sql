@@ -0,0 +1,6 @@
+-- Full-text search over message text, used by the /ask command
+-- The 'simple' configuration avoids language-specific stemming since chats mix languages
+
+CREATE INDEX idx_messages_text_fts ON messages
+    USING GIN (to_tsvector('simple', COALESCE(text, '')))
+    WHERE text IS NOT NULL;
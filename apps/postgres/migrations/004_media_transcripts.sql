@@ -0,0 +1,28 @@
+-- Transcripts of voice messages and video notes, produced by the telegram-bot transcription worker
+
+-- Media transcripts table: one transcript per media blob (keyed by the MinIO object hash),
+-- shared by every message that references the same file
+CREATE TABLE media_transcripts (
+    media_sha256 VARCHAR(64) PRIMARY KEY, -- matches messages.media_sha256
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- 'pending', 'done', 'failed'
+    language VARCHAR(50), -- detected language as reported by whisper
+    text TEXT,
+
+    -- Timed segments, e.g. [{"start": 0.0, "end": 2.4, "text": "See you at noon"}]
+    segments JSONB,
+
+    model VARCHAR(255), -- backend/model that produced the transcript
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT, -- last error when status = 'failed'
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_media_transcripts_status ON media_transcripts(status);
+CREATE INDEX idx_media_transcripts_text_fts ON media_transcripts
+    USING GIN (to_tsvector('simple', COALESCE(text, '')))
+    WHERE text IS NOT NULL;
+
+CREATE TRIGGER update_media_transcripts_updated_at BEFORE UPDATE ON media_transcripts
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,24 @@
+-- Text extracted from photos and image documents by the telegram-bot enrichment worker
+
+-- Media enrichments table: OCR text and caption per media blob (keyed by the MinIO object hash),
+-- so a picture forwarded many times is only analysed once
+CREATE TABLE media_enrichments (
+    media_sha256 VARCHAR(64) PRIMARY KEY, -- matches messages.media_sha256
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- 'pending', 'done', 'failed'
+    ocr_text TEXT, -- text recognised by tesseract
+    caption TEXT, -- description from the vision model, if enabled
+    model VARCHAR(255), -- tools/models that produced the result
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT, -- last error when status = 'failed'
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_media_enrichments_status ON media_enrichments(status);
+CREATE INDEX idx_media_enrichments_fts ON media_enrichments
+    USING GIN (to_tsvector('simple', COALESCE(caption, '') || ' ' || COALESCE(ocr_text, '')))
+    WHERE status = 'done';
+
+CREATE TRIGGER update_media_enrichments_updated_at BEFORE UPDATE ON media_enrichments
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,47 @@
+-- Polls and quizzes posted in chats, with their options and non-anonymous votes
+
+-- Polls table: keyed by the Telegram poll id; the poll message itself is also stored in messages
+CREATE TABLE polls (
+    id VARCHAR(64) PRIMARY KEY, -- Telegram poll id
+    chat_id BIGINT REFERENCES chats(id),
+    telegram_message_id BIGINT,
+    creator_user_id BIGINT REFERENCES users(id),
+    question TEXT NOT NULL,
+    type VARCHAR(20) NOT NULL, -- 'regular', 'quiz'
+    is_anonymous BOOLEAN NOT NULL DEFAULT TRUE,
+    allows_multiple_answers BOOLEAN NOT NULL DEFAULT FALSE,
+    correct_option_id INTEGER, -- quizzes only, known once the quiz is closed
+    explanation TEXT, -- quizzes only
+    total_voter_count INTEGER NOT NULL DEFAULT 0,
+    is_closed BOOLEAN NOT NULL DEFAULT FALSE,
+    open_period_seconds INTEGER,
+    close_date TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Poll options table: option text and the latest vote count reported by Telegram
+CREATE TABLE poll_options (
+    poll_id VARCHAR(64) NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+    option_index INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    voter_count INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (poll_id, option_index)
+);
+
+-- Poll votes table: current choice of each user in non-anonymous polls
+CREATE TABLE poll_votes (
+    poll_id VARCHAR(64) NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    option_ids INTEGER[] NOT NULL,
+    voted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (poll_id, user_id)
+);
+
+CREATE INDEX idx_polls_chat_id ON polls(chat_id);
+CREATE INDEX idx_poll_votes_user_id ON poll_votes(user_id);
+
+CREATE TRIGGER update_polls_updated_at BEFORE UPDATE ON polls
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
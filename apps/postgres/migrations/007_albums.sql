@@ -0,0 +1,65 @@
+-- Media albums: photos/videos sent together share a media_group_id and form one logical post
+
+ALTER TABLE messages ADD COLUMN media_group_id VARCHAR(64);
+
+CREATE INDEX idx_messages_media_group_id ON messages(chat_id, media_group_id) WHERE media_group_id IS NOT NULL;
+
+-- Albums table: one row per album, maintained as its items arrive
+CREATE TABLE albums (
+    chat_id BIGINT NOT NULL REFERENCES chats(id),
+    media_group_id VARCHAR(64) NOT NULL,
+    user_id BIGINT REFERENCES users(id),
+    first_telegram_message_id BIGINT NOT NULL, -- the album is addressed by its first item
+    message_date TIMESTAMPTZ NOT NULL,
+    caption TEXT, -- Telegram puts the shared caption on a single item
+    item_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (chat_id, media_group_id)
+);
+
+CREATE TRIGGER update_albums_updated_at BEFORE UPDATE ON albums
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Posts view: messages as readers see them, with each album collapsed into a single post
+-- carrying its items as attachments, addressed by its first item.
+CREATE VIEW message_posts AS
+SELECT
+    m.chat_id,
+    m.telegram_message_id,
+    m.user_id,
+    m.message_date,
+    m.message_type,
+    m.text,
+    NULL::VARCHAR(64) AS media_group_id,
+    CASE WHEN m.media_sha256 IS NULL THEN 0 ELSE 1 END AS attachment_count,
+    CASE WHEN m.media_sha256 IS NULL THEN '[]'::JSONB
+        ELSE jsonb_build_array(jsonb_build_object(
+            'telegram_message_id', m.telegram_message_id,
+            'message_type', m.message_type,
+            'media_sha256', m.media_sha256,
+            'media_mime_type', m.media_mime_type))
+    END AS attachments
+FROM messages m
+WHERE m.media_group_id IS NULL
+UNION ALL
+SELECT
+    a.chat_id,
+    a.first_telegram_message_id,
+    a.user_id,
+    a.message_date,
+    'album',
+    a.caption,
+    a.media_group_id,
+    a.item_count,
+    (
+        SELECT jsonb_agg(jsonb_build_object(
+            'telegram_message_id', m.telegram_message_id,
+            'message_type', m.message_type,
+            'media_sha256', m.media_sha256,
+            'media_mime_type', m.media_mime_type) ORDER BY m.telegram_message_id)
+        FROM messages m
+        WHERE m.chat_id = a.chat_id AND m.media_group_id = a.media_group_id
+    )
+FROM albums a;
@@ -0,0 +1,71 @@
+-- Chat aliases: when a group is upgraded to a supergroup its id changes. History stays under
+-- the old id, and the alias links it to the new one so both resolve to one logical chat.
+
+ALTER TABLE chats ADD COLUMN migrated_to_chat_id BIGINT;
+
+-- Chat aliases table: alias_chat_id is an old id, chat_id the current (canonical) one
+CREATE TABLE chat_aliases (
+    alias_chat_id BIGINT PRIMARY KEY,
+    chat_id BIGINT NOT NULL REFERENCES chats(id),
+    reason VARCHAR(50) NOT NULL DEFAULT 'migrated_to_supergroup',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_chat_aliases_chat_id ON chat_aliases(chat_id);
+
+-- canonical_chat_id resolves any chat id (old or current) to the current id
+CREATE FUNCTION canonical_chat_id(id BIGINT) RETURNS BIGINT AS $$
+    SELECT COALESCE((SELECT chat_id FROM chat_aliases WHERE alias_chat_id = id), id)
+$$ LANGUAGE sql STABLE;
+
+-- chat_id_group returns every id of the logical chat that id belongs to
+CREATE FUNCTION chat_id_group(id BIGINT) RETURNS BIGINT[] AS $$
+    SELECT ARRAY(
+        SELECT canonical_chat_id(id)
+        UNION
+        SELECT alias_chat_id FROM chat_aliases WHERE chat_id = canonical_chat_id(id)
+    )
+$$ LANGUAGE sql STABLE;
+
+-- Expose the logical chat of each post
+CREATE OR REPLACE VIEW message_posts AS
+SELECT
+    m.chat_id,
+    m.telegram_message_id,
+    m.user_id,
+    m.message_date,
+    m.message_type,
+    m.text,
+    NULL::VARCHAR(64) AS media_group_id,
+    CASE WHEN m.media_sha256 IS NULL THEN 0 ELSE 1 END AS attachment_count,
+    CASE WHEN m.media_sha256 IS NULL THEN '[]'::JSONB
+        ELSE jsonb_build_array(jsonb_build_object(
+            'telegram_message_id', m.telegram_message_id,
+            'message_type', m.message_type,
+            'media_sha256', m.media_sha256,
+            'media_mime_type', m.media_mime_type))
+    END AS attachments,
+    canonical_chat_id(m.chat_id) AS logical_chat_id
+FROM messages m
+WHERE m.media_group_id IS NULL
+UNION ALL
+SELECT
+    a.chat_id,
+    a.first_telegram_message_id,
+    a.user_id,
+    a.message_date,
+    'album',
+    a.caption,
+    a.media_group_id,
+    a.item_count,
+    (
+        SELECT jsonb_agg(jsonb_build_object(
+            'telegram_message_id', m.telegram_message_id,
+            'message_type', m.message_type,
+            'media_sha256', m.media_sha256,
+            'media_mime_type', m.media_mime_type) ORDER BY m.telegram_message_id)
+        FROM messages m
+        WHERE m.chat_id = a.chat_id AND m.media_group_id = a.media_group_id
+    ),
+    canonical_chat_id(a.chat_id)
+FROM albums a;
@@ -0,0 +1,79 @@
+-- Forum topics: in topic-enabled supergroups every message belongs to a thread (topic).
+-- Messages outside the General topic carry the id of their topic.
+
+ALTER TABLE messages ADD COLUMN message_thread_id BIGINT;
+
+CREATE INDEX idx_messages_thread ON messages(chat_id, message_thread_id, message_date DESC)
+    WHERE message_thread_id IS NOT NULL;
+
+-- Forum topics table: a topic is identified by the id of the message that created it
+CREATE TABLE forum_topics (
+    chat_id BIGINT NOT NULL REFERENCES chats(id),
+    message_thread_id BIGINT NOT NULL,
+    name VARCHAR(128) NOT NULL,
+    icon_color INTEGER,
+    icon_custom_emoji_id VARCHAR(64),
+    created_by_user_id BIGINT REFERENCES users(id),
+    is_closed BOOLEAN NOT NULL DEFAULT FALSE,
+    topic_created_at TIMESTAMPTZ, -- NULL when the topic predates the bot
+    closed_at TIMESTAMPTZ,
+    reopened_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (chat_id, message_thread_id)
+);
+
+CREATE TRIGGER update_forum_topics_updated_at BEFORE UPDATE ON forum_topics
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Briefings may cover a single topic
+ALTER TABLE briefings ADD COLUMN message_thread_id BIGINT;
+
+-- Expose the topic of each post
+CREATE OR REPLACE VIEW message_posts AS
+SELECT
+    m.chat_id,
+    m.telegram_message_id,
+    m.user_id,
+    m.message_date,
+    m.message_type,
+    m.text,
+    NULL::VARCHAR(64) AS media_group_id,
+    CASE WHEN m.media_sha256 IS NULL THEN 0 ELSE 1 END AS attachment_count,
+    CASE WHEN m.media_sha256 IS NULL THEN '[]'::JSONB
+        ELSE jsonb_build_array(jsonb_build_object(
+            'telegram_message_id', m.telegram_message_id,
+            'message_type', m.message_type,
+            'media_sha256', m.media_sha256,
+            'media_mime_type', m.media_mime_type))
+    END AS attachments,
+    canonical_chat_id(m.chat_id) AS logical_chat_id,
+    m.message_thread_id
+FROM messages m
+WHERE m.media_group_id IS NULL
+UNION ALL
+SELECT
+    a.chat_id,
+    a.first_telegram_message_id,
+    a.user_id,
+    a.message_date,
+    'album',
+    a.caption,
+    a.media_group_id,
+    a.item_count,
+    (
+        SELECT jsonb_agg(jsonb_build_object(
+            'telegram_message_id', m.telegram_message_id,
+            'message_type', m.message_type,
+            'media_sha256', m.media_sha256,
+            'media_mime_type', m.media_mime_type) ORDER BY m.telegram_message_id)
+        FROM messages m
+        WHERE m.chat_id = a.chat_id AND m.media_group_id = a.media_group_id
+    ),
+    canonical_chat_id(a.chat_id),
+    (
+        SELECT m.message_thread_id FROM messages m
+        WHERE m.chat_id = a.chat_id AND m.telegram_message_id = a.first_telegram_message_id
+    )
+FROM albums a;
@@ -0,0 +1,40 @@
+-- Chat members: current status of every known member and a history of status changes,
+-- from chat_member/my_chat_member updates and join/leave service messages.
+
+-- Chats the bot was removed from are kept but no longer ingested
+ALTER TABLE chats ADD COLUMN is_active BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE chats ADD COLUMN bot_status VARCHAR(20); -- the bot's own member status
+ALTER TABLE chats ADD COLUMN deactivated_at TIMESTAMPTZ;
+
+-- Chat members table: one row per user and chat with the current status
+CREATE TABLE chat_members (
+    chat_id BIGINT NOT NULL REFERENCES chats(id),
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    status VARCHAR(20) NOT NULL, -- 'creator', 'administrator', 'member', 'restricted', 'left', 'kicked'
+    is_member BOOLEAN NOT NULL, -- restricted users may or may not be in the chat
+    custom_title VARCHAR(64),
+    until_date TIMESTAMPTZ, -- end of a ban or restriction
+    rights JSONB, -- administrator rights or restrictions
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (chat_id, user_id)
+);
+
+CREATE INDEX idx_chat_members_user_id ON chat_members(user_id);
+
+-- Chat member events table: every status transition
+CREATE TABLE chat_member_events (
+    id BIGSERIAL PRIMARY KEY,
+    chat_id BIGINT NOT NULL REFERENCES chats(id),
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    actor_user_id BIGINT REFERENCES users(id),
+    old_status VARCHAR(20), -- NULL when the member was unknown
+    new_status VARCHAR(20) NOT NULL,
+    source VARCHAR(20) NOT NULL, -- 'chat_member', 'my_chat_member' or 'service_message'
+    metadata JSONB,
+    changed_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_chat_member_events_chat ON chat_member_events(chat_id, changed_at DESC);
+CREATE INDEX idx_chat_member_events_user ON chat_member_events(user_id, changed_at DESC);
@@ -0,0 +1,56 @@
+-- User profiles: name history, account flags and archived profile photos
+
+ALTER TABLE users ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN language_code VARCHAR(35);
+ALTER TABLE users ADD COLUMN is_premium BOOLEAN;
+ALTER TABLE users ADD COLUMN avatar_sha256 VARCHAR(64); -- current profile photo in MinIO
+ALTER TABLE users ADD COLUMN avatar_file_unique_id VARCHAR(64);
+ALTER TABLE users ADD COLUMN avatar_checked_at TIMESTAMPTZ;
+
+-- User name history table: the names a user had before each change
+CREATE TABLE user_name_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    old_username VARCHAR(255),
+    old_first_name VARCHAR(255),
+    old_last_name VARCHAR(255),
+    new_username VARCHAR(255),
+    new_first_name VARCHAR(255),
+    new_last_name VARCHAR(255),
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_user_name_history_user_id ON user_name_history(user_id, changed_at DESC);
+CREATE INDEX idx_user_name_history_old_username ON user_name_history(LOWER(old_username));
+
+-- Record every name change made to users, whichever code path makes it
+CREATE OR REPLACE FUNCTION record_user_name_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO user_name_history (
+        user_id, old_username, old_first_name, old_last_name,
+        new_username, new_first_name, new_last_name
+    ) VALUES (
+        NEW.id, OLD.username, OLD.first_name, OLD.last_name,
+        NEW.username, NEW.first_name, NEW.last_name
+    );
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER record_users_name_change AFTER UPDATE ON users
+    FOR EACH ROW
+    WHEN ((OLD.username, OLD.first_name, OLD.last_name) IS DISTINCT FROM (NEW.username, NEW.first_name, NEW.last_name))
+    EXECUTE FUNCTION record_user_name_change();
+
+-- User avatars table: every profile photo seen, archived in MinIO by hash
+CREATE TABLE user_avatars (
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    file_unique_id VARCHAR(64) NOT NULL, -- stable Telegram id of the photo
+    media_sha256 VARCHAR(64) NOT NULL,
+    width INTEGER,
+    height INTEGER,
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (user_id, file_unique_id)
+);
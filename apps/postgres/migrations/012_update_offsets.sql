@@ -0,0 +1,23 @@
+-- Update offsets: the last Telegram update processed, stored along with the messages
+-- it wrote, so ingestion resumes where it stopped after a restart or crash.
+
+-- Replayed updates may have stored a message twice; keep the first copy
+DELETE FROM messages m
+USING messages d
+WHERE m.chat_id = d.chat_id
+    AND m.telegram_message_id = d.telegram_message_id
+    AND m.id > d.id;
+
+-- A message is stored once, so replayed updates are ignored
+ALTER TABLE messages ADD CONSTRAINT messages_chat_id_telegram_message_id_key
+    UNIQUE (chat_id, telegram_message_id);
+
+-- Update offsets table: one row per bot, since several bots may share the database
+CREATE TABLE update_offsets (
+    bot_id BIGINT PRIMARY KEY,
+    last_update_id BIGINT NOT NULL, -- every update up to this one was processed
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TRIGGER update_update_offsets_updated_at BEFORE UPDATE ON update_offsets
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
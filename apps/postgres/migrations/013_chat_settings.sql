@@ -0,0 +1,23 @@
+-- Chat settings: the ingestion policy of each chat. Only chats on the allowlist are
+-- ingested; anything else the bot receives is dropped before it is written.
+
+-- Chat settings table: no reference to chats, since a chat can be allowed before
+-- anything of it is stored
+CREATE TABLE chat_settings (
+    chat_id BIGINT PRIMARY KEY,
+    allowed BOOLEAN NOT NULL DEFAULT FALSE, -- on the allowlist
+    message_types TEXT[], -- message types stored; NULL stores every type
+    store_media BOOLEAN NOT NULL DEFAULT TRUE, -- download media files to MinIO
+    max_media_size BIGINT, -- largest media file downloaded, in bytes; NULL for no limit
+    store_locations BOOLEAN NOT NULL DEFAULT TRUE, -- keep coordinates of locations and venues
+    updated_by BIGINT, -- user who last changed the settings
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TRIGGER update_chat_settings_updated_at BEFORE UPDATE ON chat_settings
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Chats ingested so far stay on the allowlist
+INSERT INTO chat_settings (chat_id, allowed)
+SELECT id, TRUE FROM chats;
@@ -0,0 +1,12 @@
+-- Admin commands: chats can be paused by their admins, and users can opt out of
+-- having their messages stored with /forgetme.
+
+-- A paused chat stays on the allowlist but is not ingested until resumed
+ALTER TABLE chat_settings ADD COLUMN paused BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- User opt-outs table: no reference to users, so an opt-out outlives the user's data
+CREATE TABLE user_opt_outs (
+    user_id BIGINT PRIMARY KEY,
+    chat_id BIGINT, -- chat /forgetme was sent in
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,43 @@
+-- User erasure: /forgetme deletes or anonymises everything stored about a user, and
+-- an audit record is kept of each erasure.
+
+-- User erasures table: what was removed for whom, without any of the removed data
+CREATE TABLE user_erasures (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL, -- no reference to users, like user_opt_outs
+    chat_id BIGINT, -- chat the request was made in
+    messages_deleted INTEGER NOT NULL DEFAULT 0,
+    reactions_deleted INTEGER NOT NULL DEFAULT 0,
+    poll_votes_deleted INTEGER NOT NULL DEFAULT 0,
+    polls_deleted INTEGER NOT NULL DEFAULT 0,
+    service_messages_anonymised INTEGER NOT NULL DEFAULT 0,
+    member_events_deleted INTEGER NOT NULL DEFAULT 0,
+    blobs_unreferenced INTEGER NOT NULL DEFAULT 0, -- MinIO objects no longer referenced by anything
+    blobs_deleted INTEGER NOT NULL DEFAULT 0,
+    error TEXT, -- why blobs could not be deleted, if any
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ -- set once the blobs are deleted
+);
+
+CREATE INDEX idx_user_erasures_user_id ON user_erasures(user_id);
+
+-- Users who opted out are kept as bare ids, since chat members still reference them;
+-- strip their profile whichever code path writes it
+CREATE OR REPLACE FUNCTION strip_opted_out_user()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM user_opt_outs WHERE user_id = NEW.id) THEN
+        NEW.username = NULL;
+        NEW.first_name = NULL;
+        NEW.last_name = NULL;
+        NEW.language_code = NULL;
+        NEW.is_premium = NULL;
+        NEW.avatar_sha256 = NULL;
+        NEW.avatar_file_unique_id = NULL;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER strip_users_opted_out BEFORE INSERT OR UPDATE ON users
+    FOR EACH ROW EXECUTE FUNCTION strip_opted_out_user();
@@ -0,0 +1,15 @@
+-- Retention: how long each chat keeps its messages, media and locations. The
+-- telegram-bot retention worker purges what is older; NULL keeps it forever.
+
+ALTER TABLE chat_settings ADD COLUMN text_retention_days INTEGER; -- messages and service events
+ALTER TABLE chat_settings ADD COLUMN media_retention_days INTEGER; -- media files of messages
+ALTER TABLE chat_settings ADD COLUMN location_retention_days INTEGER; -- coordinates of locations and venues
+
+-- The purge selects old rows of a chat by date
+CREATE INDEX idx_messages_chat_date ON messages(chat_id, message_date);
+CREATE INDEX idx_service_messages_chat_date ON service_messages(chat_id, message_date);
+
+-- Purged media files are deleted once nothing references them
+CREATE INDEX idx_service_messages_media_sha256 ON service_messages((metadata->>'media_sha256'));
+CREATE INDEX idx_user_avatars_media_sha256 ON user_avatars(media_sha256);
+CREATE INDEX idx_users_avatar_sha256 ON users(avatar_sha256) WHERE avatar_sha256 IS NOT NULL;
@@ -0,0 +1,102 @@
+-- Blobs: media files in MinIO are keyed by their SHA256, so one object can be shared by
+-- many messages, avatars and chat photos. Each object has a reference count, kept by
+-- triggers whichever code path adds or removes a reference; an object is only deleted
+-- once nothing references it.
+
+-- Blobs table: one row per MinIO object that was ever referenced
+CREATE TABLE blobs (
+    sha256 VARCHAR(64) PRIMARY KEY, -- MinIO object key
+    size BIGINT, -- bytes, when known
+    content_type VARCHAR(255),
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ref_count INTEGER NOT NULL DEFAULT 0,
+    unreferenced_since TIMESTAMPTZ -- set while ref_count is 0
+);
+
+CREATE INDEX idx_blobs_unreferenced ON blobs(unreferenced_since) WHERE ref_count <= 0;
+
+-- Existing references
+INSERT INTO blobs (sha256, size, content_type, first_seen_at, ref_count)
+SELECT hash, MAX(size), MAX(content_type), MIN(seen_at), COUNT(*)
+FROM (
+    SELECT media_sha256, media_file_size, media_mime_type, created_at
+    FROM messages WHERE media_sha256 IS NOT NULL
+    UNION ALL
+    SELECT media_sha256, NULL, 'image/jpeg', first_seen_at
+    FROM user_avatars
+    UNION ALL
+    SELECT metadata->>'media_sha256', NULL, 'image/jpeg', created_at
+    FROM service_messages WHERE metadata->>'media_sha256' IS NOT NULL
+) refs (hash, size, content_type, seen_at)
+GROUP BY hash;
+
+-- add_blob_ref adds delta references to a blob, creating its row on first use
+CREATE OR REPLACE FUNCTION add_blob_ref(hash VARCHAR, delta INTEGER, blob_size BIGINT, blob_content_type VARCHAR)
+RETURNS VOID AS $$
+BEGIN
+    IF hash IS NULL THEN
+        RETURN;
+    END IF;
+    INSERT INTO blobs (sha256, size, content_type, ref_count, unreferenced_since)
+    VALUES (hash, blob_size, blob_content_type, delta, CASE WHEN delta <= 0 THEN NOW() END)
+    ON CONFLICT (sha256) DO UPDATE SET
+        size = COALESCE(blobs.size, EXCLUDED.size),
+        content_type = COALESCE(blobs.content_type, EXCLUDED.content_type),
+        ref_count = blobs.ref_count + delta,
+        unreferenced_since = CASE WHEN blobs.ref_count + delta <= 0 THEN COALESCE(blobs.unreferenced_since, NOW()) END;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION count_message_blob_refs()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD.media_sha256 IS NOT DISTINCT FROM NEW.media_sha256 THEN
+        RETURN NULL;
+    END IF;
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM add_blob_ref(OLD.media_sha256, -1, NULL, NULL);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM add_blob_ref(NEW.media_sha256, 1, NEW.media_file_size, NEW.media_mime_type);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER count_messages_blob_refs AFTER INSERT OR DELETE OR UPDATE OF media_sha256 ON messages
+    FOR EACH ROW EXECUTE FUNCTION count_message_blob_refs();
+
+-- Avatars and chat photos are always archived as JPEG
+CREATE OR REPLACE FUNCTION count_avatar_blob_refs()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM add_blob_ref(OLD.media_sha256, -1, NULL, NULL);
+    ELSE
+        PERFORM add_blob_ref(NEW.media_sha256, 1, NULL, 'image/jpeg');
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER count_user_avatars_blob_refs AFTER INSERT OR DELETE ON user_avatars
+    FOR EACH ROW EXECUTE FUNCTION count_avatar_blob_refs();
+
+CREATE OR REPLACE FUNCTION count_service_message_blob_refs()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD.metadata->>'media_sha256' IS NOT DISTINCT FROM NEW.metadata->>'media_sha256' THEN
+        RETURN NULL;
+    END IF;
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM add_blob_ref(OLD.metadata->>'media_sha256', -1, NULL, NULL);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM add_blob_ref(NEW.metadata->>'media_sha256', 1, NULL, 'image/jpeg');
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER count_service_messages_blob_refs AFTER INSERT OR DELETE OR UPDATE OF metadata ON service_messages
+    FOR EACH ROW EXECUTE FUNCTION count_service_message_blob_refs();
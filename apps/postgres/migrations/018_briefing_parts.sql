@@ -0,0 +1,5 @@
+-- Briefing parts: the messages a briefing is posted as are stored before the first one
+-- goes out, so a publish resumed after a restart sends exactly the parts still missing,
+-- whatever the current rendering would produce.
+
+ALTER TABLE briefings ADD COLUMN rendered_parts TEXT[]; -- Telegram HTML of each part, in order; NULL until publishing starts
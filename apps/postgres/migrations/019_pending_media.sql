@@ -0,0 +1,10 @@
+-- Pending media: a message whose media is to be archived keeps its Telegram file id until
+-- the upload has finished, so an upload lost to a crash or a failed attempt is retried by
+-- the upload recovery worker instead of leaving media_sha256 NULL for good.
+
+ALTER TABLE messages
+    ADD COLUMN media_file_id TEXT, -- Telegram file id of media not archived yet; NULL once archived or given up
+    ADD COLUMN media_upload_attempts INTEGER NOT NULL DEFAULT 0, -- failed uploads so far
+    ADD COLUMN media_upload_failed_at TIMESTAMPTZ; -- last failed upload
+
+CREATE INDEX idx_messages_pending_media ON messages (created_at) WHERE media_file_id IS NOT NULL;
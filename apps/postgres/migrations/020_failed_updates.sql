@@ -0,0 +1,17 @@
+-- Failed updates: an update whose handler still fails after several attempts is parked
+-- here, with the offset moving past it, so one bad update doesn't hold up its chat or
+-- the stored offset for good. Parked updates can be inspected and replayed by hand.
+
+CREATE TABLE failed_updates (
+    bot_id BIGINT NOT NULL,
+    update_id BIGINT NOT NULL,
+    update_type VARCHAR(32) NOT NULL, -- Bot API field name, e.g. message or poll_answer
+    chat_id BIGINT, -- NULL for updates without a chat
+    payload JSONB NOT NULL, -- the update as received from Telegram
+    error TEXT NOT NULL, -- error of the last attempt
+    attempts INTEGER NOT NULL,
+    failed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (bot_id, update_id)
+);
+
+CREATE INDEX idx_failed_updates_chat ON failed_updates (chat_id, failed_at);
@@ -0,0 +1,57 @@
+-- Opt-outs in service messages and member events: like profiles, the service events and
+-- member history of users who opted out are stripped whichever code path writes them,
+-- so a join or leave after /forgetme doesn't store what the erasure removed.
+
+-- Service messages keep the event but lose opted-out users as actor, joined or left
+-- member, or video chat invitee
+CREATE OR REPLACE FUNCTION strip_opted_out_service_message()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM user_opt_outs WHERE user_id = NEW.actor_user_id) THEN
+        NEW.actor_user_id = NULL;
+    END IF;
+    IF jsonb_typeof(NEW.metadata) IS DISTINCT FROM 'object' THEN
+        RETURN NEW;
+    END IF;
+
+    IF EXISTS (SELECT 1 FROM user_opt_outs WHERE user_id = (NEW.metadata->>'joined_user_id')::bigint) THEN
+        NEW.metadata = NEW.metadata - ARRAY['joined_user_id', 'joined_username', 'joined_first_name', 'joined_last_name'];
+    END IF;
+    IF EXISTS (SELECT 1 FROM user_opt_outs WHERE user_id = (NEW.metadata->>'left_user_id')::bigint) THEN
+        NEW.metadata = NEW.metadata - ARRAY['left_user_id', 'left_username', 'left_first_name', 'left_last_name'];
+    END IF;
+    IF jsonb_typeof(NEW.metadata->'joined_users') = 'array' THEN
+        NEW.metadata = jsonb_set(NEW.metadata, '{joined_users}', (
+            SELECT COALESCE(jsonb_agg(u ORDER BY n), '[]'::jsonb)
+            FROM jsonb_array_elements(NEW.metadata->'joined_users') WITH ORDINALITY AS e(u, n)
+            WHERE NOT EXISTS (SELECT 1 FROM user_opt_outs WHERE user_id = (u->>'user_id')::bigint)));
+    END IF;
+    IF jsonb_typeof(NEW.metadata->'invited_user_ids') = 'array' THEN
+        NEW.metadata = jsonb_set(NEW.metadata, '{invited_user_ids}', (
+            SELECT COALESCE(jsonb_agg(id ORDER BY n), '[]'::jsonb)
+            FROM jsonb_array_elements(NEW.metadata->'invited_user_ids') WITH ORDINALITY AS e(id, n)
+            WHERE NOT EXISTS (SELECT 1 FROM user_opt_outs WHERE user_id = (id #>> '{}')::bigint)));
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER strip_service_messages_opted_out BEFORE INSERT OR UPDATE OF actor_user_id, metadata ON service_messages
+    FOR EACH ROW EXECUTE FUNCTION strip_opted_out_service_message();
+
+-- Member events of opted-out users are not stored, and they are left out as actor
+CREATE OR REPLACE FUNCTION skip_opted_out_member_event()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM user_opt_outs WHERE user_id = NEW.user_id) THEN
+        RETURN NULL;
+    END IF;
+    IF EXISTS (SELECT 1 FROM user_opt_outs WHERE user_id = NEW.actor_user_id) THEN
+        NEW.actor_user_id = NULL;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER skip_chat_member_events_opted_out BEFORE INSERT OR UPDATE OF user_id, actor_user_id ON chat_member_events
+    FOR EACH ROW EXECUTE FUNCTION skip_opted_out_member_event();
@@ -0,0 +1,10 @@
+-- Pending chat photos: like message media, a new chat photo is archived on the upload
+-- workers, and the service message keeps its Telegram file id until the upload has
+-- finished, so a lost or failed upload is retried by the upload recovery worker.
+
+ALTER TABLE service_messages
+    ADD COLUMN media_file_id TEXT, -- Telegram file id of a chat photo not archived yet; NULL once archived or given up
+    ADD COLUMN media_upload_attempts INTEGER NOT NULL DEFAULT 0, -- failed uploads so far
+    ADD COLUMN media_upload_failed_at TIMESTAMPTZ; -- last failed upload
+
+CREATE INDEX idx_service_messages_pending_media ON service_messages (created_at) WHERE media_file_id IS NOT NULL;
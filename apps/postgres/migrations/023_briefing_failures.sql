@@ -0,0 +1,8 @@
+-- Briefing failures: a briefing Telegram refuses for good (bot removed from the chat,
+-- chat deleted, no right to post) is given up on at once, and any other failure after a
+-- number of attempts, instead of being retried every minute forever.
+
+ALTER TABLE briefings
+    ADD COLUMN publish_attempts INTEGER NOT NULL DEFAULT 0, -- failed publishing attempts so far
+    ADD COLUMN publish_error TEXT, -- error of the last failed attempt
+    ADD COLUMN failed_at TIMESTAMPTZ; -- set once publishing is given up on
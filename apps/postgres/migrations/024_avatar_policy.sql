@@ -0,0 +1,18 @@
+-- Avatar policy: profile photos are only archived under the media settings of the chats
+-- a user is in, so users seen only in chats that don't store media keep no avatar.
+
+-- avatar_policy tells whether a user's profile photo may be archived: the user has not
+-- opted out and is a member of, or wrote in, an ingested chat that stores media.
+-- max_size is the largest photo archived, NULL when one of those chats has no limit.
+CREATE FUNCTION avatar_policy(uid BIGINT, OUT allowed BOOLEAN, OUT max_size BIGINT) AS $$
+    SELECT COUNT(*) > 0,
+        CASE WHEN bool_or(cs.max_media_size IS NULL) THEN NULL ELSE MAX(cs.max_media_size) END
+    FROM (
+        SELECT chat_id FROM chat_members WHERE user_id = uid AND is_member
+        UNION
+        SELECT DISTINCT chat_id FROM messages WHERE user_id = uid
+    ) user_chats
+    JOIN chats c ON c.id = canonical_chat_id(user_chats.chat_id) AND c.is_active
+    JOIN chat_settings cs ON cs.chat_id = c.id AND cs.allowed AND NOT cs.paused AND cs.store_media
+    WHERE NOT EXISTS (SELECT 1 FROM user_opt_outs WHERE user_id = uid)
+$$ LANGUAGE sql STABLE;
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/blobs"
+	"beef-briefing/apps/telegram-bot/internal/config"
+	"beef-briefing/apps/telegram-bot/internal/storage"
+	"beef-briefing/apps/telegram-bot/internal/store"
+)
+
+// runGC collects garbage in the MinIO bucket once and exits:
+//
+//	telegram-bot gc [-dry-run] [-min-age 24h] [-delete-orphans]
+//
+// Blobs no message, avatar or chat photo references are deleted; files in the
+// bucket that have no blob row at all are reported, and deleted with -delete-orphans.
+func runGC(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would be deleted")
+	minAge := fs.Duration("min-age", 24*time.Hour, "how long a file must have been unreferenced, or an orphan, before it is deleted")
+	deleteOrphans := fs.Bool("delete-orphans", false, "delete files that have no blob row, rather than only reporting them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	dbStore, err := store.NewPostgresStore(ctx, cfg.DSN())
+	if err != nil {
+		return fmt.Errorf("failed to create database store: %w", err)
+	}
+	defer dbStore.Close()
+
+	minioClient, err := storage.NewMinIOClient(ctx, cfg.MinIOEndpoint, cfg.MinIOAccessKey, cfg.MinIOSecretKey,
+		cfg.MinIOBucket, cfg.MinIOUseSSL)
+	if err != nil {
+		return fmt.Errorf("failed to create MinIO client: %w", err)
+	}
+
+	slog.Info("garbage collection started",
+		"bucket", cfg.MinIOBucket,
+		"dry_run", *dryRun,
+		"min_age", *minAge,
+		"delete_orphans", *deleteOrphans)
+
+	report, err := blobs.Collect(ctx, dbStore, minioClient, blobs.Options{
+		MinAge:        *minAge,
+		DeleteOrphans: *deleteOrphans,
+		DryRun:        *dryRun,
+	})
+	slog.Info("garbage collection done",
+		"unreferenced", report.Unreferenced,
+		"unreferenced_bytes", report.UnreferencedBytes,
+		"deleted", report.Deleted,
+		"orphans", report.Orphans,
+		"orphan_bytes", report.OrphanBytes,
+		"orphans_deleted", report.OrphansDeleted,
+		"failed", report.Failed)
+	return err
+}
@@ -1,20 +1,46 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
+	_ "time/tzdata" // timezone database for the briefing schedule in minimal images
 
+	"beef-briefing/apps/telegram-bot/internal/api"
+	"beef-briefing/apps/telegram-bot/internal/avatar"
 	"beef-briefing/apps/telegram-bot/internal/config"
+	"beef-briefing/apps/telegram-bot/internal/dispatch"
+	"beef-briefing/apps/telegram-bot/internal/enrich"
 	"beef-briefing/apps/telegram-bot/internal/handler"
+	"beef-briefing/apps/telegram-bot/internal/metrics"
+	"beef-briefing/apps/telegram-bot/internal/publisher"
+	"beef-briefing/apps/telegram-bot/internal/retention"
 	"beef-briefing/apps/telegram-bot/internal/storage"
 	"beef-briefing/apps/telegram-bot/internal/store"
+	"beef-briefing/apps/telegram-bot/internal/tracing"
+	"beef-briefing/apps/telegram-bot/internal/transcribe"
+	"beef-briefing/apps/telegram-bot/internal/webhook"
 
 	tele "gopkg.in/telebot.v4"
 )
 
+// offsetSaveInterval is how often the update offset is stored for updates that
+// write nothing themselves
+const offsetSaveInterval = 10 * time.Second
+
+// allowedUpdates are the update types requested from Telegram. chat_member
+// updates are only sent when asked for explicitly.
+var allowedUpdates = []string{
+	"message", "edited_message", "channel_post", "edited_channel_post",
+	"poll", "poll_answer", "my_chat_member", "chat_member",
+}
+
 func main() {
 	// Load configuration
 	cfg, err := config.LoadConfig()
@@ -26,21 +52,45 @@ func main() {
 	// Setup logger
 	setupLogger(cfg)
 
+	// `telegram-bot gc` collects garbage in the MinIO bucket instead of running the bot
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		if err := runGC(cfg, os.Args[2:]); err != nil {
+			slog.Error("garbage collection failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	slog.Info("starting telegram bot",
 		"environment", cfg.Environment,
 		"log_level", cfg.LogLevel)
 
+	// The root context is cancelled on SIGINT/SIGTERM: no new updates are taken and
+	// background jobs stop. Work already under way runs under workCtx, which outlives
+	// the signal and is only cancelled if draining takes longer than DRAIN_TIMEOUT.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	workCtx, abortWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer abortWork()
+
+	// Export traces, if enabled
+	shutdownTracing, err := setupTracing(ctx, cfg)
+	if err != nil {
+		slog.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
 	// Initialize database store
-	dbStore, err := store.NewPostgresStore(cfg.DSN())
+	dbStore, err := store.NewPostgresStore(ctx, cfg.DSN())
 	if err != nil {
 		slog.Error("failed to create database store", "error", err)
 		os.Exit(1)
 	}
-	defer dbStore.Close()
 	slog.Info("database connection established")
 
 	// Initialize MinIO storage
 	minioClient, err := storage.NewMinIOClient(
+		ctx,
 		cfg.MinIOEndpoint,
 		cfg.MinIOAccessKey,
 		cfg.MinIOSecretKey,
@@ -53,10 +103,13 @@ func main() {
 	}
 	slog.Info("MinIO client initialized", "bucket", cfg.MinIOBucket)
 
-	// Create bot (needed for file downloads)
+	// Create bot (needed for file downloads). Handlers run synchronously on the
+	// update workers rather than in a goroutine per update. Its poller is set once
+	// the update offset is loaded.
 	pref := tele.Settings{
-		Token:  cfg.TelegramBotToken,
-		Poller: &tele.LongPoller{Timeout: 10 * time.Second},
+		Token:       cfg.TelegramBotToken,
+		Synchronous: true,
+		OnError:     onError,
 	}
 
 	bot, err := tele.NewBot(pref)
@@ -67,8 +120,54 @@ func main() {
 
 	slog.Info("bot created successfully")
 
-	// Initialize handler with MinIO client and bot
-	h := handler.NewHandler(dbStore, minioClient, bot)
+	// Resume after the last update processed by a previous run. Long polling asks
+	// Telegram for the updates after it; with webhooks, redelivered ones are skipped.
+	offset, err := dbStore.GetUpdateOffset(ctx, bot.Me.ID)
+	if err != nil {
+		slog.Error("failed to load update offset", "error", err)
+		os.Exit(1)
+	}
+	offsets := dispatch.NewOffsets(offset)
+	slog.Info("update offset loaded", "last_update_id", offset)
+
+	poller, err := newPoller(cfg, offsets)
+	if err != nil {
+		slog.Error("failed to configure update delivery", "error", err)
+		os.Exit(1)
+	}
+
+	// Initialize api-service client
+	apiClient := api.NewClient(cfg.APIServiceURL, cfg.APIServiceTimeout)
+
+	// Media uploads run on their own workers, so large files don't hold up update processing
+	uploads := dispatch.NewPool("uploads", cfg.UploadWorkers, cfg.UploadQueueSize)
+
+	// Slow commands run on their own workers, so waiting for the analyzer doesn't hold up a chat
+	commands := dispatch.NewPool("commands", cfg.CommandWorkers, cfg.CommandQueueSize)
+
+	// Initialize handler with MinIO client, bot, api-service client, upload and command workers and update offsets
+	h := handler.NewHandler(workCtx, dbStore, minioClient, bot, apiClient, uploads, commands, offsets, cfg)
+	if err := h.LoadInactiveChats(ctx); err != nil {
+		slog.Error("failed to load inactive chats", "error", err)
+		os.Exit(1)
+	}
+	if err := h.LoadChatSettings(ctx); err != nil {
+		slog.Error("failed to load chat settings", "error", err)
+		os.Exit(1)
+	}
+	if err := h.LoadOptOuts(ctx); err != nil {
+		slog.Error("failed to load opt-outs", "error", err)
+		os.Exit(1)
+	}
+	if len(cfg.OwnerUserIDs) == 0 {
+		slog.Warn("no bot owners configured, chats can only be allowed with SQL")
+	}
+
+	// Mark the traces of failed updates
+	bot.Use(h.TraceErrors)
+
+	// Register commands
+	registerCommands(bot, h.Commands())
 
 	// Register handlers
 	bot.Handle(tele.OnText, h.HandleMessage)
@@ -81,30 +180,302 @@ func main() {
 	bot.Handle(tele.OnVideoNote, h.HandleMessage)
 	bot.Handle(tele.OnLocation, h.HandleMessage)
 	bot.Handle(tele.OnVenue, h.HandleMessage)
+	bot.Handle(tele.OnAudio, h.HandleMessage)
+	bot.Handle(tele.OnContact, h.HandleMessage)
+	bot.Handle(tele.OnDice, h.HandleMessage)
+	bot.Handle(tele.OnGame, h.HandleMessage)
 	bot.Handle(tele.OnUserJoined, h.HandleUserJoined)
 	bot.Handle(tele.OnUserLeft, h.HandleUserLeft)
+	bot.Handle(tele.OnAddedToGroup, h.HandleAddedToGroup)
+	bot.Handle(tele.OnGroupCreated, h.HandleChatCreated)
+	bot.Handle(tele.OnSuperGroupCreated, h.HandleChatCreated)
+	bot.Handle(tele.OnChannelCreated, h.HandleChatCreated)
+	bot.Handle(tele.OnNewGroupTitle, h.HandleNewGroupTitle)
+	bot.Handle(tele.OnNewGroupPhoto, h.HandleNewGroupPhoto)
+	bot.Handle(tele.OnGroupPhotoDeleted, h.HandleGroupPhotoDeleted)
+	bot.Handle(tele.OnPinned, h.HandlePinned)
+	bot.Handle(tele.OnMigration, h.HandleMigration)
+	bot.Handle(tele.OnVideoChatStarted, h.HandleVideoChatStarted)
+	bot.Handle(tele.OnVideoChatEnded, h.HandleVideoChatEnded)
+	bot.Handle(tele.OnVideoChatScheduled, h.HandleVideoChatScheduled)
+	bot.Handle(tele.OnVideoChatParticipants, h.HandleVideoChatParticipants)
+	bot.Handle(tele.OnAutoDeleteTimer, h.HandleAutoDeleteTimer)
+	bot.Handle(tele.OnTopicCreated, h.HandleTopicCreated)
+	bot.Handle(tele.OnTopicEdited, h.HandleTopicEdited)
+	bot.Handle(tele.OnTopicClosed, h.HandleTopicClosed)
+	bot.Handle(tele.OnTopicReopened, h.HandleTopicReopened)
+	bot.Handle(tele.OnGeneralTopicHidden, h.HandleGeneralTopicHidden)
+	bot.Handle(tele.OnGeneralTopicUnhidden, h.HandleGeneralTopicUnhidden)
+	bot.Handle(tele.OnPoll, h.HandlePoll)
+	bot.Handle(tele.OnPollAnswer, h.HandlePollAnswer)
+	bot.Handle(tele.OnChatMember, h.HandleChatMember)
+	bot.Handle(tele.OnMyChatMember, h.HandleMyChatMember)
+
+	// Process updates on a bounded set of workers, in order per chat (for poll
+	// updates, the chat of the poll). Failing updates are retried, then parked.
+	updates := dispatch.NewShardedPool("updates", cfg.UpdateWorkers, cfg.UpdateQueueSize)
+	bot.Poller = dispatch.NewPoller(workCtx, poller, updates, offsets, h.ProcessUpdate, h.ParkUpdate, func(pollID string) int64 {
+		chatID, err := dbStore.GetPollChatID(workCtx, pollID)
+		if err != nil {
+			slog.Warn("failed to look up poll chat", "error", err, "poll_id", pollID)
+		}
+		return chatID
+	})
+	metrics.RegisterQueue("updates", updates.Len)
+	metrics.RegisterQueue("uploads", uploads.Len)
+	metrics.RegisterQueue("commands", commands.Len)
 
 	slog.Info("handlers registered")
 
+	// Background jobs, waited for on shutdown
+	var jobs sync.WaitGroup
+
+	// Start metrics and health check server
+	var metricsServer *metrics.Server
+	if cfg.MetricsEnabled {
+		metricsServer = metrics.NewServer(cfg.MetricsListen,
+			metrics.Check{Name: "postgres", Check: dbStore.Ping},
+			metrics.Check{Name: "minio", Check: minioClient.Ping},
+		)
+		go metricsServer.Run(ctx)
+	}
+
+	// Start briefing publisher
+	if cfg.BriefingEnabled {
+		pub, err := publisher.NewPublisher(dbStore, bot, cfg.BriefingPostTime, cfg.BriefingTimezone)
+		if err != nil {
+			slog.Error("failed to create briefing publisher", "error", err)
+			os.Exit(1)
+		}
+		jobs.Go(func() { pub.Run(ctx) })
+		slog.Info("briefing publisher started",
+			"post_time", cfg.BriefingPostTime,
+			"timezone", cfg.BriefingTimezone)
+	}
+
+	// Start transcription worker
+	if cfg.TranscribeEnabled {
+		transcriber, err := newTranscriber(cfg)
+		if err != nil {
+			slog.Error("failed to create transcriber", "error", err)
+			os.Exit(1)
+		}
+		worker := transcribe.NewWorker(dbStore, minioClient, transcriber, cfg.TranscribeInterval, cfg.TranscribeMaxAttempts)
+		jobs.Go(func() { worker.Run(ctx) })
+		slog.Info("transcription worker started", "backend", transcriber.Name())
+	}
+
+	// Start image enrichment worker
+	if cfg.EnrichEnabled {
+		ocr := enrich.NewTesseract(cfg.TesseractPath, cfg.OCRLanguages)
+		var captioner *enrich.OllamaCaptioner
+		if cfg.CaptionEnabled {
+			captioner = enrich.NewOllamaCaptioner(cfg.OllamaURL, cfg.OllamaVisionModel, cfg.OllamaTimeout)
+		}
+		worker := enrich.NewWorker(dbStore, minioClient, ocr, captioner, cfg.EnrichInterval, cfg.EnrichMaxAttempts)
+		jobs.Go(func() { worker.Run(ctx) })
+		slog.Info("image enrichment worker started", "captions", cfg.CaptionEnabled)
+	}
+
+	// Start profile photo archiving worker
+	if cfg.AvatarEnabled {
+		worker := avatar.NewWorker(dbStore, minioClient, bot, cfg.AvatarInterval, cfg.AvatarRefresh)
+		jobs.Go(func() { worker.Run(ctx) })
+		slog.Info("profile photo worker started", "refresh", cfg.AvatarRefresh)
+	}
+
+	// Start retention worker
+	if cfg.RetentionEnabled {
+		worker := retention.NewWorker(dbStore, minioClient, cfg.RetentionInterval, cfg.RetentionBatchSize, cfg.RetentionDryRun)
+		jobs.Go(func() { worker.Run(ctx) })
+		slog.Info("retention worker started", "interval", cfg.RetentionInterval, "dry_run", cfg.RetentionDryRun)
+	}
+
+	// Retry media uploads lost to a restart or failed
+	jobs.Go(func() { h.RecoverUploads(ctx, cfg.UploadRetryInterval, cfg.UploadMaxAttempts) })
+
+	// Store the offset reached by updates that write nothing
+	jobs.Go(func() { h.SaveOffsets(ctx, offsetSaveInterval) })
+
+	// A webhook is registered with Telegram; long polling needs any webhook left
+	// over from webhook mode removed, or getUpdates is refused
+	var pollErr <-chan error
+	if hook, ok := poller.(*webhook.Poller); ok {
+		if err := hook.Register(bot); err != nil {
+			slog.Error("failed to register webhook", "error", err)
+			os.Exit(1)
+		}
+		pollErr = hook.Err()
+		slog.Info("webhook registered", "url", cfg.WebhookPublicURL)
+	} else if err := bot.RemoveWebhook(); err != nil {
+		slog.Warn("failed to remove webhook", "error", err)
+	}
+
 	// Start bot in goroutine
 	go func() {
 		slog.Info("bot starting to poll for updates")
 		bot.Start()
 	}()
 
-	// Wait for interrupt signal
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	// Wait for an interrupt signal, or for updates to stop arriving
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+	case err := <-pollErr:
+		slog.Error("update delivery failed", "error", err)
+		exitCode = 1
+	}
+	// A second signal exits immediately
+	stop()
 
-	slog.Info("shutting down bot...")
+	slog.Info("shutting down bot...", "drain_timeout", cfg.DrainTimeout)
 
-	// Stop bot
-	bot.Stop()
+	// Stop receiving updates, finish the queued ones, their commands and uploads, and wait
+	// for background jobs to stop
+	drained := make(chan struct{})
+	go func() {
+		bot.Stop()
+		updates.Close()
+		if err := h.SaveOffset(workCtx); err != nil {
+			slog.Error("failed to save update offset", "error", err)
+		}
+		commands.Close()
+		uploads.Close()
+		jobs.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		slog.Info("in-flight work finished")
+	case <-time.After(cfg.DrainTimeout):
+		slog.Warn("drain deadline passed, cancelling in-flight work")
+		abortWork()
+	}
 
+	// Closing the database waits for queries still running
+	if err := dbStore.Close(); err != nil {
+		slog.Error("failed to close database", "error", err)
+	}
+	if metricsServer != nil {
+		metricsServer.Shutdown()
+	}
+	if shutdownTracing != nil {
+		// Flush the spans of the drained updates
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := shutdownTracing(flushCtx); err != nil {
+			slog.Warn("failed to flush traces", "error", err)
+		}
+		cancel()
+	}
+
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
 	slog.Info("bot stopped gracefully")
 }
 
+// onError counts and logs the errors returned by handlers. Errors outside of a
+// handler, such as failed getUpdates calls, come without a context.
+func onError(err error, c tele.Context) {
+	if c == nil {
+		slog.Error("bot error", "error", err)
+		return
+	}
+
+	u := c.Update()
+	updateType := dispatch.UpdateType(&u)
+	metrics.HandlerErrors.WithLabelValues(updateType).Inc()
+	slog.Error("handler failed", "error", err, "update_id", u.ID, "type", updateType)
+}
+
+// setupTracing exports traces to the OTLP collector and to New Relic, whichever
+// are enabled. It returns a nil shutdown function if neither is.
+func setupTracing(ctx context.Context, cfg *config.Config) (func(context.Context) error, error) {
+	serviceName := cfg.TracingServiceName
+	var exporters []tracing.Exporter
+	if cfg.TracingEnabled {
+		exporters = append(exporters, tracing.Exporter{
+			Name:     "otlp",
+			Endpoint: cfg.OTLPEndpoint,
+			Insecure: cfg.OTLPInsecure,
+		})
+	}
+	if cfg.NewRelicEnabled {
+		if cfg.NewRelicLicenseKey == "" {
+			return nil, errors.New("NEW_RELIC_LICENSE_KEY is required when New Relic is enabled")
+		}
+		exporters = append(exporters, tracing.Exporter{
+			Name:     "new relic",
+			Endpoint: cfg.NewRelicOTLPEndpoint,
+			Headers:  map[string]string{"api-key": cfg.NewRelicLicenseKey},
+		})
+		// New Relic names the service after its app
+		serviceName = cfg.NewRelicAppName
+	}
+	if len(exporters) == 0 {
+		return nil, nil
+	}
+
+	shutdown, err := tracing.Setup(ctx, serviceName, cfg.Environment, cfg.TracingSampleRatio, exporters)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("tracing enabled", "service", serviceName, "exporters", len(exporters))
+	return shutdown, nil
+}
+
+// newPoller returns the source of updates selected by UPDATES_MODE. Either only
+// confirms updates to Telegram once offsets has them processed.
+func newPoller(cfg *config.Config, offsets *dispatch.Offsets) (tele.Poller, error) {
+	switch cfg.UpdatesMode {
+	case "polling":
+		return dispatch.NewLongPoller(10*time.Second, allowedUpdates, offsets), nil
+	case "webhook":
+		hook, err := webhook.NewPoller(cfg.WebhookListen, cfg.WebhookPublicURL, cfg.WebhookSecretToken,
+			cfg.WebhookCertPath, cfg.WebhookKeyPath, allowedUpdates, offsets)
+		if err != nil {
+			return nil, err
+		}
+		return hook, nil
+	default:
+		return nil, fmt.Errorf("unknown updates mode %q", cfg.UpdatesMode)
+	}
+}
+
+// newTranscriber creates the configured speech-to-text backend
+func newTranscriber(cfg *config.Config) (transcribe.Transcriber, error) {
+	switch cfg.TranscribeBackend {
+	case "server":
+		return transcribe.NewWhisperServer(cfg.WhisperServerURL, cfg.TranscribeLanguage, cfg.WhisperTimeout), nil
+	case "cli":
+		return transcribe.NewWhisperCLI(cfg.WhisperCLIPath, cfg.WhisperModelPath, cfg.FFmpegPath, cfg.TranscribeLanguage), nil
+	default:
+		return nil, fmt.Errorf("unknown transcription backend %q", cfg.TranscribeBackend)
+	}
+}
+
+// registerCommands routes each command to its handler and publishes the command list
+// to Telegram. Admin commands are only listed in the menu of chat admins.
+func registerCommands(bot *tele.Bot, commands []handler.Command) {
+	var menu, adminMenu []tele.Command
+	for _, cmd := range commands {
+		bot.Handle("/"+cmd.Name, cmd.Handler)
+		item := tele.Command{Text: cmd.Name, Description: cmd.Description}
+		if cmd.Access == handler.AccessEveryone {
+			menu = append(menu, item)
+		}
+		adminMenu = append(adminMenu, item)
+	}
+
+	if err := bot.SetCommands(menu); err != nil {
+		slog.Warn("failed to publish command list", "error", err)
+	}
+	if err := bot.SetCommands(adminMenu, tele.CommandScope{Type: tele.CommandScopeAllChatAdmin}); err != nil {
+		slog.Warn("failed to publish admin command list", "error", err)
+	}
+}
+
 func setupLogger(cfg *config.Config) {
 	var level slog.Level
 	switch cfg.LogLevel {
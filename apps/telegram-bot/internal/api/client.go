@@ -0,0 +1,196 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// pollInterval is how often a pending job is checked
+const pollInterval = 2 * time.Second
+
+// Job statuses reported by the api-service
+const (
+	StatusPending = "pending"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Client talks to the api-service, which forwards LLM work to the llm-analyzer
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewClient(baseURL string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// SummaryRequest asks for a catch-up of a chat over a time window
+type SummaryRequest struct {
+	ChatID int64 `json:"chat_id"`
+	// ChatIDs are all ids of the chat, including those it had before being upgraded to a supergroup
+	ChatIDs []int64   `json:"chat_ids"`
+	Since   time.Time `json:"since"`
+	Until   time.Time `json:"until"`
+	// MessageThreadID restricts the summary to one forum topic
+	MessageThreadID *int64 `json:"message_thread_id,omitempty"`
+	// FromMessageID is set when the summary starts at a specific message
+	FromMessageID *int64 `json:"from_message_id,omitempty"`
+}
+
+// Job is the state of an asynchronous LLM job on the api-service
+type Job struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (j *Job) job() *Job { return j }
+
+// SummaryJob is a summary job and, once done, its result
+type SummaryJob struct {
+	Job
+	Summary string `json:"summary,omitempty"`
+}
+
+// AnswerRequest asks a question about a chat, with the retrieved messages as context
+type AnswerRequest struct {
+	ChatID  int64   `json:"chat_id"`
+	ChatIDs []int64 `json:"chat_ids"`
+	// MessageThreadID is set when the question was asked in a forum topic
+	MessageThreadID *int64           `json:"message_thread_id,omitempty"`
+	Question        string           `json:"question"`
+	Context         []ContextMessage `json:"context"`
+}
+
+// ContextMessage is a chat message handed to the LLM as context
+type ContextMessage struct {
+	ChatID    int64     `json:"chat_id"`
+	MessageID int64     `json:"message_id"`
+	Date      time.Time `json:"date"`
+	Author    string    `json:"author"`
+	Text      string    `json:"text"`
+}
+
+// MessageRef identifies a message by the chat id it was posted under and its
+// Telegram id, which is only unique within that chat
+type MessageRef struct {
+	ChatID    int64 `json:"chat_id"`
+	MessageID int64 `json:"message_id"`
+}
+
+// AnswerJob is a question-answering job and, once done, its result
+type AnswerJob struct {
+	Job
+	Answer string `json:"answer,omitempty"`
+	// CitedMessages are the context messages the answer is based on
+	CitedMessages []MessageRef `json:"cited_messages,omitempty"`
+}
+
+// RequestSummary starts a summary job (POST /api/v1/summaries)
+func (c *Client) RequestSummary(ctx context.Context, req *SummaryRequest) (*SummaryJob, error) {
+	var job SummaryJob
+	if err := c.do(ctx, http.MethodPost, "/api/v1/summaries", req, &job); err != nil {
+		return nil, fmt.Errorf("failed to request summary: %w", err)
+	}
+	return &job, nil
+}
+
+// Summarize requests a summary and waits until it is ready or ctx is done
+func (c *Client) Summarize(ctx context.Context, req *SummaryRequest) (string, error) {
+	job, err := c.RequestSummary(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if err := c.await(ctx, "/api/v1/summaries/", job); err != nil {
+		return "", fmt.Errorf("failed to get summary: %w", err)
+	}
+	return job.Summary, nil
+}
+
+// Ask starts a question-answering job (POST /api/v1/answers) and waits for the answer
+func (c *Client) Ask(ctx context.Context, req *AnswerRequest) (*AnswerJob, error) {
+	var job AnswerJob
+	if err := c.do(ctx, http.MethodPost, "/api/v1/answers", req, &job); err != nil {
+		return nil, fmt.Errorf("failed to request answer: %w", err)
+	}
+	if err := c.await(ctx, "/api/v1/answers/", &job); err != nil {
+		return nil, fmt.Errorf("failed to get answer: %w", err)
+	}
+	return &job, nil
+}
+
+// await polls GET {path}{id} into job until it is no longer pending or ctx is done
+func (c *Client) await(ctx context.Context, path string, job interface{ job() *Job }) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		state := job.job()
+		switch state.Status {
+		case StatusDone:
+			return nil
+		case StatusFailed:
+			return fmt.Errorf("job %s failed: %s", state.ID, state.Error)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		if err := c.do(ctx, http.MethodGet, path+state.ID, nil, job); err != nil {
+			return err
+		}
+	}
+}
+
+// do sends a JSON request and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: unexpected status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
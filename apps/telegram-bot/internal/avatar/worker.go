@@ -0,0 +1,175 @@
+package avatar
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/batch"
+	"beef-briefing/apps/telegram-bot/internal/storage"
+	"beef-briefing/apps/telegram-bot/internal/store"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// batchSize is how many users are checked per polling round
+const batchSize = 20
+
+// Worker archives the profile photos of known users in MinIO. Each user is
+// checked again once refresh has passed; a photo is only downloaded when it
+// changed and the media settings of the user's chats allow it, and identical
+// photos share one blob.
+type Worker struct {
+	store       *store.PostgresStore
+	minioClient *storage.MinIOClient
+	bot         *tele.Bot
+	interval    time.Duration
+	refresh     time.Duration
+}
+
+func NewWorker(store *store.PostgresStore, minioClient *storage.MinIOClient, bot *tele.Bot, interval, refresh time.Duration) *Worker {
+	return &Worker{
+		store:       store,
+		minioClient: minioClient,
+		bot:         bot,
+		interval:    interval,
+		refresh:     refresh,
+	}
+}
+
+// Run checks users' profile photos until ctx is cancelled
+func (w *Worker) Run(ctx context.Context) {
+	batch.Run(ctx, w.interval, batchSize, w.processBatch)
+}
+
+// processBatch checks one batch of users and returns how many were picked up
+// and how many of those were recorded as checked
+func (w *Worker) processBatch(ctx context.Context) (picked, done int) {
+	checks, err := w.store.ListAvatarChecks(ctx, time.Now().Add(-w.refresh), batchSize)
+	if err != nil {
+		slog.Error("failed to list avatar checks", "error", err)
+		return 0, 0
+	}
+
+	for _, check := range checks {
+		if ctx.Err() != nil {
+			break
+		}
+		if w.process(ctx, check) {
+			done++
+		}
+	}
+	return len(checks), done
+}
+
+// process archives the current profile photo of a user and reports whether the
+// check was recorded. Failures are logged and the user is retried after the
+// refresh period, so one failing user cannot stall the queue.
+func (w *Worker) process(ctx context.Context, check *store.AvatarCheck) bool {
+	now := time.Now()
+
+	// Users only in chats that don't store media keep no avatar
+	if !check.Allowed {
+		return w.markChecked(ctx, check.UserID, false, now)
+	}
+
+	photos, err := w.bot.ProfilePhotosOf(&tele.User{ID: check.UserID})
+	if err != nil {
+		slog.Warn("failed to get profile photos", "error", err, "user_id", check.UserID)
+		return w.markChecked(ctx, check.UserID, false, now)
+	}
+
+	// Users without a photo, or hiding it from the bot
+	if len(photos) == 0 {
+		return w.markChecked(ctx, check.UserID, check.FileUniqueID != nil, now)
+	}
+
+	// The current photo comes first, in its largest size
+	photo := photos[0]
+	if check.FileUniqueID != nil && *check.FileUniqueID == photo.UniqueID {
+		return w.markChecked(ctx, check.UserID, false, now)
+	}
+
+	// The user may have opted out, or their chats stopped storing media, since
+	// the batch was listed
+	policy, err := w.store.GetAvatarPolicy(ctx, check.UserID)
+	if err != nil {
+		slog.Error("failed to check avatar policy", "error", err, "user_id", check.UserID)
+		return false
+	}
+	if !policy.Permits(photo.FileSize) {
+		return w.markChecked(ctx, check.UserID, false, now)
+	}
+
+	reader, err := w.bot.File(&photo.File)
+	if err != nil {
+		slog.Warn("failed to download profile photo", "error", err, "user_id", check.UserID)
+		return w.markChecked(ctx, check.UserID, false, now)
+	}
+	defer reader.Close()
+
+	hash, data, err := storage.ComputeSHA256(reader)
+	if err != nil {
+		slog.Warn("failed to download profile photo", "error", err, "user_id", check.UserID)
+		return w.markChecked(ctx, check.UserID, false, now)
+	}
+
+	avatar := &store.UserAvatar{
+		UserID:       check.UserID,
+		FileUniqueID: photo.UniqueID,
+		MediaSHA256:  hash,
+		Width:        photo.Width,
+		Height:       photo.Height,
+	}
+
+	// The policy is checked again with the user locked, so an opt-out is either
+	// committed before and seen here, or erases the avatar saved here. The blob
+	// stays locked from the upload until the avatar references it, so the file
+	// can't be deleted as unreferenced in between.
+	uploaded, permitted := true, true
+	err = w.store.WithTx(ctx, func(tx *store.Tx) error {
+		if err := tx.LockUser(ctx, check.UserID); err != nil {
+			return err
+		}
+		policy, err := tx.GetAvatarPolicy(ctx, check.UserID)
+		if err != nil {
+			return err
+		}
+		if !policy.Permits(int64(len(data))) {
+			permitted = false
+			return nil
+		}
+
+		if _, err := tx.LockBlob(ctx, hash); err != nil {
+			return err
+		}
+		if err := w.minioClient.UploadFileWithHash(ctx, hash, data, "image/jpeg"); err != nil {
+			slog.Error("failed to upload profile photo", "error", err, "user_id", check.UserID)
+			uploaded = false
+			return err
+		}
+		return tx.SaveUserAvatar(ctx, avatar, now)
+	})
+	if !uploaded {
+		return w.markChecked(ctx, check.UserID, false, now)
+	}
+	if err != nil {
+		slog.Error("failed to save user avatar", "error", err, "user_id", check.UserID)
+		return false
+	}
+	if !permitted {
+		return w.markChecked(ctx, check.UserID, false, now)
+	}
+
+	slog.Info("profile photo archived", "user_id", check.UserID, "hash", hash)
+	return true
+}
+
+// markChecked records that a user was checked and reports whether it was
+func (w *Worker) markChecked(ctx context.Context, userID int64, removed bool, at time.Time) bool {
+	if err := w.store.MarkAvatarChecked(ctx, userID, removed, at); err != nil {
+		slog.Error("failed to mark avatar checked", "error", err, "user_id", userID)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package batch
+
+import (
+	"context"
+	"time"
+)
+
+// Run calls process on start and then every interval until ctx is cancelled.
+// process handles one batch of at most size jobs and returns how many it picked
+// up and how many of those it got through, successfully or by recording a failure.
+//
+// A full batch usually means there is a backlog, so the next one follows right
+// away. Jobs that could not be recorded come back in the next batch, so once a
+// batch gets through none of its jobs, Run waits for the next tick instead of
+// picking the same jobs up again.
+func Run(ctx context.Context, interval time.Duration, size int, process func(context.Context) (picked, done int)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			picked, done := process(ctx)
+			if picked < size || done == 0 {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
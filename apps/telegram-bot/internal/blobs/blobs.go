@@ -0,0 +1,168 @@
+package blobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/storage"
+	"beef-briefing/apps/telegram-bot/internal/store"
+)
+
+const (
+	// sweepBatchSize is how many unreferenced blobs are listed at a time
+	sweepBatchSize = 500
+
+	// orphanBatchSize is how many listed files are looked up at a time
+	orphanBatchSize = 1000
+)
+
+// Delete removes unreferenced media files from MinIO, then their blob rows. It
+// returns how many were deleted and the last error; files that could not be
+// deleted keep their row and are left to the garbage collector, and files
+// referenced again in the meantime are kept.
+func Delete(ctx context.Context, st *store.PostgresStore, mc *storage.MinIOClient, hashes []string) (int, error) {
+	deleted := 0
+	var lastErr error
+	for _, hash := range hashes {
+		ok, err := st.DeleteBlob(ctx, hash, mc.DeleteFile)
+		if err != nil {
+			slog.Warn("failed to delete media file", "error", err, "hash", hash)
+			lastErr = err
+			continue
+		}
+		if ok {
+			deleted++
+		}
+	}
+	return deleted, lastErr
+}
+
+// Options controls a garbage collection run
+type Options struct {
+	// MinAge is how long a blob must have been unreferenced, or an orphan in the
+	// bucket, before it is deleted. It covers uploads whose reference is not stored yet.
+	MinAge time.Duration
+	// DeleteOrphans deletes files that have no blob row, rather than only reporting them
+	DeleteOrphans bool
+	// DryRun only reports what would be deleted
+	DryRun bool
+}
+
+// Report is what a garbage collection run found and deleted
+type Report struct {
+	Unreferenced      int   // blobs nothing references
+	UnreferencedBytes int64 // their size, where known
+	Deleted           int   // unreferenced blobs deleted
+	Orphans           int   // files in the bucket without a blob row
+	OrphanBytes       int64
+	OrphansDeleted    int
+	Failed            int // files that could not be deleted
+}
+
+// Collect sweeps unreferenced blobs from MinIO, then lists the bucket for orphaned
+// files that no blob row accounts for
+func Collect(ctx context.Context, st *store.PostgresStore, mc *storage.MinIOClient, opts Options) (*Report, error) {
+	report := &Report{}
+	cutoff := time.Now().Add(-opts.MinAge)
+
+	if err := sweep(ctx, st, mc, opts, cutoff, report); err != nil {
+		return report, err
+	}
+	if err := findOrphans(ctx, st, mc, opts, cutoff, report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// sweep deletes the blobs unreferenced since before the cutoff
+func sweep(ctx context.Context, st *store.PostgresStore, mc *storage.MinIOClient, opts Options, cutoff time.Time, report *Report) error {
+	after := ""
+	for {
+		list, err := st.ListUnreferencedBlobs(ctx, cutoff, after, sweepBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			return nil
+		}
+		after = list[len(list)-1].SHA256
+
+		hashes := make([]string, 0, len(list))
+		for _, b := range list {
+			hashes = append(hashes, b.SHA256)
+			report.Unreferenced++
+			if b.Size != nil {
+				report.UnreferencedBytes += *b.Size
+			}
+			slog.Debug("unreferenced media file", "hash", b.SHA256, "unreferenced_since", b.UnreferencedSince)
+		}
+		if opts.DryRun {
+			continue
+		}
+
+		deleted, _ := Delete(ctx, st, mc, hashes)
+		report.Deleted += deleted
+		report.Failed += len(hashes) - deleted
+	}
+}
+
+// findOrphans lists the bucket and reports, or deletes, files older than the
+// cutoff that have no blob row
+func findOrphans(ctx context.Context, st *store.PostgresStore, mc *storage.MinIOClient, opts Options, cutoff time.Time, report *Report) error {
+	var hashes []string
+	var sizes []int64
+	flush := func() error {
+		if len(hashes) == 0 {
+			return nil
+		}
+		orphans, err := st.FindOrphans(ctx, hashes, sizes)
+		if err != nil {
+			return err
+		}
+
+		size := make(map[string]int64, len(hashes))
+		for i, hash := range hashes {
+			size[hash] = sizes[i]
+		}
+		for _, hash := range orphans {
+			report.Orphans++
+			report.OrphanBytes += size[hash]
+			slog.Info("orphaned media file", "hash", hash, "size", size[hash])
+
+			if !opts.DeleteOrphans || opts.DryRun {
+				continue
+			}
+			// Deleted under a blob row, so an upload of the same file waits for it
+			deleted, err := st.DeleteBlob(ctx, hash, mc.DeleteFile)
+			if err != nil {
+				slog.Warn("failed to delete orphaned media file", "error", err, "hash", hash)
+				report.Failed++
+				continue
+			}
+			if deleted {
+				report.OrphansDeleted++
+			}
+		}
+
+		hashes, sizes = hashes[:0], sizes[:0]
+		return nil
+	}
+
+	err := mc.ListFiles(ctx, func(f *storage.FileInfo) error {
+		// Files uploaded recently may not be referenced yet
+		if !f.LastModified.Before(cutoff) {
+			return nil
+		}
+		hashes = append(hashes, f.Hash)
+		sizes = append(sizes, f.Size)
+		if len(hashes) < orphanBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
@@ -11,6 +12,28 @@ type Config struct {
 	// Telegram Bot Configuration
 	TelegramBotToken string `envconfig:"TELEGRAM_BOT_TOKEN" required:"true"`
 
+	// Update Delivery Configuration
+	UpdatesMode        string `envconfig:"UPDATES_MODE" default:"polling"` // "polling" or "webhook"
+	WebhookListen      string `envconfig:"WEBHOOK_LISTEN" default:":8443"`
+	WebhookPublicURL   string `envconfig:"WEBHOOK_PUBLIC_URL"`
+	WebhookSecretToken string `envconfig:"WEBHOOK_SECRET_TOKEN"`
+	WebhookCertPath    string `envconfig:"WEBHOOK_CERT_PATH"`
+	WebhookKeyPath     string `envconfig:"WEBHOOK_KEY_PATH"`
+
+	// Update Processing Configuration
+	UpdateWorkers   int `envconfig:"UPDATE_WORKERS" default:"8"`
+	UpdateQueueSize int `envconfig:"UPDATE_QUEUE_SIZE" default:"100"`
+	UploadWorkers   int `envconfig:"UPLOAD_WORKERS" default:"4"`
+	UploadQueueSize int `envconfig:"UPLOAD_QUEUE_SIZE" default:"100"`
+	// Media uploads lost to a restart, or failed, are retried after this long
+	UploadRetryInterval time.Duration `envconfig:"UPLOAD_RETRY_INTERVAL" default:"10m"`
+	UploadMaxAttempts   int           `envconfig:"UPLOAD_MAX_ATTEMPTS" default:"5"`
+	// Slow commands (/summary, /ask, /export, /forgetme) run on their own workers
+	CommandWorkers   int `envconfig:"COMMAND_WORKERS" default:"4"`
+	CommandQueueSize int `envconfig:"COMMAND_QUEUE_SIZE" default:"20"`
+	// How long shutdown waits for in-flight updates and uploads before cancelling them
+	DrainTimeout time.Duration `envconfig:"DRAIN_TIMEOUT" default:"30s"`
+
 	// Database Configuration
 	DBHost     string `envconfig:"DB_HOST" default:"localhost"`
 	DBPort     int    `envconfig:"DB_PORT" default:"5432"`
@@ -26,14 +49,76 @@ type Config struct {
 	MinIOBucket    string `envconfig:"MINIO_BUCKET" default:"telegram-media"`
 	MinIOUseSSL    bool   `envconfig:"MINIO_USE_SSL" default:"false"`
 
+	// API Service Configuration
+	APIServiceURL     string        `envconfig:"API_SERVICE_URL" default:"http://localhost:8080"`
+	APIServiceTimeout time.Duration `envconfig:"API_SERVICE_TIMEOUT" default:"30s"`
+
+	// Command Configuration
+	OwnerUserIDs    []int64       `envconfig:"OWNER_USER_IDS"` // users who may allow or deny chats, comma-separated
+	SummaryCooldown time.Duration `envconfig:"SUMMARY_COOLDOWN" default:"10m"`
+	AskCooldown     time.Duration `envconfig:"ASK_COOLDOWN" default:"1m"`
+	ExportCooldown  time.Duration `envconfig:"EXPORT_COOLDOWN" default:"1h"`
+	AdminCacheTTL   time.Duration `envconfig:"ADMIN_CACHE_TTL" default:"10m"` // how long chat admins are cached
+
+	// Briefing Publishing Configuration
+	BriefingEnabled  bool   `envconfig:"BRIEFING_ENABLED" default:"true"`
+	BriefingPostTime string `envconfig:"BRIEFING_POST_TIME" default:"08:00"`
+	BriefingTimezone string `envconfig:"BRIEFING_TIMEZONE" default:"America/Sao_Paulo"`
+
+	// Transcription Configuration
+	TranscribeEnabled     bool          `envconfig:"TRANSCRIBE_ENABLED" default:"false"`
+	TranscribeBackend     string        `envconfig:"TRANSCRIBE_BACKEND" default:"server"` // "server" or "cli"
+	TranscribeLanguage    string        `envconfig:"TRANSCRIBE_LANGUAGE" default:"auto"`
+	TranscribeInterval    time.Duration `envconfig:"TRANSCRIBE_INTERVAL" default:"30s"`
+	TranscribeMaxAttempts int           `envconfig:"TRANSCRIBE_MAX_ATTEMPTS" default:"3"`
+	WhisperServerURL      string        `envconfig:"WHISPER_SERVER_URL" default:"http://localhost:8081"`
+	WhisperTimeout        time.Duration `envconfig:"WHISPER_TIMEOUT" default:"10m"`
+	WhisperCLIPath        string        `envconfig:"WHISPER_CLI_PATH" default:"whisper-cli"`
+	WhisperModelPath      string        `envconfig:"WHISPER_MODEL_PATH" default:"models/ggml-base.bin"`
+	FFmpegPath            string        `envconfig:"FFMPEG_PATH" default:"ffmpeg"`
+
+	// Image Enrichment Configuration (OCR and captions)
+	EnrichEnabled     bool          `envconfig:"ENRICH_ENABLED" default:"false"`
+	EnrichInterval    time.Duration `envconfig:"ENRICH_INTERVAL" default:"30s"`
+	EnrichMaxAttempts int           `envconfig:"ENRICH_MAX_ATTEMPTS" default:"3"`
+	TesseractPath     string        `envconfig:"TESSERACT_PATH" default:"tesseract"`
+	OCRLanguages      string        `envconfig:"OCR_LANGUAGES" default:"eng+por"`
+	CaptionEnabled    bool          `envconfig:"CAPTION_ENABLED" default:"false"`
+	OllamaURL         string        `envconfig:"OLLAMA_URL" default:"http://localhost:11434"`
+	OllamaVisionModel string        `envconfig:"OLLAMA_VISION_MODEL" default:"llava"`
+	OllamaTimeout     time.Duration `envconfig:"OLLAMA_TIMEOUT" default:"5m"`
+
+	// Profile Photo Archiving Configuration
+	AvatarEnabled  bool          `envconfig:"AVATAR_ENABLED" default:"false"`
+	AvatarInterval time.Duration `envconfig:"AVATAR_INTERVAL" default:"1m"`
+	AvatarRefresh  time.Duration `envconfig:"AVATAR_REFRESH" default:"168h"`
+
+	// Retention Configuration (per-chat retention is set with /settings)
+	RetentionEnabled   bool          `envconfig:"RETENTION_ENABLED" default:"false"`
+	RetentionInterval  time.Duration `envconfig:"RETENTION_INTERVAL" default:"24h"`
+	RetentionBatchSize int           `envconfig:"RETENTION_BATCH_SIZE" default:"1000"` // rows purged per transaction
+	RetentionDryRun    bool          `envconfig:"RETENTION_DRY_RUN" default:"false"`   // only log what would be purged
+
+	// Metrics and Health Check Configuration
+	MetricsEnabled bool   `envconfig:"METRICS_ENABLED" default:"true"`
+	MetricsListen  string `envconfig:"METRICS_LISTEN" default:":9090"` // serves /metrics, /healthz and /readyz
+
+	// Tracing Configuration (OpenTelemetry, exported over OTLP/HTTP)
+	TracingEnabled     bool    `envconfig:"TRACING_ENABLED" default:"false"` // export to OTLP_ENDPOINT, e.g. a local collector
+	TracingServiceName string  `envconfig:"TRACING_SERVICE_NAME" default:"beef-briefing-telegram-bot"`
+	TracingSampleRatio float64 `envconfig:"TRACING_SAMPLE_RATIO" default:"1"`
+	OTLPEndpoint       string  `envconfig:"OTLP_ENDPOINT" default:"localhost:4318"` // host:port
+	OTLPInsecure       bool    `envconfig:"OTLP_INSECURE" default:"true"`
+
 	// Application Configuration
 	Environment string `envconfig:"ENVIRONMENT" default:"development"`
 	LogLevel    string `envconfig:"LOG_LEVEL" default:"info"`
 
-	// New Relic Configuration (optional)
-	NewRelicLicenseKey string `envconfig:"NEW_RELIC_LICENSE_KEY"`
-	NewRelicAppName    string `envconfig:"NEW_RELIC_APP_NAME" default:"beef-briefing-telegram-bot"`
-	NewRelicEnabled    bool   `envconfig:"NEW_RELIC_ENABLED" default:"false"`
+	// New Relic Configuration (optional, traces are sent to its OTLP endpoint)
+	NewRelicLicenseKey   string `envconfig:"NEW_RELIC_LICENSE_KEY"`
+	NewRelicAppName      string `envconfig:"NEW_RELIC_APP_NAME" default:"beef-briefing-telegram-bot"`
+	NewRelicEnabled      bool   `envconfig:"NEW_RELIC_ENABLED" default:"false"`
+	NewRelicOTLPEndpoint string `envconfig:"NEW_RELIC_OTLP_ENDPOINT" default:"otlp.nr-data.net:4318"` // otlp.eu01.nr-data.net:4318 for EU accounts
 }
 
 func (c *Config) DSN() string {
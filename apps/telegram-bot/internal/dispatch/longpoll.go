@@ -0,0 +1,134 @@
+package dispatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// retryInterval is how long the long poller waits after a failed getUpdates call
+	retryInterval = 3 * time.Second
+
+	// batchLimit is the most updates a getUpdates call returns
+	batchLimit = 100
+)
+
+// LongPoller fetches updates with getUpdates. Unlike telebot's LongPoller, which
+// confirms each update to Telegram as soon as it is received, it asks for the
+// updates after the ones processed (Offsets.Processed), so Telegram keeps every
+// update still queued or being processed and delivers it again after a crash.
+//
+// Updates still in flight are delivered again by every call, and are not passed
+// on twice. When a call brings nothing new, the poller waits for an update to be
+// done before calling again.
+//
+// A call returns at most batchLimit updates, so an update that is slow or being
+// retried would stop new updates coming in for every chat once that many
+// followed it. The poller then asks for the updates after the last one received
+// instead, which confirms those in flight to Telegram: until they are processed,
+// they are only as safe as the process.
+type LongPoller struct {
+	timeout        time.Duration
+	allowedUpdates []string
+	offsets        *Offsets
+}
+
+// NewLongPoller returns a poller whose getUpdates calls wait up to timeout for updates
+func NewLongPoller(timeout time.Duration, allowedUpdates []string, offsets *Offsets) *LongPoller {
+	return &LongPoller{
+		timeout:        timeout,
+		allowedUpdates: allowedUpdates,
+		offsets:        offsets,
+	}
+}
+
+// Poll fetches updates until stop is closed
+func (p *LongPoller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	// Updates up to sent were passed on, in this run or, up to the stored offset, a previous one
+	sent := p.offsets.Processed()
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		// Taken before the call, so an update done during it is not missed
+		changed := p.offsets.Changed()
+		updates, err := p.getUpdates(b, p.offset())
+		if err != nil {
+			b.OnError(err, nil)
+			select {
+			case <-stop:
+				return
+			case <-time.After(retryInterval):
+			}
+			continue
+		}
+
+		fresh := false
+		for _, u := range updates {
+			if int64(u.ID) <= sent {
+				continue
+			}
+			select {
+			case dest <- u:
+			case <-stop:
+				return
+			}
+			sent = int64(u.ID)
+			fresh = true
+		}
+		if fresh || len(updates) == 0 {
+			continue
+		}
+
+		// Only updates in flight came back; asking again right away would return them again
+		select {
+		case <-stop:
+			return
+		case <-changed:
+		case <-time.After(p.timeout):
+		}
+	}
+}
+
+// offset returns the first update to ask Telegram for: the one after the
+// updates processed, unless the updates in flight would fill a whole call
+func (p *LongPoller) offset() int64 {
+	offset := p.offsets.Processed() + 1
+	if last := p.offsets.Last(); last-offset+1 >= batchLimit {
+		return last + 1
+	}
+	return offset
+}
+
+// getUpdates asks Telegram for the updates from offset on
+func (p *LongPoller) getUpdates(b *tele.Bot, offset int64) ([]tele.Update, error) {
+	allowed, err := json.Marshal(p.allowedUpdates)
+	if err != nil {
+		return nil, err
+	}
+	data, err := b.Raw("getUpdates", map[string]string{
+		"offset":          strconv.FormatInt(offset, 10),
+		"limit":           strconv.Itoa(batchLimit),
+		"timeout":         strconv.Itoa(int(p.timeout / time.Second)),
+		"allowed_updates": string(allowed),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Result []tele.Update
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode updates: %w", err)
+	}
+	return resp.Result, nil
+}
@@ -0,0 +1,155 @@
+package dispatch
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Offsets tracks the updates being processed, to know up to which update every
+// update has been processed. Updates of different chats finish out of order, so
+// that is below the oldest update still in flight rather than the last one done.
+// Telegram is only told an update was received once it is processed: the long
+// poller asks for the updates after Processed, and webhook requests are answered
+// once Await says so.
+type Offsets struct {
+	mu       sync.Mutex
+	start    int64   // offset stored when the bot started
+	last     int64   // highest update received
+	inFlight []int64 // updates received but not processed yet, ascending
+	waiters  map[int64][]chan struct{}
+	changed  chan struct{} // closed, and replaced, whenever an update is done
+}
+
+// NewOffsets tracks updates after start, the offset stored by a previous run
+func NewOffsets(start int64) *Offsets {
+	return &Offsets{
+		start:   start,
+		last:    start,
+		waiters: make(map[int64][]chan struct{}),
+		changed: make(chan struct{}),
+	}
+}
+
+// Begin records that an update was received. It reports false for updates that
+// were already processed before the bot started, or are being processed now.
+func (o *Offsets) Begin(id int64) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if id <= o.start {
+		o.release(id)
+		return false
+	}
+	i, found := slices.BinarySearch(o.inFlight, id)
+	if found {
+		return false
+	}
+	o.inFlight = slices.Insert(o.inFlight, i, id)
+	o.last = max(o.last, id)
+	return true
+}
+
+// Done records that an update was processed
+func (o *Offsets) Done(id int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if i, found := slices.BinarySearch(o.inFlight, id); found {
+		o.inFlight = slices.Delete(o.inFlight, i, i+1)
+	}
+	o.release(id)
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
+
+// Processed returns the update up to which every update received has been
+// processed: the one before the oldest update in flight, or the last one received
+func (o *Offsets) Processed() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.inFlight) > 0 {
+		return o.inFlight[0] - 1
+	}
+	return o.last
+}
+
+// Last returns the highest update received
+func (o *Offsets) Last() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.last
+}
+
+// WaitProcessed waits until every update up to id has been processed
+func (o *Offsets) WaitProcessed(ctx context.Context, id int64) error {
+	for {
+		changed := o.Changed()
+		if o.Processed() >= id {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Changed returns a channel closed once the next update is done
+func (o *Offsets) Changed() <-chan struct{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.changed
+}
+
+// Await returns a channel closed once the given update is processed, or found to
+// have been processed before the bot started. It is called before the update is
+// dispatched; cancel stops waiting.
+func (o *Offsets) Await(id int64) (done <-chan struct{}, cancel func()) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	ch := make(chan struct{})
+	if id <= o.start {
+		close(ch)
+		return ch, func() {}
+	}
+	o.waiters[id] = append(o.waiters[id], ch)
+
+	return ch, func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		if i := slices.Index(o.waiters[id], ch); i >= 0 {
+			o.waiters[id] = slices.Delete(o.waiters[id], i, i+1)
+		}
+		if len(o.waiters[id]) == 0 {
+			delete(o.waiters, id)
+		}
+	}
+}
+
+// release wakes those awaiting an update
+func (o *Offsets) release(id int64) {
+	for _, ch := range o.waiters[id] {
+		close(ch)
+	}
+	delete(o.waiters, id)
+}
+
+// Committable returns the offset reached once the given update is processed: the
+// update before the next one in flight, or the last one received. It returns 0
+// when an older update is still in flight, so processing this one reaches nothing new.
+func (o *Offsets) Committable(id int64) int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.inFlight) == 0 || o.inFlight[0] != id {
+		return 0
+	}
+	if len(o.inFlight) > 1 {
+		return o.inFlight[1] - 1
+	}
+	return o.last
+}
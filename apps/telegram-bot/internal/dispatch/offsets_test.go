@@ -0,0 +1,169 @@
+package dispatch
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOffsetsProcessed(t *testing.T) {
+	o := NewOffsets(10)
+	if got := o.Processed(); got != 10 {
+		t.Fatalf("Processed() before any update = %d, want 10", got)
+	}
+
+	for _, id := range []int64{11, 12, 13} {
+		if !o.Begin(id) {
+			t.Fatalf("Begin(%d) = false, want true", id)
+		}
+	}
+	if got := o.Processed(); got != 10 {
+		t.Errorf("Processed() with 11-13 in flight = %d, want 10", got)
+	}
+
+	// Updates of other chats finish out of order
+	o.Done(13)
+	if got := o.Processed(); got != 10 {
+		t.Errorf("Processed() after 13 = %d, want 10", got)
+	}
+	o.Done(11)
+	if got := o.Processed(); got != 11 {
+		t.Errorf("Processed() after 11 = %d, want 11", got)
+	}
+	o.Done(12)
+	if got := o.Processed(); got != 13 {
+		t.Errorf("Processed() after 12 = %d, want 13", got)
+	}
+	if got := o.Last(); got != 13 {
+		t.Errorf("Last() = %d, want 13", got)
+	}
+}
+
+func TestOffsetsBeginSkipsProcessed(t *testing.T) {
+	o := NewOffsets(10)
+	if o.Begin(10) {
+		t.Error("Begin(10) = true for an update processed before start")
+	}
+	if !o.Begin(11) {
+		t.Fatal("Begin(11) = false, want true")
+	}
+	if o.Begin(11) {
+		t.Error("Begin(11) = true for an update already in flight")
+	}
+}
+
+func TestOffsetsCommittable(t *testing.T) {
+	o := NewOffsets(0)
+	for _, id := range []int64{1, 2, 5} {
+		o.Begin(id)
+	}
+
+	tests := []struct {
+		id   int64
+		want int64
+	}{
+		{2, 0}, // 1 is older and still in flight
+		{5, 0},
+		{1, 1}, // 2 is next in flight
+	}
+	for _, tt := range tests {
+		if got := o.Committable(tt.id); got != tt.want {
+			t.Errorf("Committable(%d) = %d, want %d", tt.id, got, tt.want)
+		}
+	}
+
+	o.Done(1)
+	if got := o.Committable(2); got != 4 {
+		t.Errorf("Committable(2) after 1 = %d, want 4", got)
+	}
+	o.Done(2)
+	if got := o.Committable(5); got != 5 {
+		t.Errorf("Committable(5) as last in flight = %d, want 5", got)
+	}
+}
+
+func TestOffsetsAwait(t *testing.T) {
+	o := NewOffsets(10)
+
+	done, cancel := o.Await(9)
+	defer cancel()
+	select {
+	case <-done:
+	default:
+		t.Error("Await(9) not done for an update processed before start")
+	}
+
+	done, cancel = o.Await(11)
+	defer cancel()
+	o.Begin(11)
+	select {
+	case <-done:
+		t.Fatal("Await(11) done before the update was processed")
+	default:
+	}
+	o.Done(11)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Await(11) not done after the update was processed")
+	}
+}
+
+func TestOffsetsWaitProcessed(t *testing.T) {
+	o := NewOffsets(0)
+	o.Begin(1)
+	o.Begin(2)
+
+	waited := make(chan error, 1)
+	go func() { waited <- o.WaitProcessed(context.Background(), 2) }()
+
+	o.Done(2)
+	select {
+	case err := <-waited:
+		t.Fatalf("WaitProcessed(2) returned %v with 1 still in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	o.Done(1)
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Errorf("WaitProcessed(2) = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("WaitProcessed(2) still waiting after every update was processed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	o.Begin(3)
+	cancel()
+	if err := o.WaitProcessed(ctx, 3); err != context.Canceled {
+		t.Errorf("WaitProcessed with cancelled ctx = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestLongPollerOffset(t *testing.T) {
+	o := NewOffsets(0)
+	p := NewLongPoller(time.Second, nil, o)
+
+	o.Begin(1)
+	o.Begin(2)
+	if got := p.offset(); got != 1 {
+		t.Errorf("offset() with 1-2 in flight = %d, want 1", got)
+	}
+	o.Done(2)
+
+	// A stuck update would otherwise fill every call with updates in flight
+	for id := int64(3); id <= batchLimit; id++ {
+		o.Begin(id)
+		o.Done(id)
+	}
+	if got := p.offset(); got != batchLimit+1 {
+		t.Errorf("offset() with 1 stuck and %d received = %d, want %d", batchLimit, got, batchLimit+1)
+	}
+
+	o.Done(1)
+	if got := p.offset(); got != batchLimit+1 {
+		t.Errorf("offset() once processed = %d, want %d", got, batchLimit+1)
+	}
+}
@@ -0,0 +1,225 @@
+package dispatch
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/metrics"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// maxAttempts is how many times a failing update is processed before it is parked
+	maxAttempts = 5
+
+	// firstRetryDelay is the wait before the first retry, doubled after each
+	// attempt up to maxRetryDelay
+	firstRetryDelay = time.Second
+	maxRetryDelay   = 30 * time.Second
+)
+
+// Poller hands the updates of another poller to a sharded worker pool, keyed by
+// chat, so updates of one chat are processed in order while chats are processed
+// in parallel. The bot must be Synchronous for handlers to run on the pool's
+// workers. When the pool is full, updates stop being taken from the source
+// poller, which stops fetching (long polling) or answering (webhook) until
+// there is room again.
+//
+// Updates that were already processed (before a restart, or redelivered while
+// still in flight) are skipped, and offsets tracks which ones are done. An update
+// whose processing fails is retried on its worker, holding up the chat, and after
+// maxAttempts handed to park, which records it so the chat can move on. Only
+// then is it done.
+//
+// Poll and poll answer updates carry no chat. They are keyed by the chat the poll
+// was posted in, remembered from its message or looked up with pollChat, so they
+// are processed after the message that stores the poll.
+type Poller struct {
+	ctx      context.Context
+	poller   tele.Poller
+	pool     *ShardedPool
+	offsets  *Offsets
+	process  func(tele.Update) error
+	park     func(u tele.Update, cause error, attempts int) error
+	polls    *pollChats
+	pollChat func(pollID string) int64
+}
+
+// NewPoller dispatches the updates of poller to pool, where process handles them
+// and park records those that kept failing. pollChat returns the chat of a stored
+// poll, or 0 if it is not known. Once ctx is cancelled, failed updates are given
+// up on without being parked or done, so Telegram delivers them again.
+func NewPoller(ctx context.Context, poller tele.Poller, pool *ShardedPool, offsets *Offsets, process func(tele.Update) error, park func(u tele.Update, cause error, attempts int) error, pollChat func(pollID string) int64) *Poller {
+	return &Poller{
+		ctx:      ctx,
+		poller:   poller,
+		pool:     pool,
+		offsets:  offsets,
+		process:  process,
+		park:     park,
+		polls:    newPollChats(),
+		pollChat: pollChat,
+	}
+}
+
+// Poll dispatches updates until stop is closed. The updates the source poller
+// still delivers while stopping are dispatched too.
+func (p *Poller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	updates := make(chan tele.Update)
+	stopSource := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		p.poller.Poll(b, updates, stopSource)
+		close(done)
+	}()
+
+	for {
+		select {
+		case u := <-updates:
+			p.dispatch(u)
+		case <-stop:
+			close(stopSource)
+			for {
+				select {
+				case u := <-updates:
+					p.dispatch(u)
+				case <-done:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *Poller) dispatch(u tele.Update) {
+	if !p.offsets.Begin(int64(u.ID)) {
+		slog.Debug("update already processed", "update_id", u.ID)
+		return
+	}
+
+	key := p.key(&u)
+	metrics.Updates.WithLabelValues(UpdateType(&u)).Inc()
+	p.pool.Submit(key, func() {
+		if p.run(u) {
+			p.offsets.Done(int64(u.ID))
+		}
+	})
+}
+
+// run processes an update, retrying it while it fails, and parks it after
+// maxAttempts. It reports whether the update was processed or parked; it only
+// gives up, leaving the update in flight, once ctx is cancelled.
+func (p *Poller) run(u tele.Update) bool {
+	delay := firstRetryDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		if attempt <= maxAttempts {
+			if err = p.process(u); err == nil {
+				return true
+			}
+			slog.Warn("update failed", "error", err, "update_id", u.ID, "attempt", attempt)
+		} else {
+			parkErr := p.park(u, err, maxAttempts)
+			if parkErr == nil {
+				metrics.UpdatesParked.WithLabelValues(UpdateType(&u)).Inc()
+				slog.Error("update parked after repeated failures", "error", err, "update_id", u.ID, "attempts", maxAttempts)
+				return true
+			}
+			slog.Error("failed to park update", "error", parkErr, "update_id", u.ID)
+		}
+
+		select {
+		case <-p.ctx.Done():
+			slog.Warn("update given up on shutdown", "update_id", u.ID)
+			return false
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// key returns the key an update is dispatched by: its chat, or for poll updates
+// the chat of the poll. Other updates without a chat, and the updates of polls
+// whose chat is unknown, share key 0.
+func (p *Poller) key(u *tele.Update) int64 {
+	if chatID, ok := ChatID(u); ok {
+		for _, msg := range []*tele.Message{u.Message, u.ChannelPost} {
+			if msg != nil && msg.Poll != nil {
+				p.polls.add(msg.Poll.ID, chatID)
+			}
+		}
+		return chatID
+	}
+
+	var pollID string
+	switch {
+	case u.Poll != nil:
+		pollID = u.Poll.ID
+	case u.PollAnswer != nil:
+		pollID = u.PollAnswer.PollID
+	default:
+		return 0
+	}
+	if chatID, ok := p.polls.get(pollID); ok {
+		return chatID
+	}
+	chatID := p.pollChat(pollID)
+	if chatID != 0 {
+		p.polls.add(pollID, chatID)
+	}
+	return chatID
+}
+
+// ChatID returns the chat an update belongs to, if it belongs to one
+func ChatID(u *tele.Update) (int64, bool) {
+	var chat *tele.Chat
+	switch {
+	case u.Message != nil:
+		chat = u.Message.Chat
+	case u.EditedMessage != nil:
+		chat = u.EditedMessage.Chat
+	case u.ChannelPost != nil:
+		chat = u.ChannelPost.Chat
+	case u.EditedChannelPost != nil:
+		chat = u.EditedChannelPost.Chat
+	case u.ChatMember != nil:
+		chat = u.ChatMember.Chat
+	case u.MyChatMember != nil:
+		chat = u.MyChatMember.Chat
+	}
+	if chat == nil {
+		return 0, false
+	}
+	return chat.ID, true
+}
+
+// UpdateType returns the kind of an update, named like its field in the Bot API
+func UpdateType(u *tele.Update) string {
+	switch {
+	case u.Message != nil:
+		return "message"
+	case u.EditedMessage != nil:
+		return "edited_message"
+	case u.ChannelPost != nil:
+		return "channel_post"
+	case u.EditedChannelPost != nil:
+		return "edited_channel_post"
+	case u.Poll != nil:
+		return "poll"
+	case u.PollAnswer != nil:
+		return "poll_answer"
+	case u.ChatMember != nil:
+		return "chat_member"
+	case u.MyChatMember != nil:
+		return "my_chat_member"
+	case u.Callback != nil:
+		return "callback_query"
+	case u.Query != nil:
+		return "inline_query"
+	default:
+		return "other"
+	}
+}
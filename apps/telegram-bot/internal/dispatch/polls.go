@@ -0,0 +1,38 @@
+package dispatch
+
+// pollChatsSize bounds how many polls pollChats remembers; the chats of older
+// polls are looked up
+const pollChatsSize = 10000
+
+// pollChats remembers the chat of the polls most recently seen in messages.
+// Poll and poll answer updates carry no chat, and are dispatched by it instead.
+// It is only used from the dispatch loop.
+type pollChats struct {
+	chats map[string]int64
+	order []string // poll ids in the order they were added, as a ring
+	next  int      // where the next poll id goes once the ring is full
+}
+
+func newPollChats() *pollChats {
+	return &pollChats{chats: make(map[string]int64)}
+}
+
+// add remembers the chat of a poll, forgetting the oldest poll when full
+func (p *pollChats) add(pollID string, chatID int64) {
+	if _, ok := p.chats[pollID]; !ok {
+		if len(p.order) < pollChatsSize {
+			p.order = append(p.order, pollID)
+		} else {
+			delete(p.chats, p.order[p.next])
+			p.order[p.next] = pollID
+			p.next = (p.next + 1) % pollChatsSize
+		}
+	}
+	p.chats[pollID] = chatID
+}
+
+// get returns the chat of a poll, if it is remembered
+func (p *pollChats) get(pollID string) (int64, bool) {
+	chatID, ok := p.chats[pollID]
+	return chatID, ok
+}
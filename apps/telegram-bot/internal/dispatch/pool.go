@@ -0,0 +1,100 @@
+package dispatch
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// Pool runs tasks on a fixed number of workers. Submit blocks while the queue is
+// full, so a slow pool slows its producers down instead of growing without bound.
+type Pool struct {
+	name  string
+	tasks chan func()
+	wg    sync.WaitGroup
+}
+
+// NewPool starts workers that take tasks from a queue holding up to queueSize tasks
+func NewPool(name string, workers, queueSize int) *Pool {
+	p := &Pool{
+		name:  name,
+		tasks: make(chan func(), queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for task := range p.tasks {
+				task()
+			}
+		}()
+	}
+	return p
+}
+
+// Submit queues task, waiting while the queue is full
+func (p *Pool) Submit(task func()) {
+	select {
+	case p.tasks <- task:
+	default:
+		slog.Debug("queue full, waiting", "pool", p.name, "queued", len(p.tasks))
+		p.tasks <- task
+	}
+}
+
+// TrySubmit queues task unless the queue is full, and reports whether it did
+func (p *Pool) TrySubmit(task func()) bool {
+	select {
+	case p.tasks <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+// Len returns the number of tasks waiting in the queue
+func (p *Pool) Len() int {
+	return len(p.tasks)
+}
+
+// Close stops accepting tasks and waits until the queued ones are done
+func (p *Pool) Close() {
+	close(p.tasks)
+	p.wg.Wait()
+}
+
+// ShardedPool runs tasks on a fixed number of workers, each with its own queue.
+// Tasks submitted with the same key always run on the same worker, one after
+// another, in the order they were submitted.
+type ShardedPool struct {
+	shards []*Pool
+}
+
+// NewShardedPool starts workers, each with a queue holding up to queueSize tasks
+func NewShardedPool(name string, workers, queueSize int) *ShardedPool {
+	p := &ShardedPool{shards: make([]*Pool, workers)}
+	for i := range p.shards {
+		p.shards[i] = NewPool(name, 1, queueSize)
+	}
+	return p
+}
+
+// Submit queues task on the worker for key, waiting while that worker's queue is full
+func (p *ShardedPool) Submit(key int64, task func()) {
+	p.shards[uint64(key)%uint64(len(p.shards))].Submit(task)
+}
+
+// Len returns the number of tasks waiting in all queues
+func (p *ShardedPool) Len() int {
+	n := 0
+	for _, shard := range p.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Close stops accepting tasks and waits until the queued ones are done
+func (p *ShardedPool) Close() {
+	for _, shard := range p.shards {
+		shard.Close()
+	}
+}
@@ -0,0 +1,77 @@
+package dispatch
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestShardedPoolKeepsOrderPerKey(t *testing.T) {
+	const keys, tasks = 8, 200
+	p := NewShardedPool("test", 3, 4)
+
+	var mu sync.Mutex
+	ran := make(map[int64][]int)
+	for i := 0; i < tasks; i++ {
+		for key := int64(-keys / 2); key < keys/2; key++ {
+			key, i := key, i
+			p.Submit(key, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				ran[key] = append(ran[key], i)
+			})
+		}
+	}
+	p.Close()
+
+	for key := int64(-keys / 2); key < keys/2; key++ {
+		got := ran[key]
+		if len(got) != tasks {
+			t.Fatalf("key %d ran %d tasks, want %d", key, len(got), tasks)
+		}
+		for i, n := range got {
+			if n != i {
+				t.Fatalf("key %d ran task %d at position %d", key, n, i)
+			}
+		}
+	}
+}
+
+func TestPoolCloseWaitsForQueued(t *testing.T) {
+	p := NewPool("test", 2, 10)
+
+	var mu sync.Mutex
+	ran := 0
+	for i := 0; i < 50; i++ {
+		p.Submit(func() {
+			mu.Lock()
+			ran++
+			mu.Unlock()
+		})
+	}
+	p.Close()
+
+	if ran != 50 {
+		t.Errorf("ran %d tasks before Close returned, want 50", ran)
+	}
+}
+
+func TestPoolTrySubmitFull(t *testing.T) {
+	p := NewPool("test", 1, 1)
+	defer p.Close()
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	p.Submit(func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	if !p.TrySubmit(func() {}) {
+		t.Fatal("TrySubmit = false with room in the queue")
+	}
+	if p.TrySubmit(func() {}) {
+		t.Error("TrySubmit = true with the queue full")
+	}
+	close(block)
+}
@@ -0,0 +1,83 @@
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// captionPrompt asks the vision model for a short, searchable description
+const captionPrompt = "Describe this image in one or two sentences. " +
+	"Mention any visible text, prices, places or products. Reply with the description only."
+
+// OllamaCaptioner describes images with a local vision model served by Ollama
+type OllamaCaptioner struct {
+	url        string
+	model      string
+	httpClient *http.Client
+}
+
+func NewOllamaCaptioner(url, model string, timeout time.Duration) *OllamaCaptioner {
+	return &OllamaCaptioner{
+		url:        strings.TrimRight(url, "/"),
+		model:      model,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (o *OllamaCaptioner) Name() string {
+	return "ollama:" + o.model
+}
+
+type ollamaGenerateRequest struct {
+	Model  string   `json:"model"`
+	Prompt string   `json:"prompt"`
+	Images []string `json:"images"`
+	Stream bool     `json:"stream"`
+}
+
+type ollamaGenerateResponse struct {
+	Response string `json:"response"`
+}
+
+// Caption returns a description of an image (POST /api/generate)
+func (o *OllamaCaptioner) Caption(ctx context.Context, image []byte) (string, error) {
+	body, err := json.Marshal(ollamaGenerateRequest{
+		Model:  o.model,
+		Prompt: captionPrompt,
+		Images: []string{base64.StdEncoding.EncodeToString(image)},
+		Stream: false,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url+"/api/generate", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("ollama request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("ollama returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var out ollamaGenerateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("failed to decode ollama response: %w", err)
+	}
+	return strings.TrimSpace(out.Response), nil
+}
@@ -0,0 +1,41 @@
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Tesseract extracts text from images with the tesseract command line tool
+type Tesseract struct {
+	binary    string
+	languages string
+}
+
+// NewTesseract creates an OCR backend; languages uses tesseract syntax, e.g. "eng+por"
+func NewTesseract(binary, languages string) *Tesseract {
+	return &Tesseract{
+		binary:    binary,
+		languages: languages,
+	}
+}
+
+func (t *Tesseract) Name() string {
+	return "tesseract:" + t.languages
+}
+
+// ExtractText returns the text recognised in an image, or an empty string if there is none
+func (t *Tesseract) ExtractText(ctx context.Context, image []byte) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.binary, "stdin", "stdout", "-l", t.languages)
+	cmd.Stdin = bytes.NewReader(image)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("tesseract: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
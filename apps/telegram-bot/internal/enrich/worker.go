@@ -0,0 +1,129 @@
+package enrich
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/batch"
+	"beef-briefing/apps/telegram-bot/internal/storage"
+	"beef-briefing/apps/telegram-bot/internal/store"
+)
+
+// batchSize is how many blobs are picked up per polling round
+const batchSize = 10
+
+// Worker extracts text from stored photos and image documents in the background.
+// Results are keyed by blob hash, so an image forwarded many times is only
+// analysed once.
+type Worker struct {
+	store       *store.PostgresStore
+	minioClient *storage.MinIOClient
+	ocr         *Tesseract
+	captioner   *OllamaCaptioner // nil when captioning is disabled
+	interval    time.Duration
+	maxAttempts int
+}
+
+func NewWorker(store *store.PostgresStore, minioClient *storage.MinIOClient, ocr *Tesseract, captioner *OllamaCaptioner, interval time.Duration, maxAttempts int) *Worker {
+	return &Worker{
+		store:       store,
+		minioClient: minioClient,
+		ocr:         ocr,
+		captioner:   captioner,
+		interval:    interval,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// Run processes pending blobs until ctx is cancelled
+func (w *Worker) Run(ctx context.Context) {
+	batch.Run(ctx, w.interval, batchSize, w.processBatch)
+}
+
+// processBatch analyses one batch of pending blobs and returns how many were
+// picked up and how many of those were analysed or recorded as failed
+func (w *Worker) processBatch(ctx context.Context) (picked, done int) {
+	jobs, err := w.store.ListPendingEnrichments(ctx, w.maxAttempts, batchSize)
+	if err != nil {
+		slog.Error("failed to list pending enrichments", "error", err)
+		return 0, 0
+	}
+
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		if w.process(ctx, job) {
+			done++
+		}
+	}
+	return len(jobs), done
+}
+
+// process analyses one blob and reports whether the result or the failed
+// attempt was recorded
+func (w *Worker) process(ctx context.Context, job *store.MediaJob) bool {
+	start := time.Now()
+
+	data, err := w.minioClient.DownloadFile(ctx, job.MediaSHA256)
+	if err != nil {
+		return w.fail(ctx, job, err)
+	}
+
+	enrichment := &store.Enrichment{
+		MediaSHA256: job.MediaSHA256,
+		Model:       w.ocr.Name(),
+	}
+
+	text, err := w.ocr.ExtractText(ctx, data)
+	if err != nil {
+		return w.fail(ctx, job, err)
+	}
+	if text != "" {
+		enrichment.OCRText = &text
+	}
+
+	if w.captioner != nil {
+		caption, err := w.captioner.Caption(ctx, data)
+		if err != nil {
+			return w.fail(ctx, job, err)
+		}
+		if caption != "" {
+			enrichment.Caption = &caption
+		}
+		enrichment.Model = strings.Join([]string{w.ocr.Name(), w.captioner.Name()}, ",")
+	}
+
+	if err := w.store.SaveEnrichment(ctx, enrichment); err != nil {
+		slog.Error("failed to save enrichment", "error", err, "hash", job.MediaSHA256)
+		return false
+	}
+
+	slog.Info("media enriched",
+		"hash", job.MediaSHA256,
+		"ocr_chars", len(text),
+		"captioned", enrichment.Caption != nil,
+		"duration", time.Since(start))
+	return true
+}
+
+// fail records a failed attempt, unless the failure was caused by shutdown, and
+// reports whether it was recorded
+func (w *Worker) fail(ctx context.Context, job *store.MediaJob, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	slog.Warn("failed to enrich media",
+		"error", err,
+		"hash", job.MediaSHA256,
+		"attempt", job.Attempts+1)
+
+	if err := w.store.MarkEnrichmentFailed(ctx, job.MediaSHA256, err.Error()); err != nil {
+		slog.Error("failed to record enrichment failure", "error", err, "hash", job.MediaSHA256)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package format
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// MaxMessageLength is the Telegram limit for a single text message
+const MaxMessageLength = 4096
+
+// EscapeHTML escapes text for use with Telegram's HTML parse mode
+func EscapeHTML(s string) string {
+	return html.EscapeString(s)
+}
+
+// Link returns an HTML anchor with escaped label and URL
+func Link(url, label string) string {
+	return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(url), html.EscapeString(label))
+}
+
+// MessageLink returns a t.me deep link to a message in a supergroup or channel.
+// Basic groups have no message links, so an empty string is returned for them.
+func MessageLink(chatID, messageID int64) string {
+	id := strconv.FormatInt(chatID, 10)
+	if !strings.HasPrefix(id, "-100") {
+		return ""
+	}
+	return fmt.Sprintf("https://t.me/c/%s/%d", id[4:], messageID)
+}
+
+// Length returns the length of s as counted by Telegram (UTF-16 code units)
+func Length(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// SplitText splits plain text into pieces whose escaped form fits in limit.
+// It prefers to cut at line breaks, then at spaces, and only cuts inside a
+// word when there is no other choice. A piece always holds at least one rune,
+// so with a limit too small for a single rune pieces exceed it.
+func SplitText(text string, limit int) []string {
+	var pieces []string
+	for text != "" && Length(EscapeHTML(text)) > limit {
+		runes := []rune(text)
+
+		// Find the longest prefix that fits once escaped
+		cut, size := 0, 0
+		for cut < len(runes) {
+			n := Length(EscapeHTML(string(runes[cut])))
+			if size+n > limit {
+				break
+			}
+			size += n
+			cut++
+		}
+		cut = max(cut, 1)
+
+		prefix := string(runes[:cut])
+		if i := strings.LastIndex(prefix, "\n"); i > 0 {
+			prefix = prefix[:i]
+		} else if i := strings.LastIndex(prefix, " "); i > 0 {
+			prefix = prefix[:i]
+		}
+
+		if piece := strings.TrimSpace(prefix); piece != "" {
+			pieces = append(pieces, piece)
+		}
+		text = strings.TrimLeft(text[len(prefix):], " \n")
+	}
+	if strings.TrimSpace(text) != "" {
+		pieces = append(pieces, strings.TrimSpace(text))
+	}
+	return pieces
+}
+
+// TextBlocks escapes plain text into HTML blocks, one or more per paragraph,
+// each within limit. The result is ready to be passed to Pack.
+func TextBlocks(text string, limit int) []string {
+	var blocks []string
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		for _, piece := range SplitText(paragraph, limit) {
+			blocks = append(blocks, EscapeHTML(piece))
+		}
+	}
+	return blocks
+}
+
+// Pack joins HTML blocks into as few messages as possible, separating blocks
+// with a blank line and keeping every message within limit. Blocks must not
+// exceed limit themselves (use SplitText on their source text first).
+func Pack(blocks []string, limit int) []string {
+	var messages []string
+	var current strings.Builder
+	for _, block := range blocks {
+		if block == "" {
+			continue
+		}
+		if current.Len() > 0 && Length(current.String())+2+Length(block) > limit {
+			messages = append(messages, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(block)
+	}
+	if current.Len() > 0 {
+		messages = append(messages, current.String())
+	}
+	return messages
+}
@@ -0,0 +1,52 @@
+package format
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"fits", "hello world", 20, []string{"hello world"}},
+		{"cuts at space", "hello brave new world", 11, []string{"hello", "brave new", "world"}},
+		{"cuts at line break", "first line\nsecond", 14, []string{"first line", "second"}},
+		{"cuts inside word", "abcdefgh", 3, []string{"abc", "def", "gh"}},
+		{"counts escaped length", "a&b", 6, []string{"a&", "b"}},
+		{"rune longer than limit", "&&", 3, []string{"&", "&"}},
+		{"zero limit", "abc", 0, []string{"a", "b", "c"}},
+		{"negative limit", "ab", -10, []string{"a", "b"}},
+		{"spaces with negative limit", "a  b", -1, []string{"a", "b"}},
+		{"empty", "  ", 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitText(tt.text, tt.limit)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Errorf("SplitText(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitTextFitsLimit(t *testing.T) {
+	text := strings.Repeat("Churrasco <on> Saturday & bring the picanha. ", 300)
+	for _, piece := range SplitText(text, MaxMessageLength) {
+		if n := Length(EscapeHTML(piece)); n > MaxMessageLength {
+			t.Fatalf("piece of length %d exceeds limit %d", n, MaxMessageLength)
+		}
+	}
+}
+
+func TestPack(t *testing.T) {
+	got := Pack([]string{"aaaa", "", "bbbb", "cccc"}, 10)
+	want := []string{"aaaa\n\nbbbb", "cccc"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Pack() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// HandleStatus replies with the ingestion state and stats of the chat
+func (h *Handler) HandleStatus(c tele.Context) error {
+	msg := c.Message()
+	ctx := h.updateContext(c)
+
+	chatIDs, err := h.store.ResolveChatIDs(ctx, msg.Chat.ID)
+	if err != nil {
+		slog.Error("failed to resolve chat ids", "error", err, "chat_id", msg.Chat.ID)
+		return c.Reply("Failed to get the status. Please try again later.")
+	}
+	stats, err := h.store.GetChatStats(ctx, chatIDs)
+	if err != nil {
+		slog.Error("failed to get chat stats", "error", err, "chat_id", msg.Chat.ID)
+		return c.Reply("Failed to get the status. Please try again later.")
+	}
+
+	cs := h.policies.Get(msg.Chat.ID)
+	var b strings.Builder
+	switch {
+	case !cs.Allowed:
+		b.WriteString("Ingestion: not allowed\n")
+	case cs.Paused:
+		b.WriteString("Ingestion: paused\n")
+	default:
+		b.WriteString("Ingestion: running\n")
+	}
+	fmt.Fprintf(&b, "Messages: %d (%d in the last 24h)\n", stats.Messages, stats.MessagesLastDay)
+	if stats.LastMessageAt != nil {
+		fmt.Fprintf(&b, "Last message: %s ago\n", time.Since(*stats.LastMessageAt).Round(time.Minute))
+	}
+	fmt.Fprintf(&b, "Authors: %d\n", stats.Users)
+	fmt.Fprintf(&b, "Media files: %d (%.1f MB)\n", stats.MediaFiles, float64(stats.MediaBytes)/(1<<20))
+	fmt.Fprintf(&b, "Service events: %d", stats.ServiceMessages)
+	return c.Reply(b.String())
+}
+
+// HandlePause stops ingesting the chat until /resume
+func (h *Handler) HandlePause(c tele.Context) error {
+	return h.setPaused(c, true)
+}
+
+// HandleResume ingests the chat again after /pause
+func (h *Handler) HandleResume(c tele.Context) error {
+	return h.setPaused(c, false)
+}
+
+func (h *Handler) setPaused(c tele.Context, paused bool) error {
+	msg := c.Message()
+	current := h.policies.Get(msg.Chat.ID)
+	if !current.Allowed {
+		return c.Reply("This chat is not on the allowlist, so nothing is stored anyway.")
+	}
+	if current.Paused == paused {
+		if paused {
+			return c.Reply("Ingestion is already paused. Use /resume to store messages again.")
+		}
+		return c.Reply("Ingestion is not paused.")
+	}
+
+	cs := *current
+	cs.Paused = paused
+	if err := h.saveSettings(c, &cs); err != nil {
+		return c.Reply("Failed to save the settings. Please try again later.")
+	}
+
+	slog.Info("chat ingestion paused", "chat_id", msg.Chat.ID, "paused", paused, "user_id", cs.UpdatedBy)
+	if paused {
+		return c.Reply("Ingestion paused: nothing from this chat is stored until /resume.")
+	}
+	return c.Reply("Ingestion resumed.")
+}
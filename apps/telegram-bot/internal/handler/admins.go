@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// isAdmin reports whether the sender of the message in c may run admin commands
+// in its chat: a bot owner, or an admin of the chat
+func (h *Handler) isAdmin(c tele.Context) bool {
+	return h.isOwner(c.Message().Sender) || h.isChatAdmin(c)
+}
+
+// isOwner reports whether user is one of the configured bot owners
+func (h *Handler) isOwner(user *tele.User) bool {
+	return user != nil && slices.Contains(h.owners, user.ID)
+}
+
+// isChatAdmin reports whether the sender of the message in c is an admin of its
+// chat, including admins posting anonymously as the chat
+func (h *Handler) isChatAdmin(c tele.Context) bool {
+	msg := c.Message()
+	if msg.SenderChat != nil && msg.SenderChat.ID == msg.Chat.ID {
+		return true
+	}
+	if msg.Sender == nil || msg.Chat.Type == tele.ChatPrivate {
+		return false
+	}
+
+	admin, err := h.admins.IsAdmin(msg.Chat, msg.Sender.ID)
+	if err != nil {
+		slog.Warn("failed to check chat admin", "error", err, "chat_id", msg.Chat.ID, "user_id", msg.Sender.ID)
+		return false
+	}
+	return admin
+}
+
+// isAdminRole reports whether a member with role is an admin of the chat
+func isAdminRole(role tele.MemberStatus) bool {
+	return role == tele.Creator || role == tele.Administrator
+}
+
+// adminCache caches the administrators of each chat, as returned by
+// getChatAdministrators, so admin commands don't each cost a Bot API call
+type adminCache struct {
+	bot *tele.Bot
+	ttl time.Duration
+
+	mu    sync.Mutex
+	chats map[int64]chatAdmins
+}
+
+// chatAdmins are the administrators of a chat when they were fetched
+type chatAdmins struct {
+	userIDs   []int64
+	fetchedAt time.Time
+}
+
+func newAdminCache(bot *tele.Bot, ttl time.Duration) *adminCache {
+	return &adminCache{
+		bot:   bot,
+		ttl:   ttl,
+		chats: make(map[int64]chatAdmins),
+	}
+}
+
+// IsAdmin reports whether userID is an administrator (or the creator) of chat.
+// The admins of a chat are fetched again once the cached list is older than the TTL.
+func (a *adminCache) IsAdmin(chat *tele.Chat, userID int64) (bool, error) {
+	a.mu.Lock()
+	cached, ok := a.chats[chat.ID]
+	a.mu.Unlock()
+
+	if !ok || time.Since(cached.fetchedAt) > a.ttl {
+		members, err := a.bot.AdminsOf(chat)
+		if err != nil {
+			return false, fmt.Errorf("failed to get chat administrators: %w", err)
+		}
+
+		cached = chatAdmins{fetchedAt: time.Now()}
+		for _, m := range members {
+			if m.User != nil {
+				cached.userIDs = append(cached.userIDs, m.User.ID)
+			}
+		}
+
+		a.mu.Lock()
+		a.chats[chat.ID] = cached
+		a.mu.Unlock()
+	}
+
+	return slices.Contains(cached.userIDs, userID), nil
+}
+
+// Invalidate drops the cached admins of a chat, e.g. after a promotion or demotion
+func (a *adminCache) Invalidate(chatID int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.chats, chatID)
+}
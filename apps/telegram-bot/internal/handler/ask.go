@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/api"
+	"beef-briefing/apps/telegram-bot/internal/format"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// askContextSize is how many retrieved messages are sent to the LLM
+	askContextSize = 20
+
+	// askTimeout bounds how long we wait for an answer
+	askTimeout = 3 * time.Minute
+)
+
+const askUsage = "Usage: /ask who recommended the butcher on Rua Augusta?"
+
+// HandleAsk answers a question using the messages of the current chat as context
+func (h *Handler) HandleAsk(c tele.Context) error {
+	msg := c.Message()
+	question := strings.TrimSpace(msg.Payload)
+	if question == "" {
+		return c.Reply(askUsage)
+	}
+
+	if ok, wait := h.askLimiter.Allow(msg.Chat.ID); !ok {
+		return c.Reply(fmt.Sprintf("A question was asked recently. Please try again in %s.", wait.Round(time.Second)))
+	}
+
+	// Retrieval and waiting for the answer happen on the command workers
+	ok := h.runCommand(c, "ask", func(ctx context.Context) error {
+		return h.answer(ctx, c, question)
+	})
+	if !ok {
+		h.askLimiter.Reset(msg.Chat.ID)
+		return c.Reply(busyReply)
+	}
+	return nil
+}
+
+// answer searches the chat for context on question, asks the analyzer and replies
+// with its answer and the messages it cites
+func (h *Handler) answer(ctx context.Context, c tele.Context, question string) error {
+	msg := c.Message()
+	ctx, cancel := context.WithTimeout(ctx, askTimeout)
+	defer cancel()
+
+	stopTyping := keepTyping(ctx, c)
+	defer stopTyping()
+
+	// Retrieval is scoped to this chat only, so answers never leak other groups' messages,
+	// and to the forum topic the question was asked in, if any
+	results, err := h.store.SearchMessages(ctx, msg.Chat.ID, topicID(msg), question, askContextSize)
+	if err != nil {
+		slog.Error("failed to search messages", "error", err, "chat_id", msg.Chat.ID)
+		h.askLimiter.Reset(msg.Chat.ID)
+		return c.Reply("Sorry, I couldn't search the archive. Please try again later.")
+	}
+
+	if len(results) == 0 {
+		stopTyping()
+		return c.Reply("I couldn't find anything about that in this chat.")
+	}
+
+	req := &api.AnswerRequest{
+		ChatID:          msg.Chat.ID,
+		MessageThreadID: topicID(msg),
+		Question:        question,
+	}
+	// Messages may come from the chat's former ids, which can reuse the same message
+	// ids, so messages are told apart by chat as well
+	retrieved := make(map[api.MessageRef]bool, len(results))
+	for _, r := range results {
+		req.Context = append(req.Context, api.ContextMessage{
+			ChatID:    r.ChatID,
+			MessageID: r.TelegramMessageID,
+			Date:      r.MessageDate,
+			Author:    r.Author,
+			Text:      r.Text,
+		})
+		retrieved[api.MessageRef{ChatID: r.ChatID, MessageID: r.TelegramMessageID}] = true
+		if !slices.Contains(req.ChatIDs, r.ChatID) {
+			req.ChatIDs = append(req.ChatIDs, r.ChatID)
+		}
+	}
+
+	answer, err := h.api.Ask(ctx, req)
+	stopTyping()
+	if err != nil {
+		slog.Error("failed to get answer", "error", err, "chat_id", msg.Chat.ID)
+		h.askLimiter.Reset(msg.Chat.ID)
+		return c.Reply("Sorry, I couldn't answer that. Please try again later.")
+	}
+
+	slog.Info("question answered",
+		"chat_id", msg.Chat.ID,
+		"context_messages", len(results),
+		"cited_messages", len(answer.CitedMessages))
+
+	blocks := format.TextBlocks(answer.Answer, format.MaxMessageLength)
+
+	// Only cite messages that were actually retrieved from this chat
+	var sources []string
+	for _, ref := range answer.CitedMessages {
+		if !retrieved[ref] {
+			continue
+		}
+		if url := format.MessageLink(ref.ChatID, ref.MessageID); url != "" {
+			sources = append(sources, format.Link(url, fmt.Sprintf("[%d]", len(sources)+1)))
+		}
+	}
+	if len(sources) > 0 {
+		blocks = append(blocks, "Sources: "+strings.Join(sources, " "))
+	}
+
+	return replyHTML(c, blocks)
+}
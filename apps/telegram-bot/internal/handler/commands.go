@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/tracing"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Access is who may run a command
+type Access int
+
+const (
+	// AccessEveryone lets anyone in the chat run the command
+	AccessEveryone Access = iota
+	// AccessAdmin restricts the command to chat admins and bot owners
+	AccessAdmin
+)
+
+// Command is a bot command served by the handler
+type Command struct {
+	Name        string // without the leading slash
+	Description string
+	Access      Access
+	Handler     tele.HandlerFunc // checks Access before running the command
+}
+
+// Commands returns the commands the bot responds to
+func (h *Handler) Commands() []Command {
+	commands := []Command{
+		{
+			Name:        "help",
+			Description: "List the commands you can use here",
+			Access:      AccessEveryone,
+			Handler:     h.HandleHelp,
+		},
+		{
+			Name:        "summary",
+			Description: "Catch up: /summary 6h, or reply to a message with /summary",
+			Access:      AccessEveryone,
+			Handler:     h.HandleSummary,
+		},
+		{
+			Name:        "ask",
+			Description: "Ask the chat archive a question",
+			Access:      AccessEveryone,
+			Handler:     h.HandleAsk,
+		},
+		{
+			Name:        "forgetme",
+			Description: "Erase your messages and stop storing them",
+			Access:      AccessEveryone,
+			Handler:     h.HandleForgetMe,
+		},
+		{
+			Name:        "status",
+			Description: "Show ingestion stats of this chat",
+			Access:      AccessAdmin,
+			Handler:     h.HandleStatus,
+		},
+		{
+			Name:        "pause",
+			Description: "Stop storing this chat until /resume",
+			Access:      AccessAdmin,
+			Handler:     h.HandlePause,
+		},
+		{
+			Name:        "resume",
+			Description: "Store this chat again after /pause",
+			Access:      AccessAdmin,
+			Handler:     h.HandleResume,
+		},
+		{
+			Name:        "settings",
+			Description: "Show or change what the bot stores in this chat",
+			Access:      AccessAdmin,
+			Handler:     h.HandleSettings,
+		},
+		{
+			Name:        "export",
+			Description: "Receive an archive of this chat in a private message",
+			Access:      AccessAdmin,
+			Handler:     h.HandleExport,
+		},
+	}
+
+	for i, cmd := range commands {
+		commands[i].Handler = h.authorize(cmd)
+	}
+	return commands
+}
+
+// authorize wraps the handler of cmd with its access check
+func (h *Handler) authorize(cmd Command) tele.HandlerFunc {
+	if cmd.Access == AccessEveryone {
+		return cmd.Handler
+	}
+	return func(c tele.Context) error {
+		if !h.isAdmin(c) {
+			return c.Reply(fmt.Sprintf("Only chat admins can use /%s.", cmd.Name))
+		}
+		return cmd.Handler(c)
+	}
+}
+
+// HandleHelp lists the commands the sender may run in the chat
+func (h *Handler) HandleHelp(c tele.Context) error {
+	admin := h.isAdmin(c)
+
+	var b strings.Builder
+	b.WriteString("Commands:\n")
+	for _, cmd := range h.Commands() {
+		if cmd.Access == AccessAdmin && !admin {
+			continue
+		}
+		fmt.Fprintf(&b, "/%s - %s\n", cmd.Name, cmd.Description)
+	}
+	if admin {
+		b.WriteString("\nSend /settings help for the settings you can change.")
+	}
+	return c.Reply(b.String())
+}
+
+// busyReply answers a command the command workers have no room for
+const busyReply = "I'm busy with other requests right now. Please try again in a minute."
+
+// runCommand finishes a slow command on the command workers, so the other updates
+// of the chat are not held up while it waits for the analyzer or the database. Its
+// span is a child of the update's, and its error is reported like a handler's.
+// It reports false, without running fn, if the command workers are all busy.
+func (h *Handler) runCommand(c tele.Context, name string, fn func(ctx context.Context) error) bool {
+	ctx, span := tracing.StartChild(h.updateContext(c), "command."+name)
+	ok := h.commands.TrySubmit(func() {
+		defer span.End()
+		if err := fn(ctx); err != nil {
+			tracing.RecordError(span, err)
+			h.bot.OnError(err, c)
+		}
+	})
+	if !ok {
+		span.End()
+		slog.Warn("command workers busy, command refused", "command", name, "chat_id", c.Chat().ID)
+	}
+	return ok
+}
+
+// rateLimiter allows one action per key within a cooldown period
+type rateLimiter struct {
+	mu       sync.Mutex
+	cooldown time.Duration
+	last     map[int64]time.Time
+}
+
+func newRateLimiter(cooldown time.Duration) *rateLimiter {
+	return &rateLimiter{
+		cooldown: cooldown,
+		last:     make(map[int64]time.Time),
+	}
+}
+
+// Allow reports whether key may act now and, if so, starts its cooldown.
+// Otherwise it returns how long the caller has to wait.
+func (r *rateLimiter) Allow(key int64) (bool, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := r.last[key]; ok {
+		if wait := r.cooldown - now.Sub(last); wait > 0 {
+			return false, wait
+		}
+	}
+	r.last[key] = now
+	return true, 0
+}
+
+// Reset clears the cooldown of key, e.g. after the action failed
+func (r *rateLimiter) Reset(key int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.last, key)
+}
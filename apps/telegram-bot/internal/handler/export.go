@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/store"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// maxExportSize is the largest file a bot can send
+	maxExportSize = 50 << 20
+
+	// exportTimeout bounds how long building an export may take
+	exportTimeout = 5 * time.Minute
+)
+
+// errExportTooLarge is returned when an export does not fit in a Telegram document
+var errExportTooLarge = errors.New("export too large")
+
+// HandleExport sends the sender a zip of the chat's messages and service events
+// as JSON lines, in a private message. Media files are referenced by hash, not included.
+func (h *Handler) HandleExport(c tele.Context) error {
+	msg := c.Message()
+	if msg.Sender == nil || msg.SenderChat != nil {
+		return c.Reply("Send /export from your own account, so the archive can be sent to you.")
+	}
+
+	if ok, wait := h.exportLimiter.Allow(msg.Chat.ID); !ok {
+		return c.Reply(fmt.Sprintf("An export was made recently. Please try again in %s.", wait.Round(time.Second)))
+	}
+
+	// Building the archive happens on the command workers
+	ok := h.runCommand(c, "export", func(ctx context.Context) error {
+		return h.export(ctx, c)
+	})
+	if !ok {
+		h.exportLimiter.Reset(msg.Chat.ID)
+		return c.Reply(busyReply)
+	}
+	return nil
+}
+
+// export builds the archive of the chat in c and sends it to the sender
+func (h *Handler) export(ctx context.Context, c tele.Context) error {
+	msg := c.Message()
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+
+	archive, err := h.buildExport(ctx, msg.Chat.ID)
+	if err != nil {
+		h.exportLimiter.Reset(msg.Chat.ID)
+		if errors.Is(err, errExportTooLarge) {
+			return c.Reply("This chat's archive is too large to send through Telegram.")
+		}
+		slog.Error("failed to export chat", "error", err, "chat_id", msg.Chat.ID)
+		return c.Reply("Failed to export the chat. Please try again later.")
+	}
+
+	doc := &tele.Document{
+		File:     tele.FromReader(archive),
+		FileName: fmt.Sprintf("chat-%d-%s.zip", msg.Chat.ID, time.Now().Format("20060102")),
+		MIME:     "application/zip",
+		Caption:  fmt.Sprintf("Export of %s", chatTitle(msg.Chat)),
+	}
+	if _, err := h.bot.Send(msg.Sender, doc); err != nil {
+		h.exportLimiter.Reset(msg.Chat.ID)
+		slog.Warn("failed to send export", "error", err, "chat_id", msg.Chat.ID, "user_id", msg.Sender.ID)
+		return c.Reply("I couldn't send you the archive. Start a private chat with me, then try again.")
+	}
+
+	slog.Info("chat exported", "chat_id", msg.Chat.ID, "user_id", msg.Sender.ID, "size", archive.Len())
+	return c.Reply("The archive was sent to you in a private message.")
+}
+
+// buildExport writes the messages and service events of every id of the chat to a zip
+func (h *Handler) buildExport(ctx context.Context, chatID int64) (*bytes.Buffer, error) {
+	chatIDs, err := h.store.ResolveChatIDs(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	// writeLine appends v to the current file, failing once the archive is too large to send
+	var enc *json.Encoder
+	writeLine := func(v interface{}) error {
+		if buf.Len() > maxExportSize {
+			return errExportTooLarge
+		}
+		return enc.Encode(v)
+	}
+
+	w, err := zw.Create("messages.jsonl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create messages file: %w", err)
+	}
+	enc = json.NewEncoder(w)
+	err = h.store.ExportMessages(ctx, chatIDs, func(m *store.ExportedMessage) error {
+		return writeLine(m)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w, err = zw.Create("service_messages.jsonl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service messages file: %w", err)
+	}
+	enc = json.NewEncoder(w)
+	err = h.store.ExportServiceMessages(ctx, chatIDs, func(m *store.ExportedServiceMessage) error {
+		return writeLine(m)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if buf.Len() > maxExportSize {
+		return nil, errExportTooLarge
+	}
+	return &buf, nil
+}
+
+// chatTitle names a chat for a reply
+func chatTitle(chat *tele.Chat) string {
+	if chat.Title != "" {
+		return chat.Title
+	}
+	if chat.Username != "" {
+		return "@" + chat.Username
+	}
+	return fmt.Sprintf("chat %d", chat.ID)
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/blobs"
+	"beef-briefing/apps/telegram-bot/internal/store"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// erasureTimeout bounds how long erasing a user's data may take
+const erasureTimeout = 5 * time.Minute
+
+// sweepWaitTimeout bounds how long an erasure waits for the updates received
+// before it to be processed, leaving time to delete the media files
+const sweepWaitTimeout = 2 * time.Minute
+
+// LoadOptOuts loads the users who opted out. Their messages are dropped by HandleMessage.
+func (h *Handler) LoadOptOuts(ctx context.Context) error {
+	ids, err := h.store.ListOptedOutUserIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		h.optedOut.Set(id, true)
+	}
+	return nil
+}
+
+// HandleForgetMe opts the sender out, so their messages are no longer stored in
+// any chat, and erases what was stored about them
+func (h *Handler) HandleForgetMe(c tele.Context) error {
+	msg := c.Message()
+	if msg.Sender == nil || msg.SenderChat != nil {
+		return c.Reply("Send /forgetme from your own account, not on behalf of a chat.")
+	}
+	userID := msg.Sender.ID
+
+	// Drop the sender's messages right away, so none are stored while erasing, and
+	// record the opt-out before the erasure, which runs on the command workers
+	h.optedOut.Set(userID, true)
+	if err := h.store.OptOutUser(h.updateContext(c), userID, msg.Chat.ID); err != nil {
+		slog.Error("failed to opt user out", "error", err, "user_id", userID)
+		return c.Reply("Failed to erase your data. Please try again later.")
+	}
+
+	// Updates received so far may have passed the opt-out check on other workers
+	// already; the erasure sweeps again once they are processed
+	received := h.offsets.Last()
+	ok := h.runCommand(c, "forgetme", func(ctx context.Context) error {
+		return h.erase(ctx, c, userID, received)
+	})
+	if !ok {
+		return c.Reply("Your messages are no longer stored, but I'm too busy to erase the stored ones right now. Please send /forgetme again in a minute.")
+	}
+	return nil
+}
+
+// erase deletes what was stored about the user and replies with what was deleted.
+// Once the updates up to received are processed, it erases again what those
+// stored about the user.
+func (h *Handler) erase(ctx context.Context, c tele.Context, userID, received int64) error {
+	msg := c.Message()
+	ctx, cancel := context.WithTimeout(ctx, erasureTimeout)
+	defer cancel()
+
+	erasure, err := h.store.EraseUser(ctx, userID, msg.Chat.ID)
+	if err != nil {
+		slog.Error("failed to erase user", "error", err, "user_id", userID)
+		return c.Reply("Failed to erase your data. Your messages are no longer stored; please try again later.")
+	}
+	h.sweepErasure(ctx, erasure, received)
+	deleted := h.deleteBlobs(ctx, erasure)
+
+	slog.Info("user erased",
+		"erasure_id", erasure.ID,
+		"user_id", userID,
+		"chat_id", msg.Chat.ID,
+		"messages", erasure.MessagesDeleted,
+		"service_messages", erasure.ServiceMessagesAnonymised,
+		"blobs", deleted)
+	return c.Reply(fmt.Sprintf("Done: %d of your messages and %d media files were deleted, in this chat and any other. "+
+		"Your messages will no longer be stored.", erasure.MessagesDeleted, deleted))
+}
+
+// sweepErasure waits for the updates up to received to be processed, then erases
+// what they stored about the user. On failure, the sweep is left out; the
+// user's later messages are dropped either way.
+func (h *Handler) sweepErasure(ctx context.Context, erasure *store.Erasure, received int64) {
+	waitCtx, cancel := context.WithTimeout(ctx, sweepWaitTimeout)
+	defer cancel()
+	if err := h.offsets.WaitProcessed(waitCtx, received); err != nil {
+		slog.Warn("erasure not swept, updates still being processed", "error", err, "erasure_id", erasure.ID)
+		return
+	}
+	if err := h.store.SweepErasure(ctx, erasure); err != nil {
+		slog.Error("failed to sweep erasure", "error", err, "erasure_id", erasure.ID)
+	}
+}
+
+// deleteBlobs removes the media files an erasure left unreferenced and completes
+// its audit record. It returns how many were deleted; the last failure, if any,
+// is recorded on the audit record, and the files left to the garbage collector.
+func (h *Handler) deleteBlobs(ctx context.Context, erasure *store.Erasure) int {
+	deleted, err := blobs.Delete(ctx, h.store, h.minioClient, erasure.UnreferencedBlobs)
+	var reason *string
+	if err != nil {
+		reason = stringPtr(err.Error())
+	}
+
+	if err := h.store.CompleteErasure(ctx, erasure.ID, deleted, reason); err != nil {
+		slog.Error("failed to complete erasure", "error", err, "erasure_id", erasure.ID)
+	}
+	return deleted
+}
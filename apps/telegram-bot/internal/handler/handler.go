@@ -1,70 +1,85 @@
 package handler
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"io"
+	"errors"
 	"log/slog"
 	"time"
 
+	"beef-briefing/apps/telegram-bot/internal/api"
+	"beef-briefing/apps/telegram-bot/internal/config"
+	"beef-briefing/apps/telegram-bot/internal/dispatch"
 	"beef-briefing/apps/telegram-bot/internal/storage"
 	"beef-briefing/apps/telegram-bot/internal/store"
+	"beef-briefing/apps/telegram-bot/internal/tracing"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	tele "gopkg.in/telebot.v4"
 )
 
 type Handler struct {
-	store       *store.PostgresStore
-	minioClient *storage.MinIOClient
-	bot         *tele.Bot
+	// ctx is the context handlers work under. It outlives the shutdown signal, so
+	// in-flight updates and uploads can finish, and is cancelled once the drain deadline passes.
+	ctx            context.Context
+	store          *store.PostgresStore
+	minioClient    *storage.MinIOClient
+	bot            *tele.Bot
+	api            *api.Client
+	summaryLimiter *rateLimiter
+	askLimiter     *rateLimiter
+	exportLimiter  *rateLimiter
+	inactiveChats  *idSet
+	policies       *chatPolicies
+	admins         *adminCache
+	optedOut       *idSet
+	owners         []int64
+	uploads        *dispatch.Pool
+	commands       *dispatch.Pool
+	offsets        *dispatch.Offsets
 }
 
-func NewHandler(store *store.PostgresStore, minioClient *storage.MinIOClient, bot *tele.Bot) *Handler {
+func NewHandler(ctx context.Context, store *store.PostgresStore, minioClient *storage.MinIOClient, bot *tele.Bot, apiClient *api.Client, uploads, commands *dispatch.Pool, offsets *dispatch.Offsets, cfg *config.Config) *Handler {
 	return &Handler{
-		store:       store,
-		minioClient: minioClient,
-		bot:         bot,
+		ctx:            ctx,
+		store:          store,
+		minioClient:    minioClient,
+		bot:            bot,
+		api:            apiClient,
+		summaryLimiter: newRateLimiter(cfg.SummaryCooldown),
+		askLimiter:     newRateLimiter(cfg.AskCooldown),
+		exportLimiter:  newRateLimiter(cfg.ExportCooldown),
+		inactiveChats:  newIDSet(),
+		policies:       newChatPolicies(),
+		admins:         newAdminCache(bot, cfg.AdminCacheTTL),
+		optedOut:       newIDSet(),
+		owners:         cfg.OwnerUserIDs,
+		uploads:        uploads,
+		commands:       commands,
+		offsets:        offsets,
 	}
 }
 
 // HandleMessage processes incoming messages
 func (h *Handler) HandleMessage(c tele.Context) error {
 	msg := c.Message()
-	ctx := context.Background()
+	ctx := h.updateContext(c)
 
-	// Upsert chat
-	chat := &store.Chat{
-		ID:        msg.Chat.ID,
-		Type:      string(msg.Chat.Type),
-		Name:      msg.Chat.Title,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-	if err := h.store.UpsertChat(ctx, chat); err != nil {
-		slog.Error("failed to upsert chat", "error", err, "chat_id", msg.Chat.ID)
-		return err
+	// Unknown commands from chats not ingested end up here
+	policy := h.policies.Get(msg.Chat.ID)
+	if !ingests(policy) {
+		return nil
 	}
-
-	// Upsert user (sender)
-	if msg.Sender != nil {
-		user := &store.User{
-			ID:        msg.Sender.ID,
-			Username:  msg.Sender.Username,
-			FirstName: msg.Sender.FirstName,
-			LastName:  msg.Sender.LastName,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
-		if err := h.store.UpsertUser(ctx, user); err != nil {
-			slog.Error("failed to upsert user", "error", err, "user_id", msg.Sender.ID)
-			return err
-		}
+	if msg.Sender != nil && h.optedOut.Has(msg.Sender.ID) {
+		slog.Debug("message of opted-out user dropped", "chat_id", msg.Chat.ID, "telegram_message_id", msg.ID)
+		return nil
 	}
 
 	// Determine message type and handle media
 	messageType := "text"
 	shouldStore := true
+	var upload *mediaUpload
 	var mediaFileName *string
 	var mediaFileSize *int64
 	var mediaMimeType *string
@@ -80,31 +95,66 @@ func (h *Handler) HandleMessage(c tele.Context) error {
 	// Handle different media types
 	if msg.Photo != nil {
 		messageType = "photo"
-		h.handlePhoto(msg.Photo, &mediaFileName, &mediaFileSize, &mediaMimeType, &mediaWidth, &mediaHeight)
+		h.handlePhoto(msg.Photo, &upload, &mediaFileName, &mediaFileSize, &mediaMimeType, &mediaWidth, &mediaHeight)
 	} else if msg.Video != nil {
 		messageType = "video"
-		h.handleVideo(msg.Video, &mediaFileName, &mediaFileSize, &mediaMimeType, &mediaDuration, &mediaWidth, &mediaHeight)
+		h.handleVideo(msg.Video, &upload, &mediaFileName, &mediaFileSize, &mediaMimeType, &mediaDuration, &mediaWidth, &mediaHeight)
 	} else if msg.Voice != nil {
 		messageType = "voice"
-		h.handleVoice(msg.Voice, &mediaFileName, &mediaFileSize, &mediaMimeType, &mediaDuration)
+		h.handleVoice(msg.Voice, &upload, &mediaFileName, &mediaFileSize, &mediaMimeType, &mediaDuration)
 	} else if msg.Document != nil {
 		messageType = "document"
-		h.handleDocument(msg.Document, &mediaFileName, &mediaFileSize, &mediaMimeType)
+		h.handleDocument(msg.Document, &upload, &mediaFileName, &mediaFileSize, &mediaMimeType)
 	} else if msg.Sticker != nil {
 		messageType = "sticker"
-		h.handleSticker(msg.Sticker, &mediaFileName, &mediaFileSize, &mediaMimeType, &mediaWidth, &mediaHeight)
+		h.handleSticker(msg.Sticker, &upload, &mediaFileName, &mediaFileSize, &mediaMimeType, &mediaWidth, &mediaHeight)
 	} else if msg.Animation != nil {
 		messageType = "animation"
-		h.handleAnimation(msg.Animation, &mediaFileName, &mediaFileSize, &mediaMimeType, &mediaDuration, &mediaWidth, &mediaHeight)
+		h.handleAnimation(msg.Animation, &upload, &mediaFileName, &mediaFileSize, &mediaMimeType, &mediaDuration, &mediaWidth, &mediaHeight)
 	} else if msg.VideoNote != nil {
 		messageType = "video_note"
-		h.handleVideoNote(msg.VideoNote, &mediaFileName, &mediaFileSize, &mediaMimeType, &mediaDuration)
+		h.handleVideoNote(msg.VideoNote, &upload, &mediaFileName, &mediaFileSize, &mediaMimeType, &mediaDuration)
 	} else if msg.Location != nil {
 		messageType = "location"
-		shouldStore = h.handleLocation(ctx, msg, &latitude, &longitude, &additionalMetadata)
+		if policy.StoreLocations {
+			shouldStore = h.handleLocation(ctx, msg, &latitude, &longitude, &additionalMetadata)
+		}
 	} else if msg.Venue != nil {
 		messageType = "venue"
 		h.handleVenue(msg, &latitude, &longitude, &venueTitle, &venueAddress)
+	} else if msg.Audio != nil {
+		messageType = "audio"
+		h.handleAudio(msg.Audio, &upload, &mediaFileName, &mediaFileSize, &mediaMimeType, &mediaDuration, &additionalMetadata)
+	} else if msg.Contact != nil {
+		messageType = "contact"
+		h.handleContact(msg.Contact, &additionalMetadata)
+	} else if msg.Dice != nil {
+		messageType = "dice"
+		h.handleDice(msg.Dice, &additionalMetadata)
+	} else if msg.Game != nil {
+		messageType = "game"
+		h.handleGame(msg.Game, &additionalMetadata)
+	} else if msg.Story != nil {
+		messageType = "story"
+		h.handleStory(msg.Story, &additionalMetadata)
+	} else if msg.Poll != nil {
+		messageType = "poll"
+		pollMeta, _ := json.Marshal(map[string]interface{}{"poll_id": msg.Poll.ID, "poll_type": msg.Poll.Type})
+		additionalMetadata = pollMeta
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("message_type", messageType))
+
+	// Apply the chat's policy before anything is written
+	if !storesType(policy, messageType) {
+		slog.Debug("message type not stored in chat", "chat_id", msg.Chat.ID, "type", messageType)
+		return nil
+	}
+	if upload != nil && !storesMedia(policy, mediaFileSize) {
+		slog.Debug("media not downloaded in chat", "chat_id", msg.Chat.ID, "type", messageType, "size", mediaFileSize)
+		upload = nil
+	}
+	if !policy.StoreLocations {
+		latitude, longitude = nil, nil
 	}
 
 	// Skip storing if location handler determined it's too close to previous location
@@ -141,6 +191,11 @@ func (h *Handler) HandleMessage(c tele.Context) error {
 		VenueTitle:        venueTitle,
 		VenueAddress:      venueAddress,
 	}
+	// Until the upload finishes, the file id marks the media as pending, so an
+	// upload lost to a restart is retried by RecoverUploads
+	if upload != nil {
+		storeMsg.MediaFileID = &upload.file.FileID
+	}
 
 	if msg.Sender != nil {
 		userID := msg.Sender.ID
@@ -151,6 +206,10 @@ func (h *Handler) HandleMessage(c tele.Context) error {
 		storeMsg.Text = &msg.Text
 	} else if msg.Caption != "" {
 		storeMsg.Text = &msg.Caption
+	} else if msg.Poll != nil {
+		storeMsg.Text = &msg.Poll.Question
+	} else if msg.Game != nil {
+		storeMsg.Text = &msg.Game.Title
 	}
 
 	if msg.ReplyTo != nil {
@@ -158,13 +217,86 @@ func (h *Handler) HandleMessage(c tele.Context) error {
 		storeMsg.ReplyToMessageID = &replyID
 	}
 
-	// Insert message
-	messageID, err := h.store.InsertMessage(ctx, storeMsg)
+	if msg.AlbumID != "" {
+		storeMsg.MediaGroupID = &msg.AlbumID
+	}
+
+	storeMsg.MessageThreadID = topicID(msg)
+
+	// The chat, sender, topic, message, album and poll are stored together
+	var messageID int64
+	err := h.saveUpdate(c, func(tx *store.Tx) error {
+		// Upsert chat
+		chat := &store.Chat{
+			ID:        msg.Chat.ID,
+			Type:      string(msg.Chat.Type),
+			Name:      msg.Chat.Title,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		if err := tx.UpsertChat(ctx, chat); err != nil {
+			slog.Error("failed to upsert chat", "error", err, "chat_id", msg.Chat.ID)
+			return err
+		}
+
+		// Upsert user (sender)
+		if msg.Sender != nil {
+			user := userFromTelegram(msg.Sender)
+			if err := tx.UpsertUser(ctx, user); err != nil {
+				slog.Error("failed to upsert user", "error", err, "user_id", msg.Sender.ID)
+				return err
+			}
+		}
+
+		// Messages in a topic reply to the message that created it, which names the topic
+		if threadID := storeMsg.MessageThreadID; threadID != nil && msg.ReplyTo != nil && msg.ReplyTo.TopicCreated != nil {
+			topic := forumTopicFromTelegram(msg.Chat.ID, *threadID, msg.ReplyTo.TopicCreated)
+			createdAt := msg.ReplyTo.Time()
+			topic.TopicCreatedAt = &createdAt
+			if err := tx.EnsureForumTopic(ctx, topic); err != nil {
+				slog.Error("failed to store forum topic", "error", err, "message_thread_id", *threadID)
+				return err
+			}
+		}
+
+		// Insert message
+		id, err := tx.InsertMessage(ctx, storeMsg)
+		if err != nil {
+			if !errors.Is(err, store.ErrMessageExists) {
+				slog.Error("failed to insert message", "error", err, "telegram_message_id", msg.ID)
+			}
+			return err
+		}
+		messageID = id
+
+		if storeMsg.MediaGroupID != nil {
+			if err := tx.AddToAlbum(ctx, storeMsg); err != nil {
+				slog.Error("failed to add message to album", "error", err, "media_group_id", msg.AlbumID)
+				return err
+			}
+		}
+
+		if msg.Poll != nil {
+			if err := h.savePoll(ctx, tx, msg); err != nil {
+				slog.Error("failed to store poll", "error", err, "poll_id", msg.Poll.ID)
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, store.ErrMessageExists) {
+		slog.Debug("message already stored", "chat_id", msg.Chat.ID, "telegram_message_id", msg.ID)
+		return nil
+	}
 	if err != nil {
-		slog.Error("failed to insert message", "error", err, "telegram_message_id", msg.ID)
 		return err
 	}
 
+	// Media is archived on the upload workers, so large files don't hold up the chat
+	if upload != nil {
+		h.uploads.Submit(func() { h.archiveMedia(ctx, messageID, upload) })
+	}
+
 	slog.Info("message processed",
 		"message_id", messageID,
 		"telegram_message_id", msg.ID,
@@ -174,7 +306,7 @@ func (h *Handler) HandleMessage(c tele.Context) error {
 	return nil
 }
 
-func (h *Handler) handlePhoto(photo *tele.Photo, name **string, size **int64, mimeType **string, width, height **int) {
+func (h *Handler) handlePhoto(photo *tele.Photo, upload **mediaUpload, name **string, size **int64, mimeType **string, width, height **int) {
 	fileSize := int64(photo.FileSize)
 	*size = &fileSize
 	*mimeType = stringPtr("image/jpeg")
@@ -183,15 +315,12 @@ func (h *Handler) handlePhoto(photo *tele.Photo, name **string, size **int64, mi
 	photHeight := photo.Height
 	*height = &photHeight
 
-	// Download and upload to MinIO
-	if hash := h.uploadFileToMinIO(photo.File, "image/jpeg"); hash != "" {
-		*name = stringPtr(hash)
-	} else {
-		*name = stringPtr(photo.FileID)
-	}
+	// Archived in MinIO once the message is stored, named by its hash from then on
+	*name = stringPtr(photo.FileID)
+	*upload = &mediaUpload{file: photo.File, contentType: "image/jpeg"}
 }
 
-func (h *Handler) handleVideo(video *tele.Video, name **string, size **int64, mimeType **string, duration, width, height **int) {
+func (h *Handler) handleVideo(video *tele.Video, upload **mediaUpload, name **string, size **int64, mimeType **string, duration, width, height **int) {
 	fileSize := int64(video.FileSize)
 	*size = &fileSize
 	*mimeType = stringPtr(video.MIME)
@@ -202,43 +331,34 @@ func (h *Handler) handleVideo(video *tele.Video, name **string, size **int64, mi
 	vidHeight := video.Height
 	*height = &vidHeight
 
-	// Download and upload to MinIO
-	if hash := h.uploadFileToMinIO(video.File, video.MIME); hash != "" {
-		*name = stringPtr(hash)
-	} else {
-		*name = stringPtr(video.FileName)
-	}
+	// Archived in MinIO once the message is stored, named by its hash from then on
+	*name = stringPtr(video.FileName)
+	*upload = &mediaUpload{file: video.File, contentType: video.MIME}
 }
 
-func (h *Handler) handleVoice(voice *tele.Voice, name **string, size **int64, mimeType **string, duration **int) {
+func (h *Handler) handleVoice(voice *tele.Voice, upload **mediaUpload, name **string, size **int64, mimeType **string, duration **int) {
 	fileSize := int64(voice.FileSize)
 	*size = &fileSize
 	*mimeType = stringPtr(voice.MIME)
 	d := voice.Duration
 	*duration = &d
 
-	// Download and upload to MinIO
-	if hash := h.uploadFileToMinIO(voice.File, voice.MIME); hash != "" {
-		*name = stringPtr(hash)
-	} else {
-		*name = stringPtr(voice.FileID)
-	}
+	// Archived in MinIO once the message is stored, named by its hash from then on
+	*name = stringPtr(voice.FileID)
+	*upload = &mediaUpload{file: voice.File, contentType: voice.MIME}
 }
 
-func (h *Handler) handleDocument(doc *tele.Document, name **string, size **int64, mimeType **string) {
+func (h *Handler) handleDocument(doc *tele.Document, upload **mediaUpload, name **string, size **int64, mimeType **string) {
 	fileSize := int64(doc.FileSize)
 	*size = &fileSize
 	*mimeType = stringPtr(doc.MIME)
 
-	// Download and upload to MinIO
-	if hash := h.uploadFileToMinIO(doc.File, doc.MIME); hash != "" {
-		*name = stringPtr(hash)
-	} else {
-		*name = stringPtr(doc.FileName)
-	}
+	// Archived in MinIO once the message is stored, named by its hash from then on
+	*name = stringPtr(doc.FileName)
+	*upload = &mediaUpload{file: doc.File, contentType: doc.MIME}
 }
 
-func (h *Handler) handleSticker(sticker *tele.Sticker, name **string, size **int64, mimeType **string, width, height **int) {
+func (h *Handler) handleSticker(sticker *tele.Sticker, upload **mediaUpload, name **string, size **int64, mimeType **string, width, height **int) {
 	fileSize := int64(sticker.FileSize)
 	*size = &fileSize
 	*mimeType = stringPtr("image/webp")
@@ -247,15 +367,12 @@ func (h *Handler) handleSticker(sticker *tele.Sticker, name **string, size **int
 	stickerHeight := sticker.Height
 	*height = &stickerHeight
 
-	// Download and upload to MinIO
-	if hash := h.uploadFileToMinIO(sticker.File, "image/webp"); hash != "" {
-		*name = stringPtr(hash)
-	} else {
-		*name = stringPtr(sticker.FileID)
-	}
+	// Archived in MinIO once the message is stored, named by its hash from then on
+	*name = stringPtr(sticker.FileID)
+	*upload = &mediaUpload{file: sticker.File, contentType: "image/webp"}
 }
 
-func (h *Handler) handleAnimation(anim *tele.Animation, name **string, size **int64, mimeType **string, duration, width, height **int) {
+func (h *Handler) handleAnimation(anim *tele.Animation, upload **mediaUpload, name **string, size **int64, mimeType **string, duration, width, height **int) {
 	fileSize := int64(anim.FileSize)
 	*size = &fileSize
 	*mimeType = stringPtr(anim.MIME)
@@ -266,70 +383,204 @@ func (h *Handler) handleAnimation(anim *tele.Animation, name **string, size **in
 	animHeight := anim.Height
 	*height = &animHeight
 
-	// Download and upload to MinIO
-	if hash := h.uploadFileToMinIO(anim.File, anim.MIME); hash != "" {
-		*name = stringPtr(hash)
-	} else {
-		*name = stringPtr(anim.FileName)
-	}
+	// Archived in MinIO once the message is stored, named by its hash from then on
+	*name = stringPtr(anim.FileName)
+	*upload = &mediaUpload{file: anim.File, contentType: anim.MIME}
 }
 
-func (h *Handler) handleVideoNote(videoNote *tele.VideoNote, name **string, size **int64, mimeType **string, duration **int) {
+func (h *Handler) handleVideoNote(videoNote *tele.VideoNote, upload **mediaUpload, name **string, size **int64, mimeType **string, duration **int) {
 	fileSize := int64(videoNote.FileSize)
 	*size = &fileSize
 	*mimeType = stringPtr("video/mp4")
 	d := videoNote.Duration
 	*duration = &d
 
-	// Download and upload to MinIO
-	if hash := h.uploadFileToMinIO(videoNote.File, "video/mp4"); hash != "" {
-		*name = stringPtr(hash)
-	} else {
-		*name = stringPtr(videoNote.FileID)
+	// Archived in MinIO once the message is stored, named by its hash from then on
+	*name = stringPtr(videoNote.FileID)
+	*upload = &mediaUpload{file: videoNote.File, contentType: "video/mp4"}
+}
+
+func (h *Handler) handleAudio(audio *tele.Audio, upload **mediaUpload, name **string, size **int64, mimeType **string, duration **int, metadata *json.RawMessage) {
+	fileSize := int64(audio.FileSize)
+	*size = &fileSize
+	*mimeType = stringPtr(audio.MIME)
+	d := audio.Duration
+	*duration = &d
+
+	audioMeta := make(map[string]interface{})
+	if audio.Performer != "" {
+		audioMeta["performer"] = audio.Performer
+	}
+	if audio.Title != "" {
+		audioMeta["title"] = audio.Title
+	}
+	if len(audioMeta) > 0 {
+		metaJSON, _ := json.Marshal(audioMeta)
+		*metadata = metaJSON
+	}
+
+	// Archived in MinIO once the message is stored, named by its hash from then on
+	*name = stringPtr(audio.FileName)
+	*upload = &mediaUpload{file: audio.File, contentType: audio.MIME}
+}
+
+// handleContact stores a shared contact card in metadata
+func (h *Handler) handleContact(contact *tele.Contact, metadata *json.RawMessage) {
+	contactMeta := map[string]interface{}{
+		"phone_number": contact.PhoneNumber,
+		"first_name":   contact.FirstName,
+	}
+	if contact.LastName != "" {
+		contactMeta["last_name"] = contact.LastName
+	}
+	if contact.UserID != 0 {
+		contactMeta["user_id"] = contact.UserID
+	}
+	if contact.VCard != "" {
+		contactMeta["vcard"] = contact.VCard
+	}
+
+	metaJSON, _ := json.Marshal(contactMeta)
+	*metadata = metaJSON
+}
+
+// handleDice stores the dice emoji and the rolled value in metadata
+func (h *Handler) handleDice(dice *tele.Dice, metadata *json.RawMessage) {
+	metaJSON, _ := json.Marshal(map[string]interface{}{
+		"emoji": dice.Type,
+		"value": dice.Value,
+	})
+	*metadata = metaJSON
+}
+
+// handleGame stores the game's identity in metadata
+func (h *Handler) handleGame(game *tele.Game, metadata *json.RawMessage) {
+	metaJSON, _ := json.Marshal(map[string]interface{}{
+		"short_name":  game.Name,
+		"title":       game.Title,
+		"description": game.Description,
+	})
+	*metadata = metaJSON
+}
+
+// handleStory stores a reference to a forwarded story in metadata
+func (h *Handler) handleStory(story *tele.Story, metadata *json.RawMessage) {
+	storyMeta := map[string]interface{}{
+		"story_id": story.ID,
+	}
+	if story.Poster != nil {
+		storyMeta["poster_chat_id"] = story.Poster.ID
+		storyMeta["poster_title"] = story.Poster.Title
+		storyMeta["poster_username"] = story.Poster.Username
+	}
+
+	metaJSON, _ := json.Marshal(storyMeta)
+	*metadata = metaJSON
+}
+
+// mediaUpload is a message's media file waiting to be archived in MinIO
+type mediaUpload struct {
+	file           tele.File
+	contentType    string
+	serviceMessage bool // the new chat photo of a service message
+}
+
+// archiveMedia uploads the media of a stored message to MinIO and links it to the
+// message, and reports whether it did. A failed attempt is recorded, for
+// RecoverUploads to retry. Its span belongs to the trace of the update, which
+// has usually ended by then.
+func (h *Handler) archiveMedia(ctx context.Context, messageID int64, upload *mediaUpload) bool {
+	ctx, span := tracing.StartChild(ctx, "media.archive", attribute.Int64("message_id", messageID))
+	defer span.End()
+
+	err := h.storeMedia(ctx, messageID, upload)
+	if err == nil {
+		return true
+	}
+
+	tracing.RecordError(span, err)
+	mark := h.store.MarkMediaUploadFailed
+	if upload.serviceMessage {
+		mark = h.store.MarkServiceMediaUploadFailed
+	}
+	if err := mark(ctx, messageID); err != nil {
+		slog.Error("failed to record failed media upload", "error", err, "message_id", messageID)
 	}
+	return false
 }
 
-// uploadFileToMinIO downloads a file from Telegram and uploads it to MinIO
-// Returns the SHA256 hash (object key) or empty string on error
-func (h *Handler) uploadFileToMinIO(file tele.File, contentType string) string {
-	ctx := context.Background()
+// storeMedia downloads the media of a stored message, uploads it and links it to the message
+func (h *Handler) storeMedia(ctx context.Context, messageID int64, upload *mediaUpload) error {
+	hash, data, err := h.downloadFile(upload.file)
+	if err != nil {
+		return err
+	}
+
+	return h.store.WithTx(ctx, func(tx *store.Tx) error {
+		stored, err := h.storeFile(ctx, tx, hash, data, upload.contentType)
+		if err != nil {
+			return err
+		}
+		if !stored {
+			return errors.New("media upload failed")
+		}
+		link := tx.SetMessageMedia
+		if upload.serviceMessage {
+			link = tx.SetServiceMessageMedia
+		}
+		if err := link(ctx, messageID, hash); err != nil {
+			slog.Error("failed to link media to message", "error", err, "message_id", messageID, "hash", hash)
+			return err
+		}
+		return nil
+	})
+}
 
-	// Get file reader from Telegram
+// downloadFile reads a file from Telegram and returns its SHA256 hash, the key it
+// is stored under in MinIO, with its contents
+func (h *Handler) downloadFile(file tele.File) (string, []byte, error) {
 	reader, err := h.bot.File(&file)
 	if err != nil {
 		slog.Error("failed to get file from Telegram",
 			"error", err,
 			"file_id", file.FileID)
-		return ""
+		return "", nil, err
 	}
 	defer reader.Close()
 
-	// Read file into buffer
-	var buf bytes.Buffer
-	if _, err := io.Copy(&buf, reader); err != nil {
+	hash, data, err := storage.ComputeSHA256(reader)
+	if err != nil {
 		slog.Error("failed to read file from Telegram",
 			"error", err,
 			"file_id", file.FileID)
-		return ""
+		return "", nil, err
 	}
+	return hash, data, nil
+}
 
-	// Upload to MinIO (with SHA256 deduplication)
-	hash, err := h.minioClient.UploadFile(ctx, &buf, contentType)
-	if err != nil {
+// storeFile uploads a file to MinIO unless it is stored already, and reports
+// whether it is. The blob's row stays locked until tx ends, so the file can't be
+// deleted as unreferenced before tx stores its reference. An error means tx
+// can't be used any more; a failed upload only returns false.
+func (h *Handler) storeFile(ctx context.Context, tx *store.Tx, hash string, data []byte, contentType string) (bool, error) {
+	if _, err := tx.LockBlob(ctx, hash); err != nil {
+		slog.Error("failed to lock media file", "error", err, "hash", hash)
+		return false, err
+	}
+
+	if err := h.minioClient.UploadFileWithHash(ctx, hash, data, contentType); err != nil {
 		slog.Error("failed to upload file to MinIO",
 			"error", err,
-			"file_id", file.FileID,
+			"hash", hash,
 			"content_type", contentType)
-		return ""
+		return false, nil
 	}
 
 	slog.Debug("file uploaded to MinIO",
-		"file_id", file.FileID,
 		"hash", hash,
-		"size", buf.Len(),
+		"size", len(data),
 		"content_type", contentType)
-
-	return hash
+	return true, nil
 }
 
 // handleLocation processes location messages with 15m distance filtering
@@ -398,6 +649,27 @@ func (h *Handler) handleVenue(msg *tele.Message, lat, lng **float64, venueTitle,
 	*venueAddress = &msg.Venue.Address
 }
 
+// userFromTelegram converts a Telegram user into its stored form
+func userFromTelegram(u *tele.User) *store.User {
+	user := &store.User{
+		ID:        u.ID,
+		Username:  u.Username,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		IsBot:     u.IsBot,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	// Telegram only sends language_code and is_premium along with a user's own
+	// actions; elsewhere both are missing and must not overwrite what we know
+	if u.LanguageCode != "" {
+		user.LanguageCode = &u.LanguageCode
+		user.IsPremium = &u.IsPremium
+	}
+	return user
+}
+
 func stringPtr(s string) *string {
 	return &s
 }
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/store"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// idSet is a set of chat or user ids safe for concurrent use
+type idSet struct {
+	mu  sync.RWMutex
+	ids map[int64]bool
+}
+
+func newIDSet() *idSet {
+	return &idSet{ids: make(map[int64]bool)}
+}
+
+// Has reports whether id is in the set
+func (s *idSet) Has(id int64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ids[id]
+}
+
+// Set adds id to the set, or removes it
+func (s *idSet) Set(id int64, in bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if in {
+		s.ids[id] = true
+	} else {
+		delete(s.ids, id)
+	}
+}
+
+// LoadInactiveChats loads the chats the bot was removed from. Updates from these
+// chats are dropped by ProcessUpdate until the bot is added back.
+func (h *Handler) LoadInactiveChats(ctx context.Context) error {
+	ids, err := h.store.ListInactiveChatIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		h.inactiveChats.Set(id, true)
+	}
+	return nil
+}
+
+// HandleChatMember processes status changes of chat members: joins, leaves,
+// promotions, restrictions and bans. Telegram only sends these to admin bots.
+func (h *Handler) HandleChatMember(c tele.Context) error {
+	update := c.ChatMember()
+	err := h.saveUpdate(c, func(tx *store.Tx) error {
+		_, err := h.recordChatMember(h.updateContext(c), tx, update, "chat_member")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Promotions and demotions change who may run admin commands
+	if isAdminRole(update.OldChatMember.Role) || isAdminRole(update.NewChatMember.Role) {
+		h.admins.Invalidate(update.Chat.ID)
+	}
+	return nil
+}
+
+// HandleMyChatMember processes changes of the bot's own status in a chat. When the
+// bot is removed the chat is marked inactive and no longer ingested.
+func (h *Handler) HandleMyChatMember(c tele.Context) error {
+	update := c.ChatMember()
+	ctx := h.updateContext(c)
+
+	status := update.NewChatMember.Role
+	active := inChat(update.NewChatMember)
+	err := h.saveUpdate(c, func(tx *store.Tx) error {
+		if _, err := h.recordChatMember(ctx, tx, update, "my_chat_member"); err != nil {
+			return err
+		}
+		if err := tx.SetChatBotStatus(ctx, update.Chat.ID, string(status), active, time.Unix(update.Unixtime, 0)); err != nil {
+			slog.Error("failed to update chat bot status", "error", err, "chat_id", update.Chat.ID)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Only once committed, so an update that failed to save and is retried
+	// doesn't find its chat already (de)activated
+	h.inactiveChats.Set(update.Chat.ID, !active)
+
+	if active {
+		slog.Info("bot status changed", "chat_id", update.Chat.ID, "status", status)
+	} else {
+		slog.Warn("bot removed from chat, ingestion stopped", "chat_id", update.Chat.ID, "status", status)
+	}
+	return nil
+}
+
+// recordChatMember stores the new status carried by a chat member update
+func (h *Handler) recordChatMember(ctx context.Context, tx *store.Tx, update *tele.ChatMemberUpdate, source string) (bool, error) {
+	newMember := update.NewChatMember
+
+	// Upsert chat
+	chat := &store.Chat{
+		ID:        update.Chat.ID,
+		Type:      string(update.Chat.Type),
+		Name:      update.Chat.Title,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := tx.UpsertChat(ctx, chat); err != nil {
+		slog.Error("failed to upsert chat", "error", err, "chat_id", update.Chat.ID)
+		return false, err
+	}
+
+	// Upsert member and actor
+	var actorID *int64
+	for _, u := range []*tele.User{newMember.User, update.Sender} {
+		if u == nil {
+			continue
+		}
+		user := userFromTelegram(u)
+		if err := tx.UpsertUser(ctx, user); err != nil {
+			slog.Error("failed to upsert user", "error", err, "user_id", u.ID)
+			return false, err
+		}
+	}
+	if update.Sender != nil {
+		id := update.Sender.ID
+		actorID = &id
+	}
+
+	member := &store.ChatMember{
+		ChatID:   update.Chat.ID,
+		UserID:   newMember.User.ID,
+		Status:   string(newMember.Role),
+		IsMember: inChat(newMember),
+	}
+	if newMember.Title != "" {
+		member.CustomTitle = &newMember.Title
+	}
+	if newMember.RestrictedUntil > 0 {
+		until := time.Unix(newMember.RestrictedUntil, 0)
+		member.UntilDate = &until
+	}
+	if newMember.Role == tele.Administrator || newMember.Role == tele.Restricted {
+		member.Rights, _ = json.Marshal(newMember.Rights)
+	}
+
+	metadata := map[string]interface{}{}
+	if update.InviteLink != nil {
+		metadata["invite_link"] = update.InviteLink.InviteLink
+		if update.InviteLink.Name != "" {
+			metadata["invite_link_name"] = update.InviteLink.Name
+		}
+	}
+	if update.ViaJoinRequest {
+		metadata["via_join_request"] = true
+	}
+	if update.ViaFolderLink {
+		metadata["via_chat_folder_invite_link"] = true
+	}
+
+	change := &store.ChatMemberChange{
+		ActorUserID: actorID,
+		Source:      source,
+		ChangedAt:   time.Unix(update.Unixtime, 0),
+	}
+	if len(metadata) > 0 {
+		change.Metadata, _ = json.Marshal(metadata)
+	}
+
+	changed, err := tx.UpdateChatMember(ctx, member, change)
+	if err != nil {
+		slog.Error("failed to update chat member", "error", err,
+			"chat_id", update.Chat.ID,
+			"user_id", newMember.User.ID)
+		return false, err
+	}
+
+	if changed {
+		slog.Info("chat member updated",
+			"chat_id", update.Chat.ID,
+			"user_id", newMember.User.ID,
+			"old_status", update.OldChatMember.Role,
+			"new_status", newMember.Role)
+	}
+	return changed, nil
+}
+
+// recordMembership reconciles chat_members with a join or leave service message.
+// When the bot itself joins or leaves, the chat is (de)activated as well, and it
+// reports so; the caller updates inactiveChats once the transaction is committed.
+func (h *Handler) recordMembership(ctx context.Context, tx *store.Tx, msg *tele.Message, user *tele.User, joined bool, actorID *int64) (bool, error) {
+	change := &store.ChatMemberChange{
+		ActorUserID: actorID,
+		Source:      "service_message",
+		ChangedAt:   time.Unix(msg.Unixtime, 0),
+	}
+	if _, err := tx.SetChatMembership(ctx, msg.Chat.ID, user.ID, joined, change); err != nil {
+		slog.Error("failed to update chat membership", "error", err, "chat_id", msg.Chat.ID, "user_id", user.ID)
+		return false, err
+	}
+
+	if h.bot.Me == nil || user.ID != h.bot.Me.ID {
+		return false, nil
+	}
+
+	status := store.MemberStatusLeft
+	if joined {
+		status = store.MemberStatusMember
+	}
+	if err := tx.SetChatBotStatus(ctx, msg.Chat.ID, status, joined, change.ChangedAt); err != nil {
+		slog.Error("failed to update chat bot status", "error", err, "chat_id", msg.Chat.ID)
+		return false, err
+	}
+	return true, nil
+}
+
+// inChat reports whether a member status means the user is in the chat
+func inChat(m *tele.ChatMember) bool {
+	switch m.Role {
+	case tele.Creator, tele.Administrator, tele.Member:
+		return true
+	case tele.Restricted:
+		return m.Member
+	default:
+		return false
+	}
+}
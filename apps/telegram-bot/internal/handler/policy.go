@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"beef-briefing/apps/telegram-bot/internal/store"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// messageTypes are the message types HandleMessage stores, which chat settings can select from
+var messageTypes = []string{
+	"text", "photo", "video", "voice", "document", "sticker", "animation", "video_note",
+	"location", "venue", "audio", "contact", "dice", "game", "story", "poll",
+}
+
+// chatPolicies caches the settings of every chat, so updates can be checked
+// against them before anything is written. Settings only change through the
+// bot, which updates the cache once they are saved.
+type chatPolicies struct {
+	mu       sync.RWMutex
+	settings map[int64]*store.ChatSettings
+}
+
+func newChatPolicies() *chatPolicies {
+	return &chatPolicies{settings: make(map[int64]*store.ChatSettings)}
+}
+
+// Get returns the settings of a chat, or the defaults if it has none
+func (p *chatPolicies) Get(chatID int64) *store.ChatSettings {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if cs, ok := p.settings[chatID]; ok {
+		return cs
+	}
+	return store.DefaultChatSettings(chatID)
+}
+
+// Set replaces the settings of a chat. The settings must not be modified afterwards.
+func (p *chatPolicies) Set(cs *store.ChatSettings) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.settings[cs.ChatID] = cs
+}
+
+// Migrate gives chat to the settings of chat from, unless it has its own
+func (p *chatPolicies) Migrate(from, to int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.settings[to]; ok {
+		return
+	}
+	if cs, ok := p.settings[from]; ok {
+		migrated := *cs
+		migrated.ChatID = to
+		p.settings[to] = &migrated
+	}
+}
+
+// LoadChatSettings loads the settings of every chat. Updates from chats that are
+// not allowed are dropped by ProcessUpdate.
+func (h *Handler) LoadChatSettings(ctx context.Context) error {
+	list, err := h.store.ListChatSettings(ctx)
+	if err != nil {
+		return err
+	}
+	for _, cs := range list {
+		h.policies.Set(cs)
+	}
+	return nil
+}
+
+// allowedUpdate reports whether an update of chatID may be processed: the chat is
+// ingested, or the update is a command, so the chat can be allowed with /settings
+// or resumed. The first message of a supergroup is checked against the group it
+// was migrated from, whose settings it takes over.
+func (h *Handler) allowedUpdate(u *tele.Update, chatID int64) bool {
+	if ingests(h.policies.Get(chatID)) {
+		return true
+	}
+
+	msg := u.Message
+	if msg == nil {
+		return false
+	}
+	if msg.MigrateFrom != 0 && msg.MigrateTo == 0 {
+		return ingests(h.policies.Get(msg.MigrateFrom))
+	}
+	return strings.HasPrefix(msg.Text, "/")
+}
+
+// ingests reports whether the settings let the chat be ingested: it is on the
+// allowlist and not paused
+func ingests(cs *store.ChatSettings) bool {
+	return cs.Allowed && !cs.Paused
+}
+
+// storesType reports whether the settings store messages of the given type
+func storesType(cs *store.ChatSettings, messageType string) bool {
+	return len(cs.MessageTypes) == 0 || slices.Contains(cs.MessageTypes, messageType)
+}
+
+// storesMedia reports whether the settings allow downloading a media file of the
+// given size, if known
+func storesMedia(cs *store.ChatSettings, size *int64) bool {
+	if !cs.StoreMedia {
+		return false
+	}
+	return cs.MaxMediaSize == nil || size == nil || *size <= *cs.MaxMediaSize
+}
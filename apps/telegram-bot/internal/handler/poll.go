@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/store"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// HandlePoll processes poll state updates (vote counts, closing). Poll updates
+// carry no chat, so only polls stored from a message, and thus from a chat on
+// the allowlist, are updated.
+func (h *Handler) HandlePoll(c tele.Context) error {
+	poll := c.Poll()
+	ctx := h.updateContext(c)
+
+	exists, err := h.store.PollExists(ctx, poll.ID)
+	if err != nil {
+		slog.Error("failed to check poll", "error", err, "poll_id", poll.ID)
+		return err
+	}
+	if !exists {
+		slog.Debug("update of unknown poll ignored", "poll_id", poll.ID)
+		return nil
+	}
+
+	err = h.saveUpdate(c, func(tx *store.Tx) error {
+		return tx.UpsertPoll(ctx, pollFromTelegram(poll))
+	})
+	if err != nil {
+		slog.Error("failed to update poll", "error", err, "poll_id", poll.ID)
+		return err
+	}
+
+	slog.Debug("poll updated", "poll_id", poll.ID, "closed", poll.Closed, "voters", poll.VoterCount)
+	return nil
+}
+
+// HandlePollAnswer processes a user's vote change in a non-anonymous poll
+func (h *Handler) HandlePollAnswer(c tele.Context) error {
+	answer := c.PollAnswer()
+	ctx := h.updateContext(c)
+
+	// Votes cast on behalf of a chat carry no user; votes of users who opted out are not stored
+	if answer.Sender == nil || h.optedOut.Has(answer.Sender.ID) {
+		return nil
+	}
+
+	exists, err := h.store.PollExists(ctx, answer.PollID)
+	if err != nil {
+		slog.Error("failed to check poll", "error", err, "poll_id", answer.PollID)
+		return err
+	}
+	if !exists {
+		slog.Debug("vote for unknown poll ignored", "poll_id", answer.PollID)
+		return nil
+	}
+
+	vote := &store.PollVote{
+		PollID:    answer.PollID,
+		UserID:    answer.Sender.ID,
+		OptionIDs: answer.Options,
+		VotedAt:   time.Now(),
+	}
+	err = h.saveUpdate(c, func(tx *store.Tx) error {
+		user := userFromTelegram(answer.Sender)
+		if err := tx.UpsertUser(ctx, user); err != nil {
+			slog.Error("failed to upsert voter", "error", err, "user_id", answer.Sender.ID)
+			return err
+		}
+
+		if err := tx.UpsertPollVote(ctx, vote); err != nil {
+			slog.Error("failed to store poll vote", "error", err, "poll_id", answer.PollID)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("poll vote processed",
+		"poll_id", answer.PollID,
+		"user_id", answer.Sender.ID,
+		"options", answer.Options)
+	return nil
+}
+
+// savePoll stores the poll carried by a message, linked to that message
+func (h *Handler) savePoll(ctx context.Context, tx *store.Tx, msg *tele.Message) error {
+	poll := pollFromTelegram(msg.Poll)
+
+	chatID := msg.Chat.ID
+	messageID := int64(msg.ID)
+	poll.ChatID = &chatID
+	poll.TelegramMessageID = &messageID
+	if msg.Sender != nil {
+		userID := msg.Sender.ID
+		poll.CreatorUserID = &userID
+	}
+
+	return tx.UpsertPoll(ctx, poll)
+}
+
+// pollFromTelegram converts a Telegram poll into its stored form
+func pollFromTelegram(p *tele.Poll) *store.Poll {
+	poll := &store.Poll{
+		ID:                    p.ID,
+		Question:              p.Question,
+		Type:                  string(p.Type),
+		IsAnonymous:           p.Anonymous,
+		AllowsMultipleAnswers: p.MultipleAnswers,
+		TotalVoterCount:       p.VoterCount,
+		IsClosed:              p.Closed,
+	}
+
+	// Telegram only reveals the correct answer of a quiz once it is closed
+	if p.IsQuiz() && p.Closed {
+		correct := p.CorrectOption
+		poll.CorrectOptionID = &correct
+	}
+	if p.Explanation != "" {
+		poll.Explanation = &p.Explanation
+	}
+	if p.OpenPeriod != 0 {
+		period := p.OpenPeriod
+		poll.OpenPeriodSeconds = &period
+	}
+	if p.CloseUnixdate != 0 {
+		closeDate := p.CloseDate()
+		poll.CloseDate = &closeDate
+	}
+
+	for i, opt := range p.Options {
+		poll.Options = append(poll.Options, store.PollOption{
+			Index:      i,
+			Text:       opt.Text,
+			VoterCount: opt.VoterCount,
+		})
+	}
+	return poll
+}
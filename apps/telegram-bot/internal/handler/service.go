@@ -8,95 +8,574 @@ import (
 
 	"beef-briefing/apps/telegram-bot/internal/store"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	tele "gopkg.in/telebot.v4"
 )
 
-// HandleUserJoined processes user joined events
+// HandleUserJoined processes user joined events. A message may carry several
+// users, added at once; they are stored as one service message.
 func (h *Handler) HandleUserJoined(c tele.Context) error {
 	msg := c.Message()
-	ctx := context.Background()
+	ctx := h.updateContext(c)
+	users := joinedUsers(msg)
 
-	// Upsert chat
-	chat := &store.Chat{
-		ID:        msg.Chat.ID,
-		Type:      string(msg.Chat.Type),
-		Name:      msg.Chat.Title,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-	if err := h.store.UpsertChat(ctx, chat); err != nil {
-		slog.Error("failed to upsert chat", "error", err)
+	// The bot being added reactivates the chat once the update is committed
+	var botJoined bool
+	err := h.saveUpdate(c, func(tx *store.Tx) error {
+		// Upsert chat
+		chat := &store.Chat{
+			ID:        msg.Chat.ID,
+			Type:      string(msg.Chat.Type),
+			Name:      msg.Chat.Title,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		if err := tx.UpsertChat(ctx, chat); err != nil {
+			slog.Error("failed to upsert chat", "error", err)
+			return err
+		}
+
+		// Upsert joined users
+		for i := range users {
+			user := userFromTelegram(&users[i])
+			if err := tx.UpsertUser(ctx, user); err != nil {
+				slog.Error("failed to upsert joined user", "error", err)
+				return err
+			}
+		}
+
+		// Upsert actor (the person who added the users)
+		var actorID *int64
+		if msg.Sender != nil {
+			user := userFromTelegram(msg.Sender)
+			if err := tx.UpsertUser(ctx, user); err != nil {
+				slog.Error("failed to upsert actor user", "error", err)
+				return err
+			}
+			id := msg.Sender.ID
+			actorID = &id
+		}
+
+		// Create metadata
+		joined := make([]map[string]interface{}, 0, len(users))
+		for _, u := range users {
+			joined = append(joined, map[string]interface{}{
+				"user_id":    u.ID,
+				"username":   u.Username,
+				"first_name": u.FirstName,
+				"last_name":  u.LastName,
+			})
+		}
+		metadataJSON, _ := json.Marshal(map[string]interface{}{"joined_users": joined})
+
+		// Insert service message
+		serviceMsg := &store.ServiceMessage{
+			TelegramMessageID: int64(msg.ID),
+			ChatID:            msg.Chat.ID,
+			ActorUserID:       actorID,
+			MessageDate:       time.Unix(msg.Unixtime, 0),
+			Action:            "user_joined",
+			Metadata:          metadataJSON,
+		}
+
+		if err := tx.InsertServiceMessage(ctx, serviceMsg); err != nil {
+			slog.Error("failed to insert service message", "error", err)
+			return err
+		}
+
+		for i := range users {
+			isBot, err := h.recordMembership(ctx, tx, msg, &users[i], true, actorID)
+			if err != nil {
+				return err
+			}
+			botJoined = botJoined || isBot
+		}
+		return nil
+	})
+	if err != nil {
 		return err
 	}
+	if botJoined {
+		h.inactiveChats.Set(msg.Chat.ID, false)
+	}
 
-	// Upsert joined user
+	slog.Info("user joined event processed", "chat_id", msg.Chat.ID, "telegram_message_id", msg.ID, "users", len(users))
+	return nil
+}
+
+// joinedUsers returns the users a join message carries. new_chat_member, which
+// telebot reads into UserJoined, only holds the first of new_chat_members.
+func joinedUsers(msg *tele.Message) []tele.User {
+	if len(msg.UsersJoined) > 0 {
+		return msg.UsersJoined
+	}
 	if msg.UserJoined != nil {
-		user := &store.User{
-			ID:        msg.UserJoined.ID,
-			Username:  msg.UserJoined.Username,
-			FirstName: msg.UserJoined.FirstName,
-			LastName:  msg.UserJoined.LastName,
+		return []tele.User{*msg.UserJoined}
+	}
+	return nil
+}
+
+// HandleUserLeft processes user left events
+func (h *Handler) HandleUserLeft(c tele.Context) error {
+	msg := c.Message()
+	ctx := h.updateContext(c)
+
+	// The bot leaving deactivates the chat once the update is committed
+	var botLeft bool
+	err := h.saveUpdate(c, func(tx *store.Tx) error {
+		// Upsert chat
+		chat := &store.Chat{
+			ID:        msg.Chat.ID,
+			Type:      string(msg.Chat.Type),
+			Name:      msg.Chat.Title,
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		}
-		if err := h.store.UpsertUser(ctx, user); err != nil {
-			slog.Error("failed to upsert joined user", "error", err)
+		if err := tx.UpsertChat(ctx, chat); err != nil {
+			slog.Error("failed to upsert chat", "error", err)
 			return err
 		}
-	}
 
-	// Upsert actor (the person who added the user)
-	var actorID *int64
-	if msg.Sender != nil {
-		user := &store.User{
-			ID:        msg.Sender.ID,
-			Username:  msg.Sender.Username,
-			FirstName: msg.Sender.FirstName,
-			LastName:  msg.Sender.LastName,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+		// Upsert left user
+		if msg.UserLeft != nil {
+			user := userFromTelegram(msg.UserLeft)
+			if err := tx.UpsertUser(ctx, user); err != nil {
+				slog.Error("failed to upsert left user", "error", err)
+				return err
+			}
 		}
-		if err := h.store.UpsertUser(ctx, user); err != nil {
-			slog.Error("failed to upsert actor user", "error", err)
+
+		// Create metadata
+		metadata := map[string]interface{}{}
+		if msg.UserLeft != nil {
+			metadata["left_user_id"] = msg.UserLeft.ID
+			metadata["left_username"] = msg.UserLeft.Username
+			metadata["left_first_name"] = msg.UserLeft.FirstName
+			metadata["left_last_name"] = msg.UserLeft.LastName
 		}
-		id := msg.Sender.ID
-		actorID = &id
+		metadataJSON, _ := json.Marshal(metadata)
+
+		// Insert service message
+		serviceMsg := &store.ServiceMessage{
+			TelegramMessageID: int64(msg.ID),
+			ChatID:            msg.Chat.ID,
+			MessageDate:       time.Unix(msg.Unixtime, 0),
+			Action:            "user_left",
+			Metadata:          metadataJSON,
+		}
+
+		if err := tx.InsertServiceMessage(ctx, serviceMsg); err != nil {
+			slog.Error("failed to insert service message", "error", err)
+			return err
+		}
+
+		if msg.UserLeft != nil {
+			var err error
+			if botLeft, err = h.recordMembership(ctx, tx, msg, msg.UserLeft, false, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if botLeft {
+		h.inactiveChats.Set(msg.Chat.ID, true)
 	}
 
-	// Create metadata
-	metadata := map[string]interface{}{}
-	if msg.UserJoined != nil {
-		metadata["joined_user_id"] = msg.UserJoined.ID
-		metadata["joined_username"] = msg.UserJoined.Username
-		metadata["joined_first_name"] = msg.UserJoined.FirstName
-		metadata["joined_last_name"] = msg.UserJoined.LastName
+	slog.Info("user left event processed", "chat_id", msg.Chat.ID, "telegram_message_id", msg.ID)
+	return nil
+}
+
+// HandleAddedToGroup processes group creation and the bot being added to a group.
+// Telebot routes both here instead of to the created/joined endpoints.
+func (h *Handler) HandleAddedToGroup(c tele.Context) error {
+	msg := c.Message()
+
+	if msg.GroupCreated || msg.SuperGroupCreated || msg.ChannelCreated {
+		return h.HandleChatCreated(c)
 	}
-	metadataJSON, _ := json.Marshal(metadata)
 
-	// Insert service message
-	serviceMsg := &store.ServiceMessage{
-		TelegramMessageID: int64(msg.ID),
-		ChatID:            msg.Chat.ID,
-		ActorUserID:       actorID,
-		MessageDate:       time.Unix(msg.Unixtime, 0),
-		Action:            "user_joined",
-		Metadata:          metadataJSON,
+	if len(joinedUsers(msg)) > 0 {
+		return h.HandleUserJoined(c)
+	}
+	return nil
+}
+
+// HandleChatCreated processes group, supergroup and channel creation events
+func (h *Handler) HandleChatCreated(c tele.Context) error {
+	msg := c.Message()
+
+	kind := "group"
+	if msg.SuperGroupCreated {
+		kind = "supergroup"
+	} else if msg.ChannelCreated {
+		kind = "channel"
+	}
+
+	metadata := map[string]interface{}{
+		"chat_type": kind,
+		"title":     msg.Chat.Title,
+	}
+	return h.saveServiceMessage(c, "chat_created", metadata)
+}
+
+// HandleNewGroupTitle processes chat title changes
+func (h *Handler) HandleNewGroupTitle(c tele.Context) error {
+	msg := c.Message()
+
+	metadata := map[string]interface{}{
+		"new_title": msg.NewGroupTitle,
+	}
+	return h.saveServiceMessage(c, "title_changed", metadata)
+}
+
+// HandleNewGroupPhoto processes chat photo changes. The new photo is archived in
+// MinIO on the upload workers, like message media.
+func (h *Handler) HandleNewGroupPhoto(c tele.Context) error {
+	msg := c.Message()
+	ctx := h.updateContext(c)
+	photo := msg.NewGroupPhoto
+
+	metadata := map[string]interface{}{
+		"file_id": photo.FileID,
+		"width":   photo.Width,
+		"height":  photo.Height,
+	}
+
+	// Without the photo, the change is still recorded
+	var upload *mediaUpload
+	var size *int64
+	if photo.FileSize > 0 {
+		fileSize := photo.FileSize
+		size = &fileSize
+	}
+	if storesMedia(h.policies.Get(msg.Chat.ID), size) {
+		upload = &mediaUpload{file: photo.File, contentType: "image/jpeg", serviceMessage: true}
+	} else {
+		slog.Debug("chat photo not downloaded in chat", "chat_id", msg.Chat.ID, "size", size)
 	}
 
-	if err := h.store.InsertServiceMessage(ctx, serviceMsg); err != nil {
-		slog.Error("failed to insert service message", "error", err)
+	var serviceMessageID int64
+	err := h.saveUpdate(c, func(tx *store.Tx) error {
+		id, err := h.insertServiceMessage(ctx, tx, msg, "photo_changed", metadata, upload)
+		serviceMessageID = id
+		return err
+	})
+	if err != nil {
 		return err
 	}
 
-	slog.Info("user joined event processed", "chat_id", msg.Chat.ID, "telegram_message_id", msg.ID)
+	if upload != nil && serviceMessageID != 0 {
+		h.uploads.Submit(func() { h.archiveMedia(ctx, serviceMessageID, upload) })
+	}
 	return nil
 }
 
-// HandleUserLeft processes user left events
-func (h *Handler) HandleUserLeft(c tele.Context) error {
+// HandleGroupPhotoDeleted processes chat photo removals
+func (h *Handler) HandleGroupPhotoDeleted(c tele.Context) error {
+	return h.saveServiceMessage(c, "photo_deleted", nil)
+}
+
+// HandlePinned processes pinned message events
+func (h *Handler) HandlePinned(c tele.Context) error {
 	msg := c.Message()
-	ctx := context.Background()
+	pinned := msg.PinnedMessage
 
+	metadata := map[string]interface{}{
+		"pinned_message_id": pinned.ID,
+	}
+	if pinned.Text != "" {
+		metadata["pinned_text"] = pinned.Text
+	} else if pinned.Caption != "" {
+		metadata["pinned_text"] = pinned.Caption
+	}
+
+	return h.saveServiceMessage(c, "message_pinned", metadata)
+}
+
+// HandleMigration processes a group being upgraded to a supergroup. The old group
+// receives a message carrying the new id; the old id is linked to it so the history
+// of both stays one logical chat.
+func (h *Handler) HandleMigration(c tele.Context) error {
+	msg := c.Message()
+	ctx := h.updateContext(c)
+	from, to := c.Migration()
+
+	metadata := map[string]interface{}{
+		"from_chat_id": from,
+		"to_chat_id":   to,
+	}
+	err := h.saveUpdate(c, func(tx *store.Tx) error {
+		if err := h.storeServiceMessage(ctx, tx, msg, "migrated_to_supergroup", metadata); err != nil {
+			return err
+		}
+		return h.linkChatMigration(ctx, tx, msg, from, to)
+	})
+	if err != nil {
+		return err
+	}
+	h.policies.Migrate(from, to)
+	return nil
+}
+
+// HandleMigratedFrom processes the first message of a supergroup created from a group,
+// which carries the old group's id. Telegram sends it alongside the message handled by
+// HandleMigration, but either may arrive first, or alone.
+func (h *Handler) HandleMigratedFrom(c tele.Context) error {
+	msg := c.Message()
+	ctx := h.updateContext(c)
+	from, to := msg.MigrateFrom, msg.Chat.ID
+
+	metadata := map[string]interface{}{
+		"from_chat_id": from,
+		"to_chat_id":   to,
+	}
+	err := h.saveUpdate(c, func(tx *store.Tx) error {
+		if err := h.linkChatMigration(ctx, tx, msg, from, to); err != nil {
+			return err
+		}
+		return h.storeServiceMessage(ctx, tx, msg, "migrated_from_group", metadata)
+	})
+	if err != nil {
+		return err
+	}
+	h.policies.Migrate(from, to)
+	return nil
+}
+
+// linkChatMigration records that chat from became supergroup to
+func (h *Handler) linkChatMigration(ctx context.Context, tx *store.Tx, msg *tele.Message, from, to int64) error {
+	if err := tx.LinkChatMigration(ctx, from, to, msg.Chat.Title); err != nil {
+		slog.Error("failed to link migrated chat", "error", err, "from_chat_id", from, "to_chat_id", to)
+		return err
+	}
+
+	slog.Info("chat migrated to supergroup", "from_chat_id", from, "to_chat_id", to)
+	return nil
+}
+
+// HandleVideoChatStarted processes video chat start events
+func (h *Handler) HandleVideoChatStarted(c tele.Context) error {
+	return h.saveServiceMessage(c, "video_chat_started", nil)
+}
+
+// HandleVideoChatEnded processes video chat end events
+func (h *Handler) HandleVideoChatEnded(c tele.Context) error {
+	msg := c.Message()
+
+	metadata := map[string]interface{}{
+		"duration_seconds": msg.VideoChatEnded.Duration,
+	}
+	return h.saveServiceMessage(c, "video_chat_ended", metadata)
+}
+
+// HandleVideoChatScheduled processes scheduled video chat events
+func (h *Handler) HandleVideoChatScheduled(c tele.Context) error {
+	msg := c.Message()
+
+	metadata := map[string]interface{}{
+		"start_date": msg.VideoChatScheduled.StartsAt(),
+	}
+	return h.saveServiceMessage(c, "video_chat_scheduled", metadata)
+}
+
+// HandleVideoChatParticipants processes video chat invitations
+func (h *Handler) HandleVideoChatParticipants(c tele.Context) error {
+	msg := c.Message()
+
+	var userIDs []int64
+	for _, u := range msg.VideoChatParticipants.Users {
+		userIDs = append(userIDs, u.ID)
+	}
+	metadata := map[string]interface{}{
+		"invited_user_ids": userIDs,
+	}
+	return h.saveServiceMessage(c, "video_chat_participants_invited", metadata)
+}
+
+// HandleAutoDeleteTimer processes auto-delete timer changes
+func (h *Handler) HandleAutoDeleteTimer(c tele.Context) error {
+	msg := c.Message()
+
+	metadata := map[string]interface{}{
+		"auto_delete_seconds": msg.AutoDeleteTimer.Unixtime,
+	}
+	return h.saveServiceMessage(c, "auto_delete_timer_changed", metadata)
+}
+
+// HandleTopicCreated processes forum topic creation
+func (h *Handler) HandleTopicCreated(c tele.Context) error {
+	msg := c.Message()
+	ctx := h.updateContext(c)
+
+	// The message creating a topic is the first message of its thread
+	topic := forumTopicFromTelegram(msg.Chat.ID, topicThreadID(msg), msg.TopicCreated)
+	createdAt := msg.Time()
+	topic.TopicCreatedAt = &createdAt
+	if msg.Sender != nil {
+		userID := msg.Sender.ID
+		topic.CreatedByUserID = &userID
+	}
+
+	return h.saveUpdate(c, func(tx *store.Tx) error {
+		if err := h.storeServiceMessage(ctx, tx, msg, "topic_created", topicMetadata(msg, msg.TopicCreated)); err != nil {
+			return err
+		}
+		return h.saveForumTopic(ctx, tx, topic)
+	})
+}
+
+// HandleTopicEdited processes forum topic name or icon changes
+func (h *Handler) HandleTopicEdited(c tele.Context) error {
+	msg := c.Message()
+	ctx := h.updateContext(c)
+
+	return h.saveUpdate(c, func(tx *store.Tx) error {
+		if err := h.storeServiceMessage(ctx, tx, msg, "topic_edited", topicMetadata(msg, msg.TopicEdited)); err != nil {
+			return err
+		}
+		return h.saveForumTopic(ctx, tx, forumTopicFromTelegram(msg.Chat.ID, topicThreadID(msg), msg.TopicEdited))
+	})
+}
+
+// HandleTopicClosed processes forum topics being closed
+func (h *Handler) HandleTopicClosed(c tele.Context) error {
+	msg := c.Message()
+	ctx := h.updateContext(c)
+
+	return h.saveUpdate(c, func(tx *store.Tx) error {
+		if err := h.storeServiceMessage(ctx, tx, msg, "topic_closed", topicMetadata(msg, nil)); err != nil {
+			return err
+		}
+		return h.setForumTopicClosed(ctx, tx, msg, true)
+	})
+}
+
+// HandleTopicReopened processes forum topics being reopened
+func (h *Handler) HandleTopicReopened(c tele.Context) error {
+	msg := c.Message()
+	ctx := h.updateContext(c)
+
+	return h.saveUpdate(c, func(tx *store.Tx) error {
+		if err := h.storeServiceMessage(ctx, tx, msg, "topic_reopened", topicMetadata(msg, msg.TopicReopened)); err != nil {
+			return err
+		}
+		return h.setForumTopicClosed(ctx, tx, msg, false)
+	})
+}
+
+// saveForumTopic creates or updates a forum topic
+func (h *Handler) saveForumTopic(ctx context.Context, tx *store.Tx, topic *store.ForumTopic) error {
+	if err := tx.UpsertForumTopic(ctx, topic); err != nil {
+		slog.Error("failed to store forum topic", "error", err,
+			"chat_id", topic.ChatID,
+			"message_thread_id", topic.MessageThreadID)
+		return err
+	}
+	return nil
+}
+
+// setForumTopicClosed records the topic of msg being closed or reopened
+func (h *Handler) setForumTopicClosed(ctx context.Context, tx *store.Tx, msg *tele.Message, closed bool) error {
+	threadID := topicThreadID(msg)
+	if err := tx.SetForumTopicClosed(ctx, msg.Chat.ID, threadID, closed, msg.Time()); err != nil {
+		slog.Error("failed to update forum topic", "error", err,
+			"chat_id", msg.Chat.ID,
+			"message_thread_id", threadID)
+		return err
+	}
+	return nil
+}
+
+// HandleGeneralTopicHidden processes the General topic being hidden
+func (h *Handler) HandleGeneralTopicHidden(c tele.Context) error {
+	return h.saveServiceMessage(c, "general_topic_hidden", nil)
+}
+
+// HandleGeneralTopicUnhidden processes the General topic being unhidden
+func (h *Handler) HandleGeneralTopicUnhidden(c tele.Context) error {
+	return h.saveServiceMessage(c, "general_topic_unhidden", nil)
+}
+
+// topicThreadID returns the thread id of the topic a topic service message refers to.
+// A topic's id is the id of the message that created it.
+func topicThreadID(msg *tele.Message) int64 {
+	if msg.ThreadID != 0 {
+		return int64(msg.ThreadID)
+	}
+	return int64(msg.ID)
+}
+
+// topicID returns the forum topic a message was posted in, or nil outside topics.
+// Replies in regular supergroups also carry a thread id, so only topic messages count.
+func topicID(msg *tele.Message) *int64 {
+	if !msg.TopicMessage || msg.ThreadID == 0 {
+		return nil
+	}
+	threadID := int64(msg.ThreadID)
+	return &threadID
+}
+
+// forumTopicFromTelegram converts a Telegram topic into its stored form
+func forumTopicFromTelegram(chatID, threadID int64, t *tele.Topic) *store.ForumTopic {
+	topic := &store.ForumTopic{
+		ChatID:          chatID,
+		MessageThreadID: threadID,
+	}
+	if t == nil {
+		return topic
+	}
+	topic.Name = t.Name
+	if t.IconColor != 0 {
+		color := t.IconColor
+		topic.IconColor = &color
+	}
+	if t.IconCustomEmojiID != "" {
+		topic.IconCustomEmojiID = &t.IconCustomEmojiID
+	}
+	return topic
+}
+
+// topicMetadata describes the forum topic a service message refers to
+func topicMetadata(msg *tele.Message, topic *tele.Topic) map[string]interface{} {
+	metadata := map[string]interface{}{
+		"message_thread_id": msg.ThreadID,
+	}
+	if topic != nil {
+		if topic.Name != "" {
+			metadata["name"] = topic.Name
+		}
+		if topic.IconColor != 0 {
+			metadata["icon_color"] = topic.IconColor
+		}
+		if topic.IconCustomEmojiID != "" {
+			metadata["icon_custom_emoji_id"] = topic.IconCustomEmojiID
+		}
+	}
+	return metadata
+}
+
+// saveServiceMessage stores the service message of c, with its chat and acting user,
+// in its own transaction
+func (h *Handler) saveServiceMessage(c tele.Context, action string, metadata map[string]interface{}) error {
+	return h.saveUpdate(c, func(tx *store.Tx) error {
+		return h.storeServiceMessage(h.updateContext(c), tx, c.Message(), action, metadata)
+	})
+}
+
+// storeServiceMessage upserts the chat and the acting user, then records a typed service event
+func (h *Handler) storeServiceMessage(ctx context.Context, tx *store.Tx, msg *tele.Message, action string, metadata map[string]interface{}) error {
+	_, err := h.insertServiceMessage(ctx, tx, msg, action, metadata, nil)
+	return err
+}
+
+// insertServiceMessage stores a service event like storeServiceMessage, keeping
+// the file of upload, if any, pending. It returns the id of the service message,
+// or 0 if it was stored already.
+func (h *Handler) insertServiceMessage(ctx context.Context, tx *store.Tx, msg *tele.Message, action string, metadata map[string]interface{}, upload *mediaUpload) (int64, error) {
 	// Upsert chat
 	chat := &store.Chat{
 		ID:        msg.Chat.ID,
@@ -105,51 +584,50 @@ func (h *Handler) HandleUserLeft(c tele.Context) error {
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	if err := h.store.UpsertChat(ctx, chat); err != nil {
+	if err := tx.UpsertChat(ctx, chat); err != nil {
 		slog.Error("failed to upsert chat", "error", err)
-		return err
+		return 0, err
 	}
 
-	// Upsert left user
-	if msg.UserLeft != nil {
-		user := &store.User{
-			ID:        msg.UserLeft.ID,
-			Username:  msg.UserLeft.Username,
-			FirstName: msg.UserLeft.FirstName,
-			LastName:  msg.UserLeft.LastName,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
-		if err := h.store.UpsertUser(ctx, user); err != nil {
-			slog.Error("failed to upsert left user", "error", err)
-			return err
+	// Upsert actor
+	var actorID *int64
+	if msg.Sender != nil {
+		user := userFromTelegram(msg.Sender)
+		if err := tx.UpsertUser(ctx, user); err != nil {
+			slog.Error("failed to upsert actor user", "error", err)
+			return 0, err
 		}
+		id := msg.Sender.ID
+		actorID = &id
 	}
 
-	// Create metadata
-	metadata := map[string]interface{}{}
-	if msg.UserLeft != nil {
-		metadata["left_user_id"] = msg.UserLeft.ID
-		metadata["left_username"] = msg.UserLeft.Username
-		metadata["left_first_name"] = msg.UserLeft.FirstName
-		metadata["left_last_name"] = msg.UserLeft.LastName
+	var metadataJSON json.RawMessage
+	if len(metadata) > 0 {
+		metadataJSON, _ = json.Marshal(metadata)
 	}
-	metadataJSON, _ := json.Marshal(metadata)
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("message_type", "service"),
+		attribute.String("service_action", action))
 
 	// Insert service message
 	serviceMsg := &store.ServiceMessage{
 		TelegramMessageID: int64(msg.ID),
 		ChatID:            msg.Chat.ID,
+		ActorUserID:       actorID,
 		MessageDate:       time.Unix(msg.Unixtime, 0),
-		Action:            "user_left",
+		Action:            action,
 		Metadata:          metadataJSON,
 	}
+	if upload != nil {
+		serviceMsg.MediaFileID = &upload.file.FileID
+	}
 
-	if err := h.store.InsertServiceMessage(ctx, serviceMsg); err != nil {
-		slog.Error("failed to insert service message", "error", err)
-		return err
+	if err := tx.InsertServiceMessage(ctx, serviceMsg); err != nil {
+		slog.Error("failed to insert service message", "error", err, "action", action)
+		return 0, err
 	}
 
-	slog.Info("user left event processed", "chat_id", msg.Chat.ID, "telegram_message_id", msg.ID)
-	return nil
+	slog.Info("service event processed", "action", action, "chat_id", msg.Chat.ID, "telegram_message_id", msg.ID)
+	return serviceMsg.ID, nil
 }
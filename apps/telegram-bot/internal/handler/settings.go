@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"beef-briefing/apps/telegram-bot/internal/store"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+const settingsUsage = `Usage:
+/settings - show this chat's settings
+/settings allow|deny - ingest this chat or not (bot owners only)
+/settings types all|text,photo,... - message types to store
+/settings media on|off - download media files
+/settings max_media <MB>|none - largest media file downloaded
+/settings locations on|off - keep coordinates of locations and venues
+/settings keep_text <days>|forever - delete messages after this many days
+/settings keep_media <days>|forever - delete media files after this many days
+/settings keep_locations <days>|forever - delete coordinates after this many days`
+
+// HandleSettings shows or changes the ingestion policy of the chat. The allowlist
+// can only be changed by bot owners.
+func (h *Handler) HandleSettings(c tele.Context) error {
+	msg := c.Message()
+	current := h.policies.Get(msg.Chat.ID)
+
+	args := strings.Fields(msg.Payload)
+	if len(args) == 0 {
+		return c.Reply(describeSettings(current))
+	}
+
+	// Work on a copy, since the cached settings are shared
+	cs := *current
+	switch {
+	case args[0] == "allow" || args[0] == "deny":
+		if !h.isOwner(msg.Sender) {
+			return c.Reply("Only bot owners can allow or deny a chat.")
+		}
+		cs.Allowed = args[0] == "allow"
+	case len(args) != 2:
+		return c.Reply(settingsUsage)
+	case args[0] == "types":
+		types, ok := parseMessageTypes(args[1])
+		if !ok {
+			return c.Reply("Unknown message type. Known types: " + strings.Join(messageTypes, ", "))
+		}
+		cs.MessageTypes = types
+	case args[0] == "media":
+		on, ok := parseSwitch(args[1])
+		if !ok {
+			return c.Reply(settingsUsage)
+		}
+		cs.StoreMedia = on
+	case args[0] == "max_media":
+		size, ok := parseMediaSize(args[1])
+		if !ok {
+			return c.Reply("The size must be a whole number of megabytes, or none.")
+		}
+		cs.MaxMediaSize = size
+	case args[0] == "locations":
+		on, ok := parseSwitch(args[1])
+		if !ok {
+			return c.Reply(settingsUsage)
+		}
+		cs.StoreLocations = on
+	case args[0] == "keep_text" || args[0] == "keep_media" || args[0] == "keep_locations":
+		days, ok := parseRetention(args[1])
+		if !ok {
+			return c.Reply("The retention must be a whole number of days, or forever.")
+		}
+		switch args[0] {
+		case "keep_text":
+			cs.TextRetention = days
+		case "keep_media":
+			cs.MediaRetention = days
+		default:
+			cs.LocationRetention = days
+		}
+	default:
+		return c.Reply(settingsUsage)
+	}
+
+	if err := h.saveSettings(c, &cs); err != nil {
+		return c.Reply("Failed to save the settings. Please try again later.")
+	}
+
+	slog.Info("chat settings changed", "chat_id", msg.Chat.ID, "setting", args[0], "user_id", cs.UpdatedBy)
+	return c.Reply(describeSettings(&cs))
+}
+
+// saveSettings stores changed settings of the chat in c, made by its sender, and
+// applies them
+func (h *Handler) saveSettings(c tele.Context, cs *store.ChatSettings) error {
+	if sender := c.Message().Sender; sender != nil {
+		cs.UpdatedBy = &sender.ID
+	}
+	if err := h.store.SaveChatSettings(h.updateContext(c), cs); err != nil {
+		slog.Error("failed to save chat settings", "error", err, "chat_id", cs.ChatID)
+		return err
+	}
+	h.policies.Set(cs)
+	return nil
+}
+
+// describeSettings formats chat settings for a reply
+func describeSettings(cs *store.ChatSettings) string {
+	var b strings.Builder
+	if cs.Allowed && cs.Paused {
+		b.WriteString("Ingestion: paused (nothing is stored until /resume)\n")
+	} else if cs.Allowed {
+		b.WriteString("Ingestion: allowed\n")
+	} else {
+		b.WriteString("Ingestion: not allowed (nothing is stored)\n")
+	}
+	if len(cs.MessageTypes) == 0 {
+		b.WriteString("Message types: all\n")
+	} else {
+		fmt.Fprintf(&b, "Message types: %s\n", strings.Join(cs.MessageTypes, ", "))
+	}
+	fmt.Fprintf(&b, "Media: %s\n", onOff(cs.StoreMedia))
+	if cs.MaxMediaSize == nil {
+		b.WriteString("Max media size: none\n")
+	} else {
+		fmt.Fprintf(&b, "Max media size: %d MB\n", *cs.MaxMediaSize>>20)
+	}
+	fmt.Fprintf(&b, "Locations: %s\n", onOff(cs.StoreLocations))
+	fmt.Fprintf(&b, "Messages kept: %s\n", retentionDays(cs.TextRetention))
+	fmt.Fprintf(&b, "Media kept: %s\n", retentionDays(cs.MediaRetention))
+	fmt.Fprintf(&b, "Locations kept: %s", retentionDays(cs.LocationRetention))
+	return b.String()
+}
+
+func retentionDays(days *int) string {
+	if days == nil {
+		return "forever"
+	}
+	return fmt.Sprintf("%d days", *days)
+}
+
+// parseMessageTypes parses "all" (returned as nil) or a comma-separated list of message types
+func parseMessageTypes(s string) ([]string, bool) {
+	if s == "all" {
+		return nil, true
+	}
+
+	var types []string
+	for _, t := range strings.Split(s, ",") {
+		if !slices.Contains(messageTypes, t) {
+			return nil, false
+		}
+		if !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+	return types, true
+}
+
+// parseMediaSize parses a size in megabytes, or "none" (returned as nil) for no limit
+func parseMediaSize(s string) (*int64, bool) {
+	if s == "none" {
+		return nil, true
+	}
+	mb, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || mb <= 0 {
+		return nil, false
+	}
+	size := mb << 20
+	return &size, true
+}
+
+// parseRetention parses a number of days, or "forever" (returned as nil)
+func parseRetention(s string) (*int, bool) {
+	if s == "forever" {
+		return nil, true
+	}
+	days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+	if err != nil || days <= 0 {
+		return nil, false
+	}
+	return &days, true
+}
+
+// parseSwitch parses "on" or "off"
+func parseSwitch(s string) (on, ok bool) {
+	switch s {
+	case "on":
+		return true, true
+	case "off":
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
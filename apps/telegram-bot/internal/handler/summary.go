@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/api"
+	"beef-briefing/apps/telegram-bot/internal/format"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// maxSummaryWindow caps how far back /summary may reach
+	maxSummaryWindow = 7 * 24 * time.Hour
+
+	// summaryTimeout bounds how long we wait for the analyzer
+	summaryTimeout = 5 * time.Minute
+
+	// typingInterval refreshes the "typing" status, which Telegram clears after ~5s
+	typingInterval = 4 * time.Second
+)
+
+const summaryUsage = "Usage: /summary 6h (or 30m, 2d), or reply to a message with /summary to catch up since then."
+
+// HandleSummary replies with a catch-up of the chat since a duration ago or since the replied-to message
+func (h *Handler) HandleSummary(c tele.Context) error {
+	msg := c.Message()
+
+	// In a forum topic, the summary only covers that topic
+	req := &api.SummaryRequest{
+		ChatID:          msg.Chat.ID,
+		MessageThreadID: topicID(msg),
+		Until:           time.Now(),
+	}
+
+	// In a forum topic, Telegram makes every message a reply to the message that
+	// created the topic, so only replies to other messages anchor the summary
+	if msg.ReplyTo != nil && msg.ReplyTo.TopicCreated == nil {
+		req.Since = time.Unix(msg.ReplyTo.Unixtime, 0)
+		fromID := int64(msg.ReplyTo.ID)
+		req.FromMessageID = &fromID
+	} else {
+		window, err := parseWindow(msg.Payload)
+		if err != nil {
+			return c.Reply(summaryUsage)
+		}
+		req.Since = req.Until.Add(-window)
+	}
+
+	if req.Until.Sub(req.Since) > maxSummaryWindow {
+		req.Since = req.Until.Add(-maxSummaryWindow)
+	}
+
+	if ok, wait := h.summaryLimiter.Allow(msg.Chat.ID); !ok {
+		return c.Reply(fmt.Sprintf("A summary was requested recently. Please try again in %s.", wait.Round(time.Second)))
+	}
+
+	// Waiting for the analyzer happens on the command workers
+	ok := h.runCommand(c, "summary", func(ctx context.Context) error {
+		return h.summarize(ctx, c, req)
+	})
+	if !ok {
+		h.summaryLimiter.Reset(msg.Chat.ID)
+		return c.Reply(busyReply)
+	}
+	return nil
+}
+
+// summarize asks the analyzer for the summary requested in c and replies with it
+func (h *Handler) summarize(ctx context.Context, c tele.Context, req *api.SummaryRequest) error {
+	msg := c.Message()
+	ctx, cancel := context.WithTimeout(ctx, summaryTimeout)
+	defer cancel()
+
+	chatIDs, err := h.store.ResolveChatIDs(ctx, msg.Chat.ID)
+	if err != nil {
+		slog.Error("failed to resolve chat ids", "error", err, "chat_id", msg.Chat.ID)
+		h.summaryLimiter.Reset(msg.Chat.ID)
+		return c.Reply("Sorry, I couldn't put the summary together. Please try again later.")
+	}
+	req.ChatIDs = chatIDs
+
+	stopTyping := keepTyping(ctx, c)
+	summary, err := h.api.Summarize(ctx, req)
+	stopTyping()
+
+	if err != nil {
+		slog.Error("failed to get summary", "error", err, "chat_id", msg.Chat.ID)
+		h.summaryLimiter.Reset(msg.Chat.ID)
+		return c.Reply("Sorry, I couldn't put the summary together. Please try again later.")
+	}
+
+	if strings.TrimSpace(summary) == "" {
+		return c.Reply("Nothing to summarise in that period.")
+	}
+
+	slog.Info("summary ready",
+		"chat_id", msg.Chat.ID,
+		"since", req.Since,
+		"until", req.Until)
+
+	return replyHTML(c, format.TextBlocks(summary, format.MaxMessageLength))
+}
+
+// parseWindow parses a look-back window such as "30m", "6h", "1h30m" or "2d"
+func parseWindow(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty window")
+	}
+
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q: %w", s, err)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		d, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q: %w", s, err)
+		}
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("window must be positive: %q", s)
+	}
+	return d, nil
+}
+
+// keepTyping shows the "typing" status in the chat, or the forum topic of the
+// command, until the returned func is called
+func keepTyping(ctx context.Context, c tele.Context) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	var threadIDs []int
+	if threadID := topicID(c.Message()); threadID != nil {
+		threadIDs = append(threadIDs, int(*threadID))
+	}
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(typingInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := c.Bot().Notify(c.Chat(), tele.Typing, threadIDs...); err != nil {
+				slog.Debug("failed to send typing status", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// replyHTML replies with HTML blocks, packed into as few messages as the length limit allows
+func replyHTML(c tele.Context, blocks []string) error {
+	opts := &tele.SendOptions{
+		ParseMode:             tele.ModeHTML,
+		DisableWebPagePreview: true,
+	}
+
+	for i, part := range format.Pack(blocks, format.MaxMessageLength) {
+		var err error
+		if i == 0 {
+			err = c.Reply(part, opts)
+		} else {
+			err = c.Send(part, opts)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
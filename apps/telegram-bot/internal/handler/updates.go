@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/dispatch"
+	"beef-briefing/apps/telegram-bot/internal/store"
+	"beef-briefing/apps/telegram-bot/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	tele "gopkg.in/telebot.v4"
+)
+
+// updateContextKey holds the context of an update, carrying its span, in the
+// telebot context its handlers receive
+const updateContextKey = "update_context"
+
+// updateErrorKey holds the error the handler of an update returned, set by TraceErrors
+const updateErrorKey = "update_error"
+
+// ProcessUpdate handles one update. It runs on the update workers, which see the
+// updates of a chat in order, so a bot removal is always processed before the
+// updates that follow it.
+//
+// Updates from chats the bot was removed from, or that are not on the allowlist,
+// are dropped, except for commands. Messages carrying a poll
+// or a forwarded story, and the migrate_from_chat_id message of a new supergroup,
+// are not routed to any handler by telebot, so they are handed to our handlers here.
+// So are joins of other users, which telebot routes once per user when several
+// join at once. Everything else goes through the bot's routing.
+//
+// Each update is traced as a span, which the store and MinIO calls of its
+// handler are children of. The handler's error is returned, so the update is
+// retried.
+func (h *Handler) ProcessUpdate(u tele.Update) error {
+	chatID, hasChat := dispatch.ChatID(&u)
+	if hasChat && u.MyChatMember == nil && h.inactiveChats.Has(chatID) {
+		slog.Debug("update from inactive chat dropped", "chat_id", chatID, "update_id", u.ID)
+		return nil
+	}
+	if hasChat && !h.allowedUpdate(&u, chatID) {
+		slog.Debug("update from chat not on the allowlist dropped", "chat_id", chatID, "update_id", u.ID)
+		return nil
+	}
+
+	ctx, span := tracing.Start(h.ctx, "telegram.update",
+		attribute.Int("update_id", u.ID),
+		attribute.String("update_type", dispatch.UpdateType(&u)))
+	defer span.End()
+	if hasChat {
+		span.SetAttributes(attribute.Int64("chat_id", chatID))
+	}
+
+	c := h.bot.NewContext(u)
+	c.Set(updateContextKey, ctx)
+
+	var handle tele.HandlerFunc
+	if msg := u.Message; msg != nil {
+		switch {
+		case msg.Poll != nil || msg.Story != nil:
+			handle = h.HandleMessage
+		case msg.MigrateFrom != 0 && msg.MigrateTo == 0:
+			handle = h.HandleMigratedFrom
+		case len(msg.UsersJoined) > 0 && !msg.GroupCreated && !msg.SuperGroupCreated:
+			handle = h.HandleUserJoined
+		}
+	}
+	if handle == nil {
+		h.bot.ProcessContext(c)
+	} else if err := h.TraceErrors(handle)(c); err != nil {
+		h.bot.OnError(err, c)
+	}
+
+	err, _ := c.Get(updateErrorKey).(error)
+	return err
+}
+
+// TraceErrors is middleware marking the span of the update as failed when its
+// handler returns an error, and keeping the error for ProcessUpdate to return
+func (h *Handler) TraceErrors(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		err := next(c)
+		if err != nil {
+			c.Set(updateErrorKey, err)
+		}
+		tracing.RecordError(trace.SpanFromContext(h.updateContext(c)), err)
+		return err
+	}
+}
+
+// ParkUpdate records an update that kept failing in failed_updates, along with
+// the offset reached, so it no longer holds up the updates after it. Parked
+// updates are not retried by the bot.
+func (h *Handler) ParkUpdate(u tele.Update, cause error, attempts int) error {
+	payload, err := json.Marshal(u)
+	if err != nil {
+		return fmt.Errorf("failed to encode update: %w", err)
+	}
+
+	failed := &store.FailedUpdate{
+		BotID:      h.bot.Me.ID,
+		UpdateID:   int64(u.ID),
+		UpdateType: dispatch.UpdateType(&u),
+		Payload:    payload,
+		Error:      cause.Error(),
+		Attempts:   attempts,
+	}
+	if chatID, ok := dispatch.ChatID(&u); ok {
+		failed.ChatID = &chatID
+	}
+
+	return h.store.WithTx(h.ctx, func(tx *store.Tx) error {
+		if err := tx.SaveFailedUpdate(h.ctx, failed); err != nil {
+			return err
+		}
+		return tx.AdvanceUpdateOffset(h.ctx, h.offsetAfter(int64(u.ID)))
+	})
+}
+
+// updateContext returns the context of the update in c, which carries its span
+func (h *Handler) updateContext(c tele.Context) context.Context {
+	if ctx, ok := c.Get(updateContextKey).(context.Context); ok {
+		return ctx
+	}
+	return h.ctx
+}
+
+// saveUpdate runs fn in one transaction holding every write of the update in c,
+// and stores the update offset reached in that same transaction
+func (h *Handler) saveUpdate(c tele.Context, fn func(tx *store.Tx) error) error {
+	ctx := h.updateContext(c)
+	return h.store.WithTx(ctx, func(tx *store.Tx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if err := tx.AdvanceUpdateOffset(ctx, h.updateOffset(c)); err != nil {
+			slog.Error("failed to store update offset", "error", err, "update_id", c.Update().ID)
+			return err
+		}
+		return nil
+	})
+}
+
+// SaveOffsets stores the offset reached every interval until ctx is cancelled.
+// Updates that write nothing (commands, dropped updates) don't store it
+// themselves, so without this a restart would process them again.
+func (h *Handler) SaveOffsets(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var saved int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			processed := h.offsets.Processed()
+			if processed == saved {
+				continue
+			}
+			if err := h.saveOffset(ctx, processed); err != nil {
+				slog.Error("failed to save update offset", "error", err)
+				continue
+			}
+			saved = processed
+		}
+	}
+}
+
+// SaveOffset stores the update up to which every update has been processed
+func (h *Handler) SaveOffset(ctx context.Context) error {
+	return h.saveOffset(ctx, h.offsets.Processed())
+}
+
+func (h *Handler) saveOffset(ctx context.Context, processed int64) error {
+	if processed == 0 {
+		return nil
+	}
+	return h.store.AdvanceUpdateOffset(ctx, &store.UpdateOffset{BotID: h.bot.Me.ID, UpdateID: processed})
+}
+
+// updateOffset returns the offset reached once the update in c is processed, or
+// nil if an older update is still being processed
+func (h *Handler) updateOffset(c tele.Context) *store.UpdateOffset {
+	return h.offsetAfter(int64(c.Update().ID))
+}
+
+// offsetAfter returns the offset reached once the given update is processed, or
+// nil if an older update is still being processed
+func (h *Handler) offsetAfter(id int64) *store.UpdateOffset {
+	updateID := h.offsets.Committable(id)
+	if updateID == 0 {
+		return nil
+	}
+	return &store.UpdateOffset{BotID: h.bot.Me.ID, UpdateID: updateID}
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/batch"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// uploadBatchSize is how many pending media files are retried per round
+const uploadBatchSize = 20
+
+// RecoverUploads archives the media of stored messages whose upload never
+// finished, because the bot stopped before it ran or it failed, until ctx is
+// cancelled. Messages are only picked up once interval has passed since they
+// were stored and since their last failed attempt, so uploads still queued are
+// left alone; after maxAttempts failures a message keeps no media.
+func (h *Handler) RecoverUploads(ctx context.Context, interval time.Duration, maxAttempts int) {
+	batch.Run(ctx, interval, uploadBatchSize, func(ctx context.Context) (picked, done int) {
+		pending, err := h.store.ListPendingMedia(ctx, time.Now().Add(-interval), maxAttempts, uploadBatchSize)
+		if err != nil {
+			slog.Error("failed to list pending media", "error", err)
+			return 0, 0
+		}
+
+		for _, m := range pending {
+			if ctx.Err() != nil {
+				break
+			}
+			upload := &mediaUpload{file: tele.File{FileID: m.FileID}, contentType: m.MimeType, serviceMessage: m.ServiceMessage}
+			if !h.archiveMedia(ctx, m.MessageID, upload) {
+				slog.Warn("pending media upload failed", "message_id", m.MessageID, "attempts", m.Attempts+1)
+				continue
+			}
+			slog.Info("pending media archived", "message_id", m.MessageID, "attempts", m.Attempts)
+			done++
+		}
+		return len(pending), done
+	})
+}
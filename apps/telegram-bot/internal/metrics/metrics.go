@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "telegram_bot"
+
+var (
+	// Updates counts the updates received, by update type. Chats are left to traces,
+	// since a label per chat would grow with every chat the bot is added to
+	Updates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_total",
+		Help:      "Updates received, by update type.",
+	}, []string{"type"})
+
+	// HandlerErrors counts the updates whose handler failed, by update type
+	HandlerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "handler_errors_total",
+		Help:      "Updates whose handler returned an error, by update type.",
+	}, []string{"type"})
+
+	// UpdatesParked counts the updates that kept failing and were stored in
+	// failed_updates instead, by update type
+	UpdatesParked = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_parked_total",
+		Help:      "Updates parked in failed_updates after failing repeatedly, by update type.",
+	}, []string{"type"})
+
+	// MediaUploadedBytes counts the bytes of media files uploaded to MinIO
+	MediaUploadedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "media_uploaded_bytes_total",
+		Help:      "Bytes of media files uploaded to MinIO.",
+	})
+
+	// MediaDedupHits counts the media files that were already stored in MinIO
+	MediaDedupHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "media_dedup_hits_total",
+		Help:      "Media files not uploaded because MinIO already had them.",
+	})
+
+	// RetentionPurged counts what the retention worker removed, by kind (messages,
+	// service_messages, media, locations, or blobs for MinIO objects)
+	RetentionPurged = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retention_purged_total",
+		Help:      "Rows purged by the retention worker, by kind, and MinIO objects deleted (kind blobs).",
+	}, []string{"kind"})
+
+	// DBDuration observes the latency of database statements, by operation
+	DBDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_duration_seconds",
+		Help:      "Latency of database statements, by operation (exec, query, query_row, commit).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	// MinIODuration observes the latency of MinIO requests, by operation
+	MinIODuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "minio_duration_seconds",
+		Help:      "Latency of MinIO requests, by operation (stat, put, get, remove, list, bucket_exists).",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"operation"})
+)
+
+// ObserveSince records the time elapsed since start in the histogram of operation
+func ObserveSince(h *prometheus.HistogramVec, operation string, start time.Time) {
+	h.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// RegisterQueue exposes the number of tasks waiting in a worker pool's queue
+func RegisterQueue(pool string, depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "queue_depth",
+		Help:        "Tasks waiting in a worker pool's queue.",
+		ConstLabels: prometheus.Labels{"pool": pool},
+	}, func() float64 { return float64(depth()) })
+}
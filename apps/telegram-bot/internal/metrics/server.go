@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// checkTimeout bounds each readiness check
+	checkTimeout = 5 * time.Second
+
+	// shutdownTimeout bounds how long in-flight scrapes may take on shutdown
+	shutdownTimeout = 5 * time.Second
+)
+
+// Check reports whether a dependency is usable
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Server serves /metrics, /healthz and /readyz
+type Server struct {
+	server   *http.Server
+	checks   []Check
+	stopping atomic.Bool
+}
+
+// NewServer creates a server listening on listen. /readyz runs checks.
+func NewServer(listen string, checks ...Check) *Server {
+	s := &Server{checks: checks}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	s.server = &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Run serves until ctx is cancelled
+func (s *Server) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		s.stopping.Store(true)
+	}()
+
+	slog.Info("metrics server listening", "listen", s.server.Addr)
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("metrics server failed", "error", err)
+	}
+}
+
+// Shutdown stops the server, letting in-flight requests finish
+func (s *Server) Shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		slog.Warn("failed to shut down metrics server", "error", err)
+	}
+}
+
+// healthz reports that the process is up. It checks no dependencies, so an
+// outage of Postgres or MinIO does not get the bot restarted.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// readyz reports whether Postgres and MinIO are reachable, and fails once the
+// bot is shutting down
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if s.stopping.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	ready := true
+	var body []byte
+	for _, check := range s.checks {
+		if err := check.Check(ctx); err != nil {
+			slog.Warn("readiness check failed", "check", check.Name, "error", err)
+			body = append(body, check.Name+": "+err.Error()+"\n"...)
+			ready = false
+			continue
+		}
+		body = append(body, check.Name+": ok\n"...)
+	}
+
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(body)
+}
@@ -0,0 +1,243 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/format"
+	"beef-briefing/apps/telegram-bot/internal/store"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	// checkInterval is how often pending briefings are looked up
+	checkInterval = time.Minute
+
+	// maxReferenceLinks caps the links to source messages shown per item, so
+	// every item keeps most of a message for its text
+	maxReferenceLinks = 20
+
+	// maxPublishAttempts is how many failed attempts a briefing gets before it is
+	// given up on; at one attempt per check that is about an hour
+	maxPublishAttempts = 60
+)
+
+// Publisher posts briefings generated by the llm-analyzer to their chats
+// once a day at a configured local time
+type Publisher struct {
+	store    *store.PostgresStore
+	bot      *tele.Bot
+	location *time.Location
+	hour     int
+	minute   int
+}
+
+// NewPublisher creates a publisher posting at postTime ("HH:MM") in the given IANA timezone
+func NewPublisher(store *store.PostgresStore, bot *tele.Bot, postTime, timezone string) (*Publisher, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid briefing timezone %q: %w", timezone, err)
+	}
+
+	t, err := time.Parse("15:04", postTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid briefing post time %q: %w", postTime, err)
+	}
+
+	return &Publisher{
+		store:    store,
+		bot:      bot,
+		location: location,
+		hour:     t.Hour(),
+		minute:   t.Minute(),
+	}, nil
+}
+
+// Run publishes pending briefings until ctx is cancelled
+func (p *Publisher) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		p.publishDue(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishDue posts every briefing created before today's slot once the slot has passed.
+// Briefings created after the slot wait for the next day.
+func (p *Publisher) publishDue(ctx context.Context, now time.Time) {
+	local := now.In(p.location)
+	slot := time.Date(local.Year(), local.Month(), local.Day(), p.hour, p.minute, 0, 0, p.location)
+	if local.Before(slot) {
+		return
+	}
+
+	briefings, err := p.store.ListUnpublishedBriefings(ctx, slot)
+	if err != nil {
+		slog.Error("failed to list unpublished briefings", "error", err)
+		return
+	}
+
+	for _, b := range briefings {
+		if err := p.publish(ctx, b); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			p.recordFailure(ctx, b, err)
+			continue
+		}
+		slog.Info("briefing published", "briefing_id", b.ID, "chat_id", b.ChatID)
+	}
+}
+
+// recordFailure counts a failed attempt to post a briefing. Errors Telegram
+// will keep returning give up on the briefing at once; anything else is retried
+// until maxPublishAttempts.
+func (p *Publisher) recordFailure(ctx context.Context, b *store.Briefing, cause error) {
+	maxAttempts := maxPublishAttempts
+	if permanent(cause) {
+		maxAttempts = 1
+	}
+
+	failed, err := p.store.RecordBriefingFailure(ctx, b.ID, cause.Error(), maxAttempts)
+	if err != nil {
+		slog.Error("failed to record briefing failure", "error", err, "briefing_id", b.ID)
+	}
+	if failed {
+		slog.Error("giving up on briefing",
+			"error", cause,
+			"briefing_id", b.ID,
+			"chat_id", b.ChatID,
+			"attempts", b.PublishAttempts+1)
+		return
+	}
+	slog.Warn("failed to publish briefing, will retry",
+		"error", cause,
+		"briefing_id", b.ID,
+		"chat_id", b.ChatID,
+		"attempts", b.PublishAttempts+1)
+}
+
+// permanent reports whether a send error will not go away by retrying: the bot
+// was removed from the chat or can't post in it, or the chat is gone
+func permanent(err error) bool {
+	var apiErr *tele.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Code == http.StatusForbidden ||
+		apiErr == tele.ErrChatNotFound ||
+		apiErr == tele.ErrNoRightsToSend
+}
+
+// publish sends the parts of a briefing that were not posted yet. The parts are
+// stored before the first one goes out, and every sent part is recorded before
+// the next one, so a restart resumes where it stopped instead of posting the
+// briefing twice, even if rendering changed in between.
+func (p *Publisher) publish(ctx context.Context, b *store.Briefing) error {
+	parts := b.RenderedParts
+	if len(parts) == 0 {
+		// Rendered when publishing starts; briefings partly posted before parts
+		// were stored are rendered again
+		var err error
+		if parts, err = p.store.SaveBriefingParts(ctx, b.ID, p.render(b)); err != nil {
+			return err
+		}
+	}
+	chat := &tele.Chat{ID: b.ChatID}
+
+	// Per-topic briefings are posted into their topic
+	var threadID int
+	if b.MessageThreadID != nil {
+		threadID = int(*b.MessageThreadID)
+	}
+
+	for i := len(b.SentMessageIDs); i < len(parts); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		sent, err := p.bot.Send(chat, parts[i], &tele.SendOptions{
+			ParseMode:             tele.ModeHTML,
+			DisableWebPagePreview: true,
+			ThreadID:              threadID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to send part %d/%d: %w", i+1, len(parts), err)
+		}
+
+		if err := p.store.AppendBriefingMessageID(ctx, b.ID, int64(sent.ID)); err != nil {
+			return err
+		}
+	}
+
+	return p.store.MarkBriefingPublished(ctx, b.ID, time.Now())
+}
+
+// render formats a briefing as Telegram HTML messages within the length limit
+func (p *Publisher) render(b *store.Briefing) []string {
+	title := "Daily briefing"
+	if b.Title != nil && *b.Title != "" {
+		title = *b.Title
+	}
+
+	period := fmt.Sprintf("%s – %s",
+		b.PeriodStart.In(p.location).Format("Jan 2 15:04"),
+		b.PeriodEnd.In(p.location).Format("Jan 2 15:04"))
+
+	blocks := []string{
+		"<b>" + format.EscapeHTML(title) + "</b>\n<i>" + format.EscapeHTML(period) + "</i>",
+	}
+
+	blocks = append(blocks, format.TextBlocks(b.Summary, format.MaxMessageLength)...)
+
+	for _, item := range b.Items {
+		// Message ids belong to the chat the briefing was written for, not to
+		// the chat it is posted to after a migration
+		links := referenceLinks(b.SourceChatID, item.MessageIDs)
+
+		// Leave room for the bullet and the reference links
+		pieces := format.SplitText(item.Text, format.MaxMessageLength-format.Length(links)-4)
+		for i, piece := range pieces {
+			block := format.EscapeHTML(piece)
+			if i == 0 {
+				block = "• " + block
+			}
+			if i == len(pieces)-1 && links != "" {
+				block += " " + links
+			}
+			blocks = append(blocks, block)
+		}
+	}
+
+	return format.Pack(blocks, format.MaxMessageLength)
+}
+
+// referenceLinks renders numbered deep links to the referenced messages, up to
+// maxReferenceLinks of them
+func referenceLinks(chatID int64, messageIDs []int64) string {
+	var links []string
+	for i, id := range messageIDs {
+		if len(links) == maxReferenceLinks {
+			break
+		}
+		url := format.MessageLink(chatID, id)
+		if url == "" {
+			continue
+		}
+		links = append(links, format.Link(url, fmt.Sprintf("[%d]", i+1)))
+	}
+	return strings.Join(links, " ")
+}
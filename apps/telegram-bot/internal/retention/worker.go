@@ -0,0 +1,171 @@
+package retention
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/blobs"
+	"beef-briefing/apps/telegram-bot/internal/metrics"
+	"beef-briefing/apps/telegram-bot/internal/storage"
+	"beef-briefing/apps/telegram-bot/internal/store"
+)
+
+// Worker purges what chats keep for longer than their retention settings allow.
+// Rows are purged in batches, each in its own transaction, so a large backlog
+// doesn't hold locks for long; media files nothing references any more are
+// deleted from MinIO. In dry-run mode it only reports what would be removed.
+type Worker struct {
+	store       *store.PostgresStore
+	minioClient *storage.MinIOClient
+	interval    time.Duration
+	batchSize   int
+	dryRun      bool
+}
+
+func NewWorker(store *store.PostgresStore, minioClient *storage.MinIOClient, interval time.Duration, batchSize int, dryRun bool) *Worker {
+	return &Worker{
+		store:       store,
+		minioClient: minioClient,
+		interval:    interval,
+		batchSize:   batchSize,
+		dryRun:      dryRun,
+	}
+}
+
+// Run purges on start and then every interval until ctx is cancelled
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purge applies the retention of every chat once
+func (w *Worker) purge(ctx context.Context) {
+	policies, err := w.store.ListRetentionPolicies(ctx)
+	if err != nil {
+		slog.Error("failed to list retention policies", "error", err)
+		return
+	}
+
+	now := time.Now()
+	purgedTables := map[string]bool{}
+	for _, policy := range policies {
+		for _, step := range steps(policy) {
+			if ctx.Err() != nil {
+				return
+			}
+			before := now.AddDate(0, 0, -step.days)
+			if w.dryRun {
+				w.estimate(ctx, policy, step.kind, before)
+				continue
+			}
+			if w.purgeKind(ctx, policy, step.kind, before) > 0 {
+				purgedTables[store.PurgeTable(step.kind)] = true
+			}
+		}
+	}
+
+	if len(purgedTables) == 0 {
+		return
+	}
+	var tables []string
+	for table := range purgedTables {
+		tables = append(tables, table)
+	}
+	if err := w.store.VacuumAfterPurge(ctx, tables); err != nil {
+		slog.Error("failed to vacuum after purge", "error", err, "tables", tables)
+	}
+}
+
+// step is one kind of data a chat purges, and how many days it is kept
+type step struct {
+	kind store.PurgeKind
+	days int
+}
+
+// steps lists what a policy purges. Messages go first, so media and coordinates
+// of messages about to be deleted are not cleared separately.
+func steps(p *store.RetentionPolicy) []step {
+	var list []step
+	if p.TextRetention != nil {
+		list = append(list,
+			step{store.PurgeMessages, *p.TextRetention},
+			step{store.PurgeServiceMessages, *p.TextRetention})
+	}
+	if p.MediaRetention != nil {
+		list = append(list,
+			step{store.PurgeMedia, *p.MediaRetention},
+			step{store.PurgeServiceMedia, *p.MediaRetention})
+	}
+	if p.LocationRetention != nil {
+		list = append(list, step{store.PurgeLocations, *p.LocationRetention})
+	}
+	return list
+}
+
+// purgeKind purges kind from a chat in batches until nothing older than before
+// is left, and returns how many rows were purged
+func (w *Worker) purgeKind(ctx context.Context, policy *store.RetentionPolicy, kind store.PurgeKind, before time.Time) int64 {
+	var rows int64
+	deleted := 0
+	for ctx.Err() == nil {
+		res, err := w.store.PurgeBatch(ctx, kind, policy.ChatIDs, before, w.batchSize)
+		if err != nil {
+			slog.Error("failed to purge", "error", err, "chat_id", policy.ChatID, "kind", kind)
+			break
+		}
+		rows += res.Rows
+		metrics.RetentionPurged.WithLabelValues(string(kind)).Add(float64(res.Rows))
+		deleted += w.deleteBlobs(ctx, res.UnreferencedBlobs)
+
+		if res.Rows < int64(w.batchSize) {
+			break
+		}
+	}
+
+	if rows > 0 {
+		slog.Info("retention purge done",
+			"chat_id", policy.ChatID,
+			"kind", kind,
+			"before", before.Format(time.DateOnly),
+			"rows", rows,
+			"blobs", deleted)
+	}
+	return rows
+}
+
+// deleteBlobs removes media files from MinIO and returns how many were deleted.
+// Files that could not be deleted are left to the garbage collector.
+func (w *Worker) deleteBlobs(ctx context.Context, hashes []string) int {
+	deleted, _ := blobs.Delete(ctx, w.store, w.minioClient, hashes)
+	metrics.RetentionPurged.WithLabelValues("blobs").Add(float64(deleted))
+	return deleted
+}
+
+// estimate reports what purging kind from a chat would remove
+func (w *Worker) estimate(ctx context.Context, policy *store.RetentionPolicy, kind store.PurgeKind, before time.Time) {
+	est, err := w.store.EstimatePurge(ctx, kind, policy.ChatIDs, before)
+	if err != nil {
+		slog.Error("failed to estimate purge", "error", err, "chat_id", policy.ChatID, "kind", kind)
+		return
+	}
+	if est.Rows == 0 {
+		return
+	}
+	slog.Info("retention dry run: would purge",
+		"chat_id", policy.ChatID,
+		"kind", kind,
+		"before", before.Format(time.DateOnly),
+		"rows", est.Rows,
+		"blobs", est.Blobs)
+}
@@ -6,9 +6,14 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/metrics"
+	"beef-briefing/apps/telegram-bot/internal/tracing"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type MinIOClient struct {
@@ -16,7 +21,7 @@ type MinIOClient struct {
 	bucketName string
 }
 
-func NewMinIOClient(endpoint, accessKey, secretKey, bucketName string, useSSL bool) (*MinIOClient, error) {
+func NewMinIOClient(ctx context.Context, endpoint, accessKey, secretKey, bucketName string, useSSL bool) (*MinIOClient, error) {
 	client, err := minio.New(endpoint, &minio.Options{
 		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
 		Secure: useSSL,
@@ -31,7 +36,7 @@ func NewMinIOClient(endpoint, accessKey, secretKey, bucketName string, useSSL bo
 	}
 
 	// Ensure bucket exists
-	if err := mc.ensureBucket(context.Background()); err != nil {
+	if err := mc.ensureBucket(ctx); err != nil {
 		return nil, fmt.Errorf("failed to ensure bucket exists: %w", err)
 	}
 
@@ -39,7 +44,7 @@ func NewMinIOClient(endpoint, accessKey, secretKey, bucketName string, useSSL bo
 }
 
 func (m *MinIOClient) ensureBucket(ctx context.Context) error {
-	exists, err := m.client.BucketExists(ctx, m.bucketName)
+	exists, err := m.bucketExists(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to check bucket existence: %w", err)
 	}
@@ -54,6 +59,37 @@ func (m *MinIOClient) ensureBucket(ctx context.Context) error {
 	return nil
 }
 
+// Ping checks that MinIO is reachable and the bucket exists
+func (m *MinIOClient) Ping(ctx context.Context) error {
+	exists, err := m.bucketExists(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check bucket existence: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("bucket %s does not exist", m.bucketName)
+	}
+	return nil
+}
+
+func (m *MinIOClient) bucketExists(ctx context.Context) (bool, error) {
+	ctx, done := m.observe(ctx, "bucket_exists")
+	exists, err := m.client.BucketExists(ctx, m.bucketName)
+	done(err)
+	return exists, err
+}
+
+// observe times a MinIO request and traces it as a child span of ctx. The
+// returned function is called with the request's error once it completes.
+func (m *MinIOClient) observe(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, func(error)) {
+	start := time.Now()
+	attrs = append(attrs, attribute.String("minio.bucket", m.bucketName))
+	ctx, span := tracing.StartChild(ctx, "minio."+operation, attrs...)
+	return ctx, func(err error) {
+		metrics.ObserveSince(metrics.MinIODuration, operation, start)
+		tracing.End(span, err)
+	}
+}
+
 // ComputeSHA256 computes the SHA256 hash of a reader
 func ComputeSHA256(reader io.Reader) (string, []byte, error) {
 	hasher := sha256.New()
@@ -67,61 +103,92 @@ func ComputeSHA256(reader io.Reader) (string, []byte, error) {
 	return hash, data, nil
 }
 
-// UploadFile uploads a file to MinIO using SHA256 hash as the key
-// Returns the SHA256 hash (object key)
-func (m *MinIOClient) UploadFile(ctx context.Context, reader io.Reader, contentType string) (string, error) {
-	// Compute SHA256 hash
-	hash, data, err := ComputeSHA256(reader)
-	if err != nil {
-		return "", fmt.Errorf("failed to compute hash: %w", err)
-	}
-
+// UploadFileWithHash uploads a file using a pre-computed hash and data. Callers
+// hold the blob's row lock (store.Tx.LockBlob) until its reference is stored, so
+// a file found here is not deleted before it is referenced.
+func (m *MinIOClient) UploadFileWithHash(ctx context.Context, hash string, data []byte, contentType string) error {
 	// Check if file already exists (deduplication)
-	_, err = m.client.StatObject(ctx, m.bucketName, hash, minio.StatObjectOptions{})
-	if err == nil {
-		// File already exists, return hash
-		return hash, nil
+	if err := m.statObject(ctx, hash); err == nil {
+		// File already exists, skip upload
+		metrics.MediaDedupHits.Inc()
+		return nil
 	}
 
-	// Upload file with actual data
-	_, err = m.client.PutObject(ctx, m.bucketName, hash,
+	// Upload file
+	putCtx, done := m.observe(ctx, "put", attribute.String("minio.object", hash), attribute.Int("minio.size", len(data)))
+	_, err := m.client.PutObject(putCtx, m.bucketName, hash,
 		bytes.NewReader(data),
 		int64(len(data)),
 		minio.PutObjectOptions{
 			ContentType: contentType,
 		})
+	done(err)
 
 	if err != nil {
-		return "", fmt.Errorf("failed to upload file: %w", err)
+		return fmt.Errorf("failed to upload file: %w", err)
 	}
 
-	return hash, nil
+	metrics.MediaUploadedBytes.Add(float64(len(data)))
+	return nil
 }
 
-// UploadFileWithHash uploads a file using a pre-computed hash and data
-func (m *MinIOClient) UploadFileWithHash(ctx context.Context, hash string, data []byte, contentType string) error {
-	// Check if file already exists (deduplication)
-	_, err := m.client.StatObject(ctx, m.bucketName, hash, minio.StatObjectOptions{})
-	if err == nil {
-		// File already exists, skip upload
-		return nil
-	}
+// DownloadFile returns the contents of the file stored under the given hash
+func (m *MinIOClient) DownloadFile(ctx context.Context, hash string) (_ []byte, err error) {
+	ctx, done := m.observe(ctx, "get", attribute.String("minio.object", hash))
+	defer func() { done(err) }()
 
-	// Upload file
-	_, err = m.client.PutObject(ctx, m.bucketName, hash,
-		bytes.NewReader(data),
-		int64(len(data)),
-		minio.PutObjectOptions{
-			ContentType: contentType,
-		})
+	obj, err := m.client.GetObject(ctx, m.bucketName, hash, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+	defer obj.Close()
 
+	data, err := io.ReadAll(obj)
 	if err != nil {
-		return fmt.Errorf("failed to upload file: %w", err)
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
+	return data, nil
+}
 
+// DeleteFile removes the file stored under the given hash. Deleting a file that
+// does not exist is not an error.
+func (m *MinIOClient) DeleteFile(ctx context.Context, hash string) error {
+	ctx, done := m.observe(ctx, "remove", attribute.String("minio.object", hash))
+	err := m.client.RemoveObject(ctx, m.bucketName, hash, minio.RemoveObjectOptions{})
+	done(err)
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
 	return nil
 }
 
+// FileInfo describes a file stored in the bucket
+type FileInfo struct {
+	Hash         string
+	Size         int64
+	LastModified time.Time
+}
+
+// ListFiles calls fn with every file in the bucket, in key order
+func (m *MinIOClient) ListFiles(ctx context.Context, fn func(*FileInfo) error) (err error) {
+	ctx, done := m.observe(ctx, "list")
+	defer func() { done(err) }()
+
+	// Stop the listing early if fn fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for obj := range m.client.ListObjects(ctx, m.bucketName, minio.ListObjectsOptions{Recursive: true}) {
+		if obj.Err != nil {
+			return fmt.Errorf("failed to list files: %w", obj.Err)
+		}
+		if err := fn(&FileInfo{Hash: obj.Key, Size: obj.Size, LastModified: obj.LastModified}); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
 // GetFileURL returns the URL to access a file
 func (m *MinIOClient) GetFileURL(ctx context.Context, hash string) (string, error) {
 	// For internal access, return the object path
@@ -131,8 +198,7 @@ func (m *MinIOClient) GetFileURL(ctx context.Context, hash string) (string, erro
 
 // FileExists checks if a file with the given hash exists
 func (m *MinIOClient) FileExists(ctx context.Context, hash string) (bool, error) {
-	_, err := m.client.StatObject(ctx, m.bucketName, hash, minio.StatObjectOptions{})
-	if err != nil {
+	if err := m.statObject(ctx, hash); err != nil {
 		errResponse := minio.ToErrorResponse(err)
 		if errResponse.Code == "NoSuchKey" {
 			return false, nil
@@ -141,3 +207,16 @@ func (m *MinIOClient) FileExists(ctx context.Context, hash string) (bool, error)
 	}
 	return true, nil
 }
+
+// statObject checks that an object exists. A missing object is an error, but
+// not a failed request, so it isn't recorded on the span.
+func (m *MinIOClient) statObject(ctx context.Context, hash string) error {
+	ctx, done := m.observe(ctx, "stat", attribute.String("minio.object", hash))
+	_, err := m.client.StatObject(ctx, m.bucketName, hash, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		done(nil)
+	} else {
+		done(err)
+	}
+	return err
+}
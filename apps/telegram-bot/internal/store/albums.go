@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// AddToAlbum registers a stored message as an item of its album. Items can arrive
+// in any order, so the album keeps the earliest message as its address and the
+// first caption seen as the shared caption.
+func (s *PostgresStore) AddToAlbum(ctx context.Context, msg *Message) error {
+	if msg.MediaGroupID == nil {
+		return nil
+	}
+
+	// The items are counted rather than incremented, so adding a message twice counts it once
+	query := `
+		INSERT INTO albums (chat_id, media_group_id, user_id, first_telegram_message_id, message_date, caption, item_count)
+		VALUES ($1, $2, $3, $4, $5, $6,
+			(SELECT COUNT(*) FROM messages WHERE chat_id = $1 AND media_group_id = $2))
+		ON CONFLICT (chat_id, media_group_id) DO UPDATE SET
+			first_telegram_message_id = LEAST(albums.first_telegram_message_id, EXCLUDED.first_telegram_message_id),
+			message_date = LEAST(albums.message_date, EXCLUDED.message_date),
+			caption = COALESCE(albums.caption, EXCLUDED.caption),
+			item_count = EXCLUDED.item_count
+	`
+	_, err := s.db.ExecContext(ctx, query,
+		msg.ChatID, *msg.MediaGroupID, msg.UserID, msg.TelegramMessageID, msg.MessageDate, msg.Text)
+	if err != nil {
+		return fmt.Errorf("failed to add message to album: %w", err)
+	}
+	return nil
+}
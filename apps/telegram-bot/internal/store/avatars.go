@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// UserAvatar is a profile photo of a user archived in MinIO
+type UserAvatar struct {
+	UserID       int64
+	FileUniqueID string
+	MediaSHA256  string
+	Width        int
+	Height       int
+}
+
+// AvatarCheck is a user whose profile photo is due to be checked
+type AvatarCheck struct {
+	UserID int64
+	// FileUniqueID identifies the current archived photo, if any
+	FileUniqueID *string
+	AvatarPolicy
+}
+
+// AvatarPolicy is what the chats a user is in allow to archive of their profile
+// photo. A photo is only archived when the user is in an ingested chat storing
+// media and did not opt out, and when it is no larger than MaxSize, if set.
+type AvatarPolicy struct {
+	Allowed bool
+	MaxSize *int64
+}
+
+// Permits reports whether a photo of the given size may be archived
+func (p AvatarPolicy) Permits(size int64) bool {
+	return p.Allowed && (p.MaxSize == nil || size <= *p.MaxSize)
+}
+
+// ListAvatarChecks returns users whose profile photo was never checked or was
+// last checked before checkedBefore, longest-unchecked first, with their avatar
+// policy. Users who opted out are skipped.
+func (s *PostgresStore) ListAvatarChecks(ctx context.Context, checkedBefore time.Time, limit int) ([]*AvatarCheck, error) {
+	query := `
+		SELECT u.id, u.avatar_file_unique_id, p.allowed, p.max_size
+		FROM users u
+		CROSS JOIN LATERAL avatar_policy(u.id) p
+		WHERE (u.avatar_checked_at IS NULL OR u.avatar_checked_at < $1)
+			AND u.id NOT IN (SELECT user_id FROM user_opt_outs)
+		ORDER BY u.avatar_checked_at NULLS FIRST, u.id
+		LIMIT $2
+	`
+	rows, err := s.db.QueryContext(ctx, query, checkedBefore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list avatar checks: %w", err)
+	}
+	defer rows.Close()
+
+	var checks []*AvatarCheck
+	for rows.Next() {
+		var c AvatarCheck
+		if err := rows.Scan(&c.UserID, &c.FileUniqueID, &c.Allowed, &c.MaxSize); err != nil {
+			return nil, fmt.Errorf("failed to scan avatar check: %w", err)
+		}
+		checks = append(checks, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list avatar checks: %w", err)
+	}
+	return checks, nil
+}
+
+// GetAvatarPolicy returns what may be archived of a user's profile photo
+// under the settings of their chats
+func (s *PostgresStore) GetAvatarPolicy(ctx context.Context, userID int64) (AvatarPolicy, error) {
+	var p AvatarPolicy
+	err := s.db.QueryRowContext(ctx, `SELECT allowed, max_size FROM avatar_policy($1)`, userID).Scan(&p.Allowed, &p.MaxSize)
+	if err != nil {
+		return p, fmt.Errorf("failed to get avatar policy: %w", err)
+	}
+	return p, nil
+}
+
+// SaveUserAvatar records a user's current profile photo
+func (s *PostgresStore) SaveUserAvatar(ctx context.Context, avatar *UserAvatar, checkedAt time.Time) error {
+	return s.WithTx(ctx, func(tx *Tx) error {
+		_, err := tx.db.ExecContext(ctx, `
+			INSERT INTO user_avatars (user_id, file_unique_id, media_sha256, width, height, first_seen_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (user_id, file_unique_id) DO NOTHING
+		`, avatar.UserID, avatar.FileUniqueID, avatar.MediaSHA256, avatar.Width, avatar.Height, checkedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert user avatar: %w", err)
+		}
+
+		_, err = tx.db.ExecContext(ctx, `
+			UPDATE users SET avatar_sha256 = $2, avatar_file_unique_id = $3, avatar_checked_at = $4
+			WHERE id = $1
+		`, avatar.UserID, avatar.MediaSHA256, avatar.FileUniqueID, checkedAt)
+		if err != nil {
+			return fmt.Errorf("failed to update user avatar: %w", err)
+		}
+		return nil
+	})
+}
+
+// MarkAvatarChecked records that a user's profile photo was checked. With
+// removed set, the user has no (visible) profile photo any more.
+func (s *PostgresStore) MarkAvatarChecked(ctx context.Context, userID int64, removed bool, checkedAt time.Time) error {
+	query := `
+		UPDATE users SET
+			avatar_checked_at = $3,
+			avatar_sha256 = CASE WHEN $2 THEN NULL ELSE avatar_sha256 END,
+			avatar_file_unique_id = CASE WHEN $2 THEN NULL ELSE avatar_file_unique_id END
+		WHERE id = $1
+	`
+	if _, err := s.db.ExecContext(ctx, query, userID, removed, checkedAt); err != nil {
+		return fmt.Errorf("failed to mark avatar checked: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Blob is a media file in MinIO with the number of messages, avatars and chat
+// photos referencing it. Reference counts are kept by database triggers.
+type Blob struct {
+	SHA256            string
+	Size              *int64
+	ContentType       *string
+	FirstSeenAt       time.Time
+	RefCount          int
+	UnreferencedSince *time.Time
+}
+
+// ListUnreferencedBlobs returns blobs nothing has referenced since before the
+// given time, in hash order after the given hash
+func (s *PostgresStore) ListUnreferencedBlobs(ctx context.Context, before time.Time, after string, limit int) ([]*Blob, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT sha256, size, content_type, first_seen_at, ref_count, unreferenced_since
+		FROM blobs
+		WHERE ref_count <= 0 AND unreferenced_since < $1 AND sha256 > $2
+		ORDER BY sha256
+		LIMIT $3
+	`, before, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unreferenced blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var blobs []*Blob
+	for rows.Next() {
+		var b Blob
+		if err := rows.Scan(&b.SHA256, &b.Size, &b.ContentType, &b.FirstSeenAt, &b.RefCount, &b.UnreferencedSince); err != nil {
+			return nil, fmt.Errorf("failed to scan blob: %w", err)
+		}
+		blobs = append(blobs, &b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list unreferenced blobs: %w", err)
+	}
+	return blobs, nil
+}
+
+// LockBlob locks a blob's row until the transaction ends, creating it with no
+// references if it does not exist, and returns its reference count. Holding it from
+// the deduplication check in MinIO until the reference is stored keeps DeleteBlob
+// from removing the file in between.
+func (tx *Tx) LockBlob(ctx context.Context, hash string) (int, error) {
+	var refCount int
+	err := tx.db.QueryRowContext(ctx, `
+		INSERT INTO blobs (sha256, ref_count, unreferenced_since)
+		VALUES ($1, 0, NOW())
+		ON CONFLICT (sha256) DO UPDATE SET ref_count = blobs.ref_count
+		RETURNING ref_count
+	`, hash).Scan(&refCount)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock blob: %w", err)
+	}
+	return refCount, nil
+}
+
+// DeleteBlob deletes an unreferenced blob: remove deletes the file from MinIO
+// while the blob's row is locked, then the row is deleted. A blob referenced in
+// the meantime is kept, and false returned without calling remove.
+func (s *PostgresStore) DeleteBlob(ctx context.Context, hash string, remove func(ctx context.Context, hash string) error) (bool, error) {
+	deleted := false
+	err := s.WithTx(ctx, func(tx *Tx) error {
+		refCount, err := tx.LockBlob(ctx, hash)
+		if err != nil {
+			return err
+		}
+		if refCount > 0 {
+			return nil
+		}
+
+		if err := remove(ctx, hash); err != nil {
+			return err
+		}
+		if _, err := tx.db.ExecContext(ctx, `DELETE FROM blobs WHERE sha256 = $1`, hash); err != nil {
+			return fmt.Errorf("failed to delete blob: %w", err)
+		}
+		deleted = true
+		return nil
+	})
+	return deleted, err
+}
+
+// FindOrphans returns the files of a bucket listing that have no blob row, so
+// nothing ever referenced them. Sizes of blobs not known yet are filled in from
+// the listing.
+func (s *PostgresStore) FindOrphans(ctx context.Context, hashes []string, sizes []int64) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		WITH listed AS (
+			SELECT * FROM unnest($1::text[], $2::bigint[]) AS l(sha256, size)
+		), filled AS (
+			UPDATE blobs b SET size = l.size
+			FROM listed l
+			WHERE b.sha256 = l.sha256 AND b.size IS NULL
+		)
+		SELECT l.sha256 FROM listed l
+		WHERE NOT EXISTS (SELECT 1 FROM blobs b WHERE b.sha256 = l.sha256)
+	`, pq.Array(hashes), pq.Array(sizes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find orphans: %w", err)
+	}
+	defer rows.Close()
+
+	var orphans []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed to scan hash: %w", err)
+		}
+		orphans = append(orphans, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find orphans: %w", err)
+	}
+	return orphans, nil
+}
+
+// execReturningHashes runs a statement returning one media hash per row it
+// deleted or changed, and returns the number of rows and their non-null hashes
+func (s *PostgresStore) execReturningHashes(ctx context.Context, query string, args ...interface{}) (int64, []string, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var n int64
+	var hashes []string
+	for rows.Next() {
+		var hash sql.NullString
+		if err := rows.Scan(&hash); err != nil {
+			return 0, nil, err
+		}
+		n++
+		if hash.Valid {
+			hashes = append(hashes, hash.String)
+		}
+	}
+	return n, hashes, rows.Err()
+}
+
+// unreferencedBlobs returns the given hashes whose reference count dropped to zero
+func (s *PostgresStore) unreferencedBlobs(ctx context.Context, hashes []string) ([]string, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT sha256 FROM blobs WHERE sha256 = ANY($1) AND ref_count <= 0`, pq.Array(hashes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find unreferenced blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var unreferenced []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed to scan hash: %w", err)
+		}
+		unreferenced = append(unreferenced, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find unreferenced blobs: %w", err)
+	}
+	return unreferenced, nil
+}
+
+// deleteMediaResults deletes the transcripts and enrichments of media files
+func (s *PostgresStore) deleteMediaResults(ctx context.Context, hashes []string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	for _, table := range []string{"media_transcripts", "media_enrichments"} {
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM `+table+` WHERE media_sha256 = ANY($1)`, pq.Array(hashes)); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", table, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBlobRefCounts(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	testChat(t, s, -1001)
+	testUser(t, s, 1, "ana")
+
+	shared := testHash('a')
+	now := time.Now()
+	first := testMessage(t, s, -1001, 1, 1, now, shared)
+	testMessage(t, s, -1001, 2, 1, now, shared)
+	if refs, _ := blobRefs(t, s, shared); refs != 2 {
+		t.Fatalf("ref_count after two messages = %d, want 2", refs)
+	}
+
+	// Avatars and chat photos count as references too
+	if err := s.SaveUserAvatar(ctx, &UserAvatar{UserID: 1, FileUniqueID: "u1", MediaSHA256: shared}, now); err != nil {
+		t.Fatal(err)
+	}
+	photo := &ServiceMessage{TelegramMessageID: 3, ChatID: -1001, MessageDate: now, Action: "new_chat_photo",
+		Metadata: []byte(`{"media_sha256":"` + shared + `"}`)}
+	if err := s.InsertServiceMessage(ctx, photo); err != nil {
+		t.Fatal(err)
+	}
+	if refs, _ := blobRefs(t, s, shared); refs != 4 {
+		t.Fatalf("ref_count with avatar and chat photo = %d, want 4", refs)
+	}
+
+	// Removing or replacing a reference drops it
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM messages WHERE id = $1`, first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.ExecContext(ctx, `UPDATE service_messages SET metadata = '{}' WHERE id = $1`, photo.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM user_avatars WHERE user_id = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if refs, _ := blobRefs(t, s, shared); refs != 1 {
+		t.Fatalf("ref_count after removing three references = %d, want 1", refs)
+	}
+
+	other := testHash('b')
+	if _, err := s.db.ExecContext(ctx, `UPDATE messages SET media_sha256 = $1 WHERE chat_id = -1001`, other); err != nil {
+		t.Fatal(err)
+	}
+	if refs, _ := blobRefs(t, s, other); refs != 1 {
+		t.Errorf("ref_count of new hash = %d, want 1", refs)
+	}
+
+	var refs int
+	var unreferencedSince *time.Time
+	err := s.db.QueryRowContext(ctx, `SELECT ref_count, unreferenced_since FROM blobs WHERE sha256 = $1`, shared).
+		Scan(&refs, &unreferencedSince)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs != 0 || unreferencedSince == nil {
+		t.Errorf("unreferenced blob has ref_count %d, unreferenced_since %v; want 0 and set", refs, unreferencedSince)
+	}
+
+	unreferenced, err := s.unreferencedBlobs(ctx, []string{shared, other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unreferenced) != 1 || unreferenced[0] != shared {
+		t.Errorf("unreferencedBlobs = %v, want [%s]", unreferenced, shared)
+	}
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Briefing represents a chat summary generated by the llm-analyzer
+type Briefing struct {
+	ID     int64
+	ChatID int64
+	// SourceChatID is the chat id the briefing was written for, which the
+	// message ids of its items belong to; ChatID differs once the chat migrated
+	SourceChatID int64
+	// MessageThreadID is set for briefings covering a single forum topic
+	MessageThreadID *int64
+	PeriodStart     time.Time
+	PeriodEnd       time.Time
+	Title           *string
+	Summary         string
+	Items           []BriefingItem
+	PublishedAt     *time.Time
+	// RenderedParts are the messages the briefing is posted as, stored once
+	// publishing starts; SentMessageIDs holds the ids of those already posted
+	RenderedParts  []string
+	SentMessageIDs []int64
+	// PublishAttempts counts the failed attempts to post the briefing
+	PublishAttempts int
+	CreatedAt       time.Time
+}
+
+// BriefingItem is a single highlight of a briefing with the messages it refers to
+type BriefingItem struct {
+	Text       string  `json:"text"`
+	MessageIDs []int64 `json:"message_ids"`
+}
+
+// ListUnpublishedBriefings returns briefings created up to createdBefore that
+// have not been fully posted yet nor given up on, oldest first. ChatID is the
+// chat's current id, so briefings for a group that was since upgraded go to the
+// supergroup, while SourceChatID keeps the id their messages were stored under.
+// Briefings of chats the bot left, or that are not ingested (off the allowlist
+// or paused), are left out until the chat is back.
+func (s *PostgresStore) ListUnpublishedBriefings(ctx context.Context, createdBefore time.Time) ([]*Briefing, error) {
+	query := `
+		SELECT b.id, b.canonical_id, b.chat_id, b.message_thread_id, b.period_start, b.period_end, b.title, b.summary, b.items,
+			b.published_at, b.rendered_parts, b.sent_message_ids, b.publish_attempts, b.created_at
+		FROM (
+			SELECT *, canonical_chat_id(chat_id) AS canonical_id FROM briefings
+			WHERE published_at IS NULL
+				AND failed_at IS NULL
+				AND created_at <= $1
+		) b
+		JOIN chats c ON c.id = b.canonical_id AND c.is_active
+		JOIN chat_settings cs ON cs.chat_id = b.canonical_id AND cs.allowed AND NOT cs.paused
+		ORDER BY b.created_at, b.id
+	`
+	rows, err := s.db.QueryContext(ctx, query, createdBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unpublished briefings: %w", err)
+	}
+	defer rows.Close()
+
+	var briefings []*Briefing
+	for rows.Next() {
+		var b Briefing
+		var items []byte
+		var parts pq.StringArray
+		var sent pq.Int64Array
+		if err := rows.Scan(&b.ID, &b.ChatID, &b.SourceChatID, &b.MessageThreadID, &b.PeriodStart, &b.PeriodEnd, &b.Title, &b.Summary, &items,
+			&b.PublishedAt, &parts, &sent, &b.PublishAttempts, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan briefing: %w", err)
+		}
+		if len(items) > 0 {
+			if err := json.Unmarshal(items, &b.Items); err != nil {
+				return nil, fmt.Errorf("failed to decode items of briefing %d: %w", b.ID, err)
+			}
+		}
+		b.RenderedParts = parts
+		b.SentMessageIDs = sent
+		briefings = append(briefings, &b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list unpublished briefings: %w", err)
+	}
+	return briefings, nil
+}
+
+// SaveBriefingParts stores the messages a briefing is posted as. Parts stored
+// already are kept, and returned instead, so every attempt posts the same parts.
+func (s *PostgresStore) SaveBriefingParts(ctx context.Context, briefingID int64, parts []string) ([]string, error) {
+	query := `
+		UPDATE briefings
+		SET rendered_parts = COALESCE(rendered_parts, $2)
+		WHERE id = $1
+		RETURNING rendered_parts
+	`
+	var stored pq.StringArray
+	if err := s.db.QueryRowContext(ctx, query, briefingID, pq.Array(parts)).Scan(&stored); err != nil {
+		return nil, fmt.Errorf("failed to store briefing parts: %w", err)
+	}
+	return stored, nil
+}
+
+// AppendBriefingMessageID records the Telegram id of a posted briefing part
+func (s *PostgresStore) AppendBriefingMessageID(ctx context.Context, briefingID, telegramMessageID int64) error {
+	query := `
+		UPDATE briefings
+		SET sent_message_ids = array_append(sent_message_ids, $2)
+		WHERE id = $1
+	`
+	_, err := s.db.ExecContext(ctx, query, briefingID, telegramMessageID)
+	if err != nil {
+		return fmt.Errorf("failed to record briefing message id: %w", err)
+	}
+	return nil
+}
+
+// MarkBriefingPublished marks a briefing as fully posted
+func (s *PostgresStore) MarkBriefingPublished(ctx context.Context, briefingID int64, publishedAt time.Time) error {
+	query := `UPDATE briefings SET published_at = $2 WHERE id = $1 AND published_at IS NULL`
+	_, err := s.db.ExecContext(ctx, query, briefingID, publishedAt)
+	if err != nil {
+		return fmt.Errorf("failed to mark briefing published: %w", err)
+	}
+	return nil
+}
+
+// RecordBriefingFailure records a failed attempt to post a briefing, and gives
+// up on it once it has failed maxAttempts times. It reports whether the
+// briefing was given up on.
+func (s *PostgresStore) RecordBriefingFailure(ctx context.Context, briefingID int64, reason string, maxAttempts int) (bool, error) {
+	query := `
+		UPDATE briefings
+		SET publish_attempts = publish_attempts + 1,
+			publish_error = $2,
+			failed_at = CASE WHEN publish_attempts + 1 >= $3 THEN NOW() END
+		WHERE id = $1 AND published_at IS NULL
+		RETURNING failed_at IS NOT NULL
+	`
+	var failed bool
+	err := s.db.QueryRowContext(ctx, query, briefingID, reason, maxAttempts).Scan(&failed)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to record briefing failure: %w", err)
+	}
+	return failed, nil
+}
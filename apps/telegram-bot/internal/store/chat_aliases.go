@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// LinkChatMigration records that fromChatID was upgraded to toChatID. The new chat
+// becomes the canonical id of the logical chat, including for any older aliases,
+// and takes over the settings of the old one unless it has its own.
+func (s *PostgresStore) LinkChatMigration(ctx context.Context, fromChatID, toChatID int64, name string) error {
+	return s.WithTx(ctx, func(tx *Tx) error {
+		_, err := tx.db.ExecContext(ctx, `
+			INSERT INTO chats (id, type, name)
+			VALUES ($1, 'supergroup', $2)
+			ON CONFLICT (id) DO NOTHING
+		`, toChatID, name)
+		if err != nil {
+			return fmt.Errorf("failed to create migrated chat: %w", err)
+		}
+
+		_, err = tx.db.ExecContext(ctx, `
+			INSERT INTO chat_aliases (alias_chat_id, chat_id)
+			VALUES ($1, $2)
+			ON CONFLICT (alias_chat_id) DO UPDATE SET chat_id = EXCLUDED.chat_id
+		`, fromChatID, toChatID)
+		if err != nil {
+			return fmt.Errorf("failed to insert chat alias: %w", err)
+		}
+
+		// Aliases of the old chat now point to the new one
+		_, err = tx.db.ExecContext(ctx, `UPDATE chat_aliases SET chat_id = $2 WHERE chat_id = $1`, fromChatID, toChatID)
+		if err != nil {
+			return fmt.Errorf("failed to update chat aliases: %w", err)
+		}
+
+		_, err = tx.db.ExecContext(ctx, `UPDATE chats SET migrated_to_chat_id = $2 WHERE id = $1`, fromChatID, toChatID)
+		if err != nil {
+			return fmt.Errorf("failed to mark chat migrated: %w", err)
+		}
+
+		// The new chat keeps the settings of the old one
+		_, err = tx.db.ExecContext(ctx, `
+			INSERT INTO chat_settings (chat_id, allowed, paused, message_types, store_media, max_media_size, store_locations,
+				text_retention_days, media_retention_days, location_retention_days, updated_by)
+			SELECT $2, allowed, paused, message_types, store_media, max_media_size, store_locations,
+				text_retention_days, media_retention_days, location_retention_days, updated_by
+			FROM chat_settings WHERE chat_id = $1
+			ON CONFLICT (chat_id) DO NOTHING
+		`, fromChatID, toChatID)
+		if err != nil {
+			return fmt.Errorf("failed to copy chat settings: %w", err)
+		}
+		return nil
+	})
+}
+
+// ResolveChatIDs returns every id of the logical chat chatID belongs to, current id first
+func (s *PostgresStore) ResolveChatIDs(ctx context.Context, chatID int64) ([]int64, error) {
+	var ids pq.Int64Array
+	err := s.db.QueryRowContext(ctx, `SELECT chat_id_group($1)`, chatID).Scan(&ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve chat ids: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Chat member statuses, as reported by Telegram
+const (
+	MemberStatusMember = "member"
+	MemberStatusLeft   = "left"
+)
+
+// ChatMember is the current status of a user in a chat
+type ChatMember struct {
+	ChatID      int64
+	UserID      int64
+	Status      string
+	IsMember    bool
+	CustomTitle *string
+	UntilDate   *time.Time
+	Rights      json.RawMessage // administrator rights or restrictions
+}
+
+// ChatMemberChange describes who changed a member's status, when, and where we learned it
+type ChatMemberChange struct {
+	ActorUserID *int64
+	Source      string // "chat_member", "my_chat_member" or "service_message"
+	Metadata    json.RawMessage
+	ChangedAt   time.Time
+}
+
+// UpdateChatMember stores a member's status as reported by a chat_member or
+// my_chat_member update and records the transition. It reports whether anything
+// changed; repeated reports of the same status are not recorded twice.
+func (s *PostgresStore) UpdateChatMember(ctx context.Context, member *ChatMember, change *ChatMemberChange) (bool, error) {
+	rights := member.Rights
+	if len(rights) == 0 {
+		rights = nil
+	}
+
+	query := `
+		INSERT INTO chat_members (chat_id, user_id, status, is_member, custom_title, until_date, rights, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (chat_id, user_id) DO UPDATE SET
+			status = EXCLUDED.status,
+			is_member = EXCLUDED.is_member,
+			custom_title = EXCLUDED.custom_title,
+			until_date = EXCLUDED.until_date,
+			rights = EXCLUDED.rights,
+			updated_at = EXCLUDED.updated_at
+		WHERE (chat_members.status, chat_members.is_member, chat_members.custom_title, chat_members.until_date, chat_members.rights)
+			IS DISTINCT FROM (EXCLUDED.status, EXCLUDED.is_member, EXCLUDED.custom_title, EXCLUDED.until_date, EXCLUDED.rights)
+	`
+	return s.changeChatMember(ctx, member.ChatID, member.UserID, member.Status, change, query,
+		member.ChatID, member.UserID, member.Status, member.IsMember, member.CustomTitle, member.UntilDate, rights, change.ChangedAt)
+}
+
+// SetChatMembership records a user joining or leaving a chat, as seen in a service
+// message. Only membership itself is updated: a known status that already agrees
+// (e.g. a restricted member joining, or a banned user leaving) is kept as is.
+func (s *PostgresStore) SetChatMembership(ctx context.Context, chatID, userID int64, isMember bool, change *ChatMemberChange) (bool, error) {
+	status := MemberStatusLeft
+	if isMember {
+		status = MemberStatusMember
+	}
+
+	query := `
+		INSERT INTO chat_members (chat_id, user_id, status, is_member, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (chat_id, user_id) DO UPDATE SET
+			status = EXCLUDED.status,
+			is_member = EXCLUDED.is_member,
+			custom_title = NULL,
+			until_date = NULL,
+			rights = NULL,
+			updated_at = EXCLUDED.updated_at
+		WHERE chat_members.is_member <> EXCLUDED.is_member
+	`
+	return s.changeChatMember(ctx, chatID, userID, status, change, query,
+		chatID, userID, status, isMember, change.ChangedAt)
+}
+
+// changeChatMember runs a conditional member upsert and, if it changed the row,
+// records the transition from the previous status
+func (s *PostgresStore) changeChatMember(ctx context.Context, chatID, userID int64, newStatus string,
+	change *ChatMemberChange, upsert string, args ...interface{}) (bool, error) {
+	var changed bool
+	err := s.WithTx(ctx, func(tx *Tx) error {
+		var oldStatus *string
+		err := tx.db.QueryRowContext(ctx,
+			`SELECT status FROM chat_members WHERE chat_id = $1 AND user_id = $2 FOR UPDATE`, chatID, userID).Scan(&oldStatus)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to get chat member: %w", err)
+		}
+
+		res, err := tx.db.ExecContext(ctx, upsert, args...)
+		if err != nil {
+			return fmt.Errorf("failed to upsert chat member: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to upsert chat member: %w", err)
+		}
+		if n == 0 {
+			return nil
+		}
+
+		metadata := change.Metadata
+		if len(metadata) == 0 {
+			metadata = nil
+		}
+		_, err = tx.db.ExecContext(ctx, `
+			INSERT INTO chat_member_events (chat_id, user_id, actor_user_id, old_status, new_status, source, metadata, changed_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, chatID, userID, change.ActorUserID, oldStatus, newStatus, change.Source, metadata, change.ChangedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert chat member event: %w", err)
+		}
+
+		changed = true
+		return nil
+	})
+	return changed, err
+}
+
+// SetChatBotStatus records the bot's own status in a chat. Chats the bot is no
+// longer in are marked inactive and are not ingested until it is added back.
+func (s *PostgresStore) SetChatBotStatus(ctx context.Context, chatID int64, status string, active bool, at time.Time) error {
+	query := `
+		UPDATE chats SET
+			bot_status = $2,
+			is_active = $3,
+			deactivated_at = CASE WHEN $3 THEN NULL ELSE $4::TIMESTAMPTZ END
+		WHERE id = $1
+	`
+	if _, err := s.db.ExecContext(ctx, query, chatID, status, active, at); err != nil {
+		return fmt.Errorf("failed to update chat bot status: %w", err)
+	}
+	return nil
+}
+
+// ListInactiveChatIDs returns the chats the bot was removed from
+func (s *PostgresStore) ListInactiveChatIDs(ctx context.Context) ([]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM chats WHERE NOT is_active`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list inactive chats: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan chat id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list inactive chats: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ChatSettings is the ingestion policy of a chat
+type ChatSettings struct {
+	ChatID         int64
+	Allowed        bool     // on the allowlist: the chat is ingested
+	Paused         bool     // ingestion stopped for now by a chat admin
+	MessageTypes   []string // message types stored; empty stores every type
+	StoreMedia     bool     // download media files to MinIO
+	MaxMediaSize   *int64   // largest media file downloaded, in bytes; nil for no limit
+	StoreLocations bool     // keep coordinates of locations and venues
+	// Days messages, media files and coordinates are kept; nil keeps them forever
+	TextRetention     *int
+	MediaRetention    *int
+	LocationRetention *int
+	UpdatedBy         *int64
+	UpdatedAt         time.Time
+}
+
+// DefaultChatSettings returns the settings of a chat that has none stored: not
+// allowed, and storing everything once it is
+func DefaultChatSettings(chatID int64) *ChatSettings {
+	return &ChatSettings{
+		ChatID:         chatID,
+		StoreMedia:     true,
+		StoreLocations: true,
+	}
+}
+
+// ListChatSettings returns the settings of every chat that has some stored
+func (s *PostgresStore) ListChatSettings(ctx context.Context) ([]*ChatSettings, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT chat_id, allowed, paused, message_types, store_media, max_media_size, store_locations,
+			text_retention_days, media_retention_days, location_retention_days, updated_by, updated_at
+		FROM chat_settings
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chat settings: %w", err)
+	}
+	defer rows.Close()
+
+	var list []*ChatSettings
+	for rows.Next() {
+		var cs ChatSettings
+		if err := rows.Scan(&cs.ChatID, &cs.Allowed, &cs.Paused, pq.Array(&cs.MessageTypes), &cs.StoreMedia,
+			&cs.MaxMediaSize, &cs.StoreLocations, &cs.TextRetention, &cs.MediaRetention, &cs.LocationRetention,
+			&cs.UpdatedBy, &cs.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan chat settings: %w", err)
+		}
+		list = append(list, &cs)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list chat settings: %w", err)
+	}
+	return list, nil
+}
+
+// SaveChatSettings creates or replaces the settings of a chat
+func (s *PostgresStore) SaveChatSettings(ctx context.Context, cs *ChatSettings) error {
+	var messageTypes interface{}
+	if len(cs.MessageTypes) > 0 {
+		messageTypes = pq.Array(cs.MessageTypes)
+	}
+
+	query := `
+		INSERT INTO chat_settings (chat_id, allowed, paused, message_types, store_media, max_media_size, store_locations,
+			text_retention_days, media_retention_days, location_retention_days, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (chat_id) DO UPDATE SET
+			allowed = EXCLUDED.allowed,
+			paused = EXCLUDED.paused,
+			message_types = EXCLUDED.message_types,
+			store_media = EXCLUDED.store_media,
+			max_media_size = EXCLUDED.max_media_size,
+			store_locations = EXCLUDED.store_locations,
+			text_retention_days = EXCLUDED.text_retention_days,
+			media_retention_days = EXCLUDED.media_retention_days,
+			location_retention_days = EXCLUDED.location_retention_days,
+			updated_by = EXCLUDED.updated_by
+	`
+	_, err := s.db.ExecContext(ctx, query,
+		cs.ChatID, cs.Allowed, cs.Paused, messageTypes, cs.StoreMedia, cs.MaxMediaSize, cs.StoreLocations,
+		cs.TextRetention, cs.MediaRetention, cs.LocationRetention, cs.UpdatedBy)
+	if err != nil {
+		return fmt.Errorf("failed to save chat settings: %w", err)
+	}
+	return nil
+}
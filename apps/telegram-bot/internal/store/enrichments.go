@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// Enrichment represents text extracted from an image blob
+type Enrichment struct {
+	MediaSHA256 string
+	OCRText     *string
+	Caption     *string
+	Model       string
+}
+
+// ListPendingEnrichments returns image blobs (photos and image documents) that have
+// not been analysed yet, or whose previous attempts failed fewer than maxAttempts times
+func (s *PostgresStore) ListPendingEnrichments(ctx context.Context, maxAttempts, limit int) ([]*MediaJob, error) {
+	query := `
+		SELECT m.media_sha256, MAX(COALESCE(m.media_mime_type, '')), COALESCE(MAX(e.attempts), 0)
+		FROM messages m
+		LEFT JOIN media_enrichments e ON e.media_sha256 = m.media_sha256
+		WHERE (m.message_type = 'photo'
+				OR (m.message_type = 'document' AND m.media_mime_type LIKE 'image/%'))
+			AND m.media_sha256 IS NOT NULL
+			AND (e.media_sha256 IS NULL OR (e.status = 'failed' AND e.attempts < $1))
+		GROUP BY m.media_sha256
+		ORDER BY MIN(m.message_date)
+		LIMIT $2
+	`
+	rows, err := s.db.QueryContext(ctx, query, maxAttempts, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending enrichments: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*MediaJob
+	for rows.Next() {
+		var job MediaJob
+		if err := rows.Scan(&job.MediaSHA256, &job.MimeType, &job.Attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan enrichment job: %w", err)
+		}
+		jobs = append(jobs, &job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list pending enrichments: %w", err)
+	}
+	return jobs, nil
+}
+
+// SaveEnrichment stores the result of analysing an image
+func (s *PostgresStore) SaveEnrichment(ctx context.Context, e *Enrichment) error {
+	query := `
+		INSERT INTO media_enrichments (media_sha256, status, ocr_text, caption, model, attempts, error)
+		VALUES ($1, 'done', $2, $3, $4, 1, NULL)
+		ON CONFLICT (media_sha256) DO UPDATE SET
+			status = 'done',
+			ocr_text = EXCLUDED.ocr_text,
+			caption = EXCLUDED.caption,
+			model = EXCLUDED.model,
+			attempts = media_enrichments.attempts + 1,
+			error = NULL
+	`
+	_, err := s.db.ExecContext(ctx, query, e.MediaSHA256, e.OCRText, e.Caption, e.Model)
+	if err != nil {
+		return fmt.Errorf("failed to save enrichment: %w", err)
+	}
+	return nil
+}
+
+// MarkEnrichmentFailed records a failed analysis attempt
+func (s *PostgresStore) MarkEnrichmentFailed(ctx context.Context, mediaSHA256, reason string) error {
+	query := `
+		INSERT INTO media_enrichments (media_sha256, status, attempts, error)
+		VALUES ($1, 'failed', 1, $2)
+		ON CONFLICT (media_sha256) DO UPDATE SET
+			status = 'failed',
+			attempts = media_enrichments.attempts + 1,
+			error = EXCLUDED.error
+	`
+	_, err := s.db.ExecContext(ctx, query, mediaSHA256, reason)
+	if err != nil {
+		return fmt.Errorf("failed to mark enrichment failed: %w", err)
+	}
+	return nil
+}
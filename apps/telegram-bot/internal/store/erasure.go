@@ -0,0 +1,221 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Erasure is the audit record of a user's data being erased
+type Erasure struct {
+	ID                        int64
+	UserID                    int64
+	ChatID                    int64 // chat the request was made in
+	MessagesDeleted           int64
+	ReactionsDeleted          int64
+	PollVotesDeleted          int64
+	PollsDeleted              int64
+	ServiceMessagesAnonymised int64
+	MemberEventsDeleted       int64
+	// UnreferencedBlobs are the MinIO objects nothing references any more; they are
+	// deleted by the caller once the erasure is committed
+	UnreferencedBlobs []string
+	StartedAt         time.Time
+}
+
+// EraseUser opts a user out and, in one transaction, deletes or anonymises what
+// is stored about them across chats:
+//   - their messages and albums, with the reactions on them, are deleted
+//   - their reactions, poll votes and polls are deleted
+//   - service messages keep the event but lose the user as actor, joined/left
+//     member or invitee
+//   - their member history and profile (names, name history, avatars) are
+//     deleted; the user remains as a bare id
+//
+// Transcripts and enrichments of media nothing else references are deleted, and
+// the hashes returned so the blobs can be removed. An audit record is written
+// without any of the erased data.
+func (s *PostgresStore) EraseUser(ctx context.Context, userID, chatID int64) (*Erasure, error) {
+	e := &Erasure{UserID: userID, ChatID: chatID}
+	err := s.WithTx(ctx, func(tx *Tx) error {
+		// Writes about the user that hold the lock, like a profile photo being
+		// saved, are committed before the erasure starts; later ones see the opt-out
+		if err := tx.LockUser(ctx, userID); err != nil {
+			return err
+		}
+		// Opt out first, so the profile trigger strips the user from here on
+		if err := tx.OptOutUser(ctx, userID, chatID); err != nil {
+			return err
+		}
+		if err := tx.eraseUserData(ctx, e); err != nil {
+			return err
+		}
+
+		err := tx.db.QueryRowContext(ctx, `
+			INSERT INTO user_erasures (
+				user_id, chat_id, messages_deleted, reactions_deleted, poll_votes_deleted, polls_deleted,
+				service_messages_anonymised, member_events_deleted, blobs_unreferenced
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING id, started_at
+		`, userID, chatID, e.MessagesDeleted, e.ReactionsDeleted, e.PollVotesDeleted, e.PollsDeleted,
+			e.ServiceMessagesAnonymised, e.MemberEventsDeleted, len(e.UnreferencedBlobs)).Scan(&e.ID, &e.StartedAt)
+		if err != nil {
+			return fmt.Errorf("failed to store erasure: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// SweepErasure erases again what is stored about the user of an erasure, to
+// catch updates that were already being processed when they opted out. The
+// counts and the blobs it left unreferenced are added to the erasure, and the
+// counts to its audit record.
+func (s *PostgresStore) SweepErasure(ctx context.Context, e *Erasure) error {
+	sweep := &Erasure{UserID: e.UserID}
+	err := s.WithTx(ctx, func(tx *Tx) error {
+		if err := tx.eraseUserData(ctx, sweep); err != nil {
+			return err
+		}
+
+		_, err := tx.exec(ctx, `
+			UPDATE user_erasures SET
+				messages_deleted = messages_deleted + $2,
+				reactions_deleted = reactions_deleted + $3,
+				poll_votes_deleted = poll_votes_deleted + $4,
+				polls_deleted = polls_deleted + $5,
+				service_messages_anonymised = service_messages_anonymised + $6,
+				member_events_deleted = member_events_deleted + $7,
+				blobs_unreferenced = blobs_unreferenced + $8
+			WHERE id = $1
+		`, e.ID, sweep.MessagesDeleted, sweep.ReactionsDeleted, sweep.PollVotesDeleted, sweep.PollsDeleted,
+			sweep.ServiceMessagesAnonymised, sweep.MemberEventsDeleted, len(sweep.UnreferencedBlobs))
+		if err != nil {
+			return fmt.Errorf("failed to update erasure: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	e.MessagesDeleted += sweep.MessagesDeleted
+	e.ReactionsDeleted += sweep.ReactionsDeleted
+	e.PollVotesDeleted += sweep.PollVotesDeleted
+	e.PollsDeleted += sweep.PollsDeleted
+	e.ServiceMessagesAnonymised += sweep.ServiceMessagesAnonymised
+	e.MemberEventsDeleted += sweep.MemberEventsDeleted
+	e.UnreferencedBlobs = append(e.UnreferencedBlobs, sweep.UnreferencedBlobs...)
+	return nil
+}
+
+// eraseUserData deletes or anonymises what is stored about the erasure's user,
+// setting its counts and the blobs left unreferenced
+func (tx *Tx) eraseUserData(ctx context.Context, e *Erasure) error {
+	userID := e.UserID
+	var hashes []string
+	var err error
+	if e.ReactionsDeleted, err = tx.exec(ctx, `DELETE FROM message_reactions WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete reactions: %w", err)
+	}
+	if e.MessagesDeleted, hashes, err = tx.execReturningHashes(ctx,
+		`DELETE FROM messages WHERE user_id = $1 RETURNING media_sha256`, userID); err != nil {
+		return fmt.Errorf("failed to delete messages: %w", err)
+	}
+	if _, err = tx.exec(ctx, `DELETE FROM albums WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete albums: %w", err)
+	}
+	if _, err = tx.exec(ctx,
+		`UPDATE messages SET forwarded_from_user_id = NULL WHERE forwarded_from_user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to anonymise forwards: %w", err)
+	}
+
+	if e.PollVotesDeleted, err = tx.exec(ctx, `DELETE FROM poll_votes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete poll votes: %w", err)
+	}
+	if e.PollsDeleted, err = tx.exec(ctx, `DELETE FROM polls WHERE creator_user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete polls: %w", err)
+	}
+
+	if e.ServiceMessagesAnonymised, err = tx.exec(ctx, `
+		UPDATE service_messages SET
+			actor_user_id = NULLIF(actor_user_id, $1),
+			metadata = CASE
+				WHEN metadata->>'joined_user_id' = $1::bigint::text
+					THEN metadata - ARRAY['joined_user_id', 'joined_username', 'joined_first_name', 'joined_last_name']
+				WHEN metadata->>'left_user_id' = $1::bigint::text
+					THEN metadata - ARRAY['left_user_id', 'left_username', 'left_first_name', 'left_last_name']
+				WHEN jsonb_typeof(metadata->'joined_users') = 'array' AND metadata->'joined_users' @> jsonb_build_array(jsonb_build_object('user_id', $1::bigint))
+					THEN jsonb_set(metadata, '{joined_users}', (
+						SELECT COALESCE(jsonb_agg(u), '[]'::jsonb)
+						FROM jsonb_array_elements(metadata->'joined_users') u
+						WHERE u->'user_id' <> to_jsonb($1::bigint)))
+				WHEN jsonb_typeof(metadata->'invited_user_ids') = 'array' AND metadata->'invited_user_ids' @> to_jsonb($1::bigint)
+					THEN jsonb_set(metadata, '{invited_user_ids}', (
+						SELECT COALESCE(jsonb_agg(id), '[]'::jsonb)
+						FROM jsonb_array_elements(metadata->'invited_user_ids') id
+						WHERE id <> to_jsonb($1::bigint)))
+				ELSE metadata
+			END
+		WHERE actor_user_id = $1
+			OR metadata->>'joined_user_id' = $1::bigint::text
+			OR metadata->>'left_user_id' = $1::bigint::text
+			OR (jsonb_typeof(metadata->'joined_users') = 'array' AND metadata->'joined_users' @> jsonb_build_array(jsonb_build_object('user_id', $1::bigint)))
+			OR (jsonb_typeof(metadata->'invited_user_ids') = 'array' AND metadata->'invited_user_ids' @> to_jsonb($1::bigint))
+	`, userID); err != nil {
+		return fmt.Errorf("failed to anonymise service messages: %w", err)
+	}
+
+	if e.MemberEventsDeleted, err = tx.exec(ctx, `DELETE FROM chat_member_events WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete member events: %w", err)
+	}
+	if _, err = tx.exec(ctx,
+		`UPDATE chat_member_events SET actor_user_id = NULL WHERE actor_user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to anonymise member events: %w", err)
+	}
+	if _, err = tx.exec(ctx,
+		`UPDATE forum_topics SET created_by_user_id = NULL WHERE created_by_user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to anonymise forum topics: %w", err)
+	}
+
+	_, avatars, err := tx.execReturningHashes(ctx,
+		`DELETE FROM user_avatars WHERE user_id = $1 RETURNING media_sha256`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete avatars: %w", err)
+	}
+	hashes = append(hashes, avatars...)
+
+	// Clearing the names records them in user_name_history, so the history goes last
+	if _, err = tx.exec(ctx, `
+		UPDATE users SET
+			username = NULL, first_name = NULL, last_name = NULL, language_code = NULL,
+			is_premium = NULL, avatar_sha256 = NULL, avatar_file_unique_id = NULL
+		WHERE id = $1
+	`, userID); err != nil {
+		return fmt.Errorf("failed to anonymise user: %w", err)
+	}
+	if _, err = tx.exec(ctx, `DELETE FROM user_name_history WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete name history: %w", err)
+	}
+
+	if e.UnreferencedBlobs, err = tx.unreferencedBlobs(ctx, hashes); err != nil {
+		return err
+	}
+	return tx.deleteMediaResults(ctx, e.UnreferencedBlobs)
+}
+
+// CompleteErasure records how many of an erasure's blobs were deleted, and why
+// the others were not
+func (s *PostgresStore) CompleteErasure(ctx context.Context, erasureID int64, blobsDeleted int, reason *string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE user_erasures SET blobs_deleted = $2, error = $3, completed_at = NOW()
+		WHERE id = $1
+	`, erasureID, blobsDeleted, reason)
+	if err != nil {
+		return fmt.Errorf("failed to complete erasure: %w", err)
+	}
+	return nil
+}
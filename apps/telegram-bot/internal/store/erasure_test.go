@@ -0,0 +1,152 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEraseUser(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	testChat(t, s, -1001)
+	testUser(t, s, 1, "ana")
+	testUser(t, s, 2, "bia")
+
+	own, shared, avatar := testHash('a'), testHash('s'), testHash('v')
+	now := time.Now()
+	testMessage(t, s, -1001, 1, 1, now, own)
+	testMessage(t, s, -1001, 2, 1, now, shared)
+	testMessage(t, s, -1001, 3, 1, now, "")
+	other := testMessage(t, s, -1001, 4, 2, now, shared)
+	if err := s.InsertReaction(ctx, &Reaction{MessageID: other, UserID: 1, Emoji: "🔥", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveUserAvatar(ctx, &UserAvatar{UserID: 1, FileUniqueID: "u1", MediaSHA256: avatar}, now); err != nil {
+		t.Fatal(err)
+	}
+	actor := int64(1)
+	joined := &ServiceMessage{TelegramMessageID: 5, ChatID: -1001, ActorUserID: &actor, MessageDate: now, Action: "user_joined",
+		Metadata: []byte(`{"joined_users":[{"user_id":1,"username":"ana"},{"user_id":2,"username":"bia"}]}`)}
+	if err := s.InsertServiceMessage(ctx, joined); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := s.EraseUser(ctx, 1, -1001)
+	if err != nil {
+		t.Fatalf("EraseUser: %v", err)
+	}
+	if e.MessagesDeleted != 3 || e.ReactionsDeleted != 1 || e.ServiceMessagesAnonymised != 1 {
+		t.Errorf("erased %d messages, %d reactions, %d service messages; want 3, 1, 1",
+			e.MessagesDeleted, e.ReactionsDeleted, e.ServiceMessagesAnonymised)
+	}
+
+	// The file bia still posts is kept
+	slices.Sort(e.UnreferencedBlobs)
+	if want := []string{own, avatar}; !slices.Equal(e.UnreferencedBlobs, want) {
+		t.Errorf("UnreferencedBlobs = %v, want %v", e.UnreferencedBlobs, want)
+	}
+	if refs, _ := blobRefs(t, s, shared); refs != 1 {
+		t.Errorf("ref_count of shared file = %d, want 1", refs)
+	}
+
+	var actorID *int64
+	var metadata []byte
+	if err := s.db.QueryRowContext(ctx, `SELECT actor_user_id, metadata FROM service_messages WHERE id = $1`, joined.ID).
+		Scan(&actorID, &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if actorID != nil {
+		t.Errorf("service message actor = %d, want none", *actorID)
+	}
+	if got := joinedUserIDs(t, metadata); !slices.Equal(got, []int64{2}) {
+		t.Errorf("joined users = %v, want [2]", got)
+	}
+
+	var username *string
+	if err := s.db.QueryRowContext(ctx, `SELECT username FROM users WHERE id = 1`).Scan(&username); err != nil {
+		t.Fatal(err)
+	}
+	if username != nil {
+		t.Errorf("username = %q, want none", *username)
+	}
+
+	opted, err := s.ListOptedOutUserIDs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(opted, 1) {
+		t.Errorf("opted out users = %v, want 1 among them", opted)
+	}
+}
+
+func TestSweepErasure(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	testChat(t, s, -1001)
+	testUser(t, s, 1, "ana")
+	testUser(t, s, 2, "bia")
+
+	now := time.Now()
+	testMessage(t, s, -1001, 1, 1, now, "")
+	e, err := s.EraseUser(ctx, 1, -1001)
+	if err != nil {
+		t.Fatalf("EraseUser: %v", err)
+	}
+
+	// An update in flight at the opt-out is written after the erasure
+	late := testHash('l')
+	testMessage(t, s, -1001, 2, 1, now, late)
+
+	if err := s.SweepErasure(ctx, e); err != nil {
+		t.Fatalf("SweepErasure: %v", err)
+	}
+	if e.MessagesDeleted != 2 {
+		t.Errorf("MessagesDeleted = %d, want 2", e.MessagesDeleted)
+	}
+	if !slices.Equal(e.UnreferencedBlobs, []string{late}) {
+		t.Errorf("UnreferencedBlobs = %v, want [%s]", e.UnreferencedBlobs, late)
+	}
+
+	var recorded int64
+	if err := s.db.QueryRowContext(ctx, `SELECT messages_deleted FROM user_erasures WHERE id = $1`, e.ID).Scan(&recorded); err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 2 {
+		t.Errorf("user_erasures.messages_deleted = %d, want 2", recorded)
+	}
+
+	// Service messages written after the opt-out leave the user out
+	joined := &ServiceMessage{TelegramMessageID: 3, ChatID: -1001, MessageDate: now, Action: "user_joined",
+		Metadata: []byte(`{"joined_users":[{"user_id":1,"username":"ana"},{"user_id":2,"username":"bia"}]}`)}
+	if err := s.InsertServiceMessage(ctx, joined); err != nil {
+		t.Fatal(err)
+	}
+	var metadata []byte
+	if err := s.db.QueryRowContext(ctx, `SELECT metadata FROM service_messages WHERE id = $1`, joined.ID).Scan(&metadata); err != nil {
+		t.Fatal(err)
+	}
+	if got := joinedUserIDs(t, metadata); !slices.Equal(got, []int64{2}) {
+		t.Errorf("joined users stored after opt-out = %v, want [2]", got)
+	}
+}
+
+// joinedUserIDs returns the users of a user_joined service message
+func joinedUserIDs(t *testing.T, metadata []byte) []int64 {
+	t.Helper()
+	var m struct {
+		JoinedUsers []struct {
+			UserID int64 `json:"user_id"`
+		} `json:"joined_users"`
+	}
+	if err := json.Unmarshal(metadata, &m); err != nil {
+		t.Fatalf("decode metadata %s: %v", metadata, err)
+	}
+	var ids []int64
+	for _, u := range m.JoinedUsers {
+		ids = append(ids, u.UserID)
+	}
+	return ids
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ExportedMessage is a message as written to a chat export
+type ExportedMessage struct {
+	ChatID            int64           `json:"chat_id"`
+	TelegramMessageID int64           `json:"message_id"`
+	Date              time.Time       `json:"date"`
+	EditDate          *time.Time      `json:"edit_date,omitempty"`
+	UserID            *int64          `json:"user_id,omitempty"`
+	Author            *string         `json:"author,omitempty"`
+	Type              string          `json:"type"`
+	Text              *string         `json:"text,omitempty"`
+	ReplyToMessageID  *int64          `json:"reply_to_message_id,omitempty"`
+	MessageThreadID   *int64          `json:"message_thread_id,omitempty"`
+	MediaGroupID      *string         `json:"media_group_id,omitempty"`
+	MediaSHA256       *string         `json:"media_sha256,omitempty"`
+	MediaFileName     *string         `json:"media_file_name,omitempty"`
+	MediaMimeType     *string         `json:"media_mime_type,omitempty"`
+	Latitude          *float64        `json:"latitude,omitempty"`
+	Longitude         *float64        `json:"longitude,omitempty"`
+	VenueTitle        *string         `json:"venue_title,omitempty"`
+	VenueAddress      *string         `json:"venue_address,omitempty"`
+	Metadata          json.RawMessage `json:"metadata,omitempty"`
+}
+
+// ExportedServiceMessage is a service event as written to a chat export
+type ExportedServiceMessage struct {
+	ChatID            int64           `json:"chat_id"`
+	TelegramMessageID int64           `json:"message_id"`
+	Date              time.Time       `json:"date"`
+	ActorUserID       *int64          `json:"actor_user_id,omitempty"`
+	Action            string          `json:"action"`
+	Metadata          json.RawMessage `json:"metadata,omitempty"`
+}
+
+// ExportMessages calls fn with every message of the given chat ids, oldest first
+func (s *PostgresStore) ExportMessages(ctx context.Context, chatIDs []int64, fn func(*ExportedMessage) error) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			m.chat_id, m.telegram_message_id, m.message_date, m.edit_date, m.user_id,
+			NULLIF(COALESCE(NULLIF(TRIM(CONCAT(u.first_name, ' ', u.last_name)), ''), u.username), ''),
+			m.message_type, m.text, m.reply_to_message_id, m.message_thread_id, m.media_group_id,
+			m.media_sha256, m.media_file_name, m.media_mime_type,
+			ST_Y(m.location::geometry), ST_X(m.location::geometry), m.venue_title, m.venue_address,
+			m.metadata
+		FROM messages m
+		LEFT JOIN users u ON u.id = m.user_id
+		WHERE m.chat_id = ANY($1)
+		ORDER BY m.message_date, m.id
+	`, pq.Array(chatIDs))
+	if err != nil {
+		return fmt.Errorf("failed to export messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m ExportedMessage
+		var metadata []byte
+		if err := rows.Scan(&m.ChatID, &m.TelegramMessageID, &m.Date, &m.EditDate, &m.UserID,
+			&m.Author, &m.Type, &m.Text, &m.ReplyToMessageID, &m.MessageThreadID, &m.MediaGroupID,
+			&m.MediaSHA256, &m.MediaFileName, &m.MediaMimeType,
+			&m.Latitude, &m.Longitude, &m.VenueTitle, &m.VenueAddress,
+			&metadata); err != nil {
+			return fmt.Errorf("failed to scan message: %w", err)
+		}
+		m.Metadata = metadata
+		if err := fn(&m); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to export messages: %w", err)
+	}
+	return nil
+}
+
+// ExportServiceMessages calls fn with every service message of the given chat ids, oldest first
+func (s *PostgresStore) ExportServiceMessages(ctx context.Context, chatIDs []int64, fn func(*ExportedServiceMessage) error) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT chat_id, telegram_message_id, message_date, actor_user_id, action, metadata
+		FROM service_messages
+		WHERE chat_id = ANY($1)
+		ORDER BY message_date, id
+	`, pq.Array(chatIDs))
+	if err != nil {
+		return fmt.Errorf("failed to export service messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m ExportedServiceMessage
+		var metadata []byte
+		if err := rows.Scan(&m.ChatID, &m.TelegramMessageID, &m.Date, &m.ActorUserID, &m.Action, &metadata); err != nil {
+			return fmt.Errorf("failed to scan service message: %w", err)
+		}
+		m.Metadata = metadata
+		if err := fn(&m); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to export service messages: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// FailedUpdate is a Telegram update whose handler kept failing, parked so the
+// updates after it could be processed
+type FailedUpdate struct {
+	BotID      int64
+	UpdateID   int64
+	UpdateType string
+	ChatID     *int64
+	Payload    json.RawMessage // the update as received
+	Error      string          // error of the last attempt
+	Attempts   int
+}
+
+// SaveFailedUpdate parks a failed update. Parking it again updates the error.
+func (s *PostgresStore) SaveFailedUpdate(ctx context.Context, u *FailedUpdate) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO failed_updates (bot_id, update_id, update_type, chat_id, payload, error, attempts)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (bot_id, update_id) DO UPDATE SET
+			error = EXCLUDED.error,
+			attempts = failed_updates.attempts + EXCLUDED.attempts,
+			failed_at = NOW()
+	`, u.BotID, u.UpdateID, u.UpdateType, u.ChatID, u.Payload, u.Error, u.Attempts)
+	if err != nil {
+		return fmt.Errorf("failed to save failed update: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ForumTopic is a topic of a forum-enabled supergroup
+type ForumTopic struct {
+	ChatID            int64
+	MessageThreadID   int64
+	Name              string
+	IconColor         *int
+	IconCustomEmojiID *string
+	CreatedByUserID   *int64
+	IsClosed          bool
+	TopicCreatedAt    *time.Time
+	ClosedAt          *time.Time
+	ReopenedAt        *time.Time
+}
+
+// UpsertForumTopic creates a topic or applies a name or icon change to it.
+// Empty fields keep their stored value, since edit events only carry what changed.
+func (s *PostgresStore) UpsertForumTopic(ctx context.Context, topic *ForumTopic) error {
+	query := `
+		INSERT INTO forum_topics (
+			chat_id, message_thread_id, name, icon_color, icon_custom_emoji_id,
+			created_by_user_id, topic_created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (chat_id, message_thread_id) DO UPDATE SET
+			name = COALESCE(NULLIF(EXCLUDED.name, ''), forum_topics.name),
+			icon_color = COALESCE(EXCLUDED.icon_color, forum_topics.icon_color),
+			icon_custom_emoji_id = COALESCE(EXCLUDED.icon_custom_emoji_id, forum_topics.icon_custom_emoji_id),
+			created_by_user_id = COALESCE(forum_topics.created_by_user_id, EXCLUDED.created_by_user_id),
+			topic_created_at = COALESCE(forum_topics.topic_created_at, EXCLUDED.topic_created_at)
+	`
+	_, err := s.db.ExecContext(ctx, query,
+		topic.ChatID, topic.MessageThreadID, topic.Name, topic.IconColor, topic.IconCustomEmojiID,
+		topic.CreatedByUserID, topic.TopicCreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to upsert forum topic: %w", err)
+	}
+	return nil
+}
+
+// EnsureForumTopic stores a topic first seen through one of its messages. An existing
+// topic is left untouched, as the name a message carries may predate later edits.
+func (s *PostgresStore) EnsureForumTopic(ctx context.Context, topic *ForumTopic) error {
+	query := `
+		INSERT INTO forum_topics (
+			chat_id, message_thread_id, name, icon_color, icon_custom_emoji_id, topic_created_at
+		) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (chat_id, message_thread_id) DO NOTHING
+	`
+	_, err := s.db.ExecContext(ctx, query,
+		topic.ChatID, topic.MessageThreadID, topic.Name, topic.IconColor, topic.IconCustomEmojiID,
+		topic.TopicCreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert forum topic: %w", err)
+	}
+	return nil
+}
+
+// SetForumTopicClosed records a topic being closed or reopened at the given time
+func (s *PostgresStore) SetForumTopicClosed(ctx context.Context, chatID, threadID int64, closed bool, at time.Time) error {
+	query := `
+		INSERT INTO forum_topics (chat_id, message_thread_id, name, is_closed, closed_at, reopened_at)
+		VALUES ($1, $2, '', $3,
+			CASE WHEN $3 THEN $4::TIMESTAMPTZ END,
+			CASE WHEN NOT $3 THEN $4::TIMESTAMPTZ END)
+		ON CONFLICT (chat_id, message_thread_id) DO UPDATE SET
+			is_closed = EXCLUDED.is_closed,
+			closed_at = COALESCE(EXCLUDED.closed_at, forum_topics.closed_at),
+			reopened_at = COALESCE(EXCLUDED.reopened_at, forum_topics.reopened_at)
+	`
+	_, err := s.db.ExecContext(ctx, query, chatID, threadID, closed, at)
+	if err != nil {
+		return fmt.Errorf("failed to update forum topic state: %w", err)
+	}
+	return nil
+}
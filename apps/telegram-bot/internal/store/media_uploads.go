@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PendingMedia is a stored message whose media file is not archived in MinIO yet
+type PendingMedia struct {
+	MessageID      int64
+	ServiceMessage bool   // MessageID is a service message, whose media is a chat photo
+	FileID         string // Telegram file id
+	MimeType       string
+	Attempts       int
+}
+
+// SetMessageMedia links a stored message to its media file archived in MinIO
+func (s *PostgresStore) SetMessageMedia(ctx context.Context, messageID int64, hash string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE messages SET media_sha256 = $2, media_file_name = $2, media_file_id = NULL
+		WHERE id = $1
+	`, messageID, hash)
+	if err != nil {
+		return fmt.Errorf("failed to set message media: %w", err)
+	}
+	return nil
+}
+
+// SetServiceMessageMedia links a stored service message to its chat photo archived in MinIO
+func (s *PostgresStore) SetServiceMessageMedia(ctx context.Context, serviceMessageID int64, hash string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE service_messages SET
+			metadata = jsonb_set(COALESCE(metadata, '{}'::jsonb), '{media_sha256}', to_jsonb($2::text)),
+			media_file_id = NULL
+		WHERE id = $1
+	`, serviceMessageID, hash)
+	if err != nil {
+		return fmt.Errorf("failed to set service message media: %w", err)
+	}
+	return nil
+}
+
+// MarkMediaUploadFailed records a failed attempt to archive a message's media file
+func (s *PostgresStore) MarkMediaUploadFailed(ctx context.Context, messageID int64) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE messages SET media_upload_attempts = media_upload_attempts + 1, media_upload_failed_at = NOW()
+		WHERE id = $1 AND media_file_id IS NOT NULL
+	`, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to mark media upload failed: %w", err)
+	}
+	return nil
+}
+
+// MarkServiceMediaUploadFailed records a failed attempt to archive a chat photo
+func (s *PostgresStore) MarkServiceMediaUploadFailed(ctx context.Context, serviceMessageID int64) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE service_messages SET media_upload_attempts = media_upload_attempts + 1, media_upload_failed_at = NOW()
+		WHERE id = $1 AND media_file_id IS NOT NULL
+	`, serviceMessageID)
+	if err != nil {
+		return fmt.Errorf("failed to mark chat photo upload failed: %w", err)
+	}
+	return nil
+}
+
+// ListPendingMedia returns messages and chat photo changes stored before the
+// given time whose media is still not archived, and whose last failed attempt, if
+// any, was before that time too, up to maxAttempts failures, oldest first
+func (s *PostgresStore) ListPendingMedia(ctx context.Context, before time.Time, maxAttempts, limit int) ([]*PendingMedia, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, service_message, media_file_id, mime_type, media_upload_attempts
+		FROM (
+			SELECT id, FALSE AS service_message, media_file_id, COALESCE(media_mime_type, '') AS mime_type,
+				media_upload_attempts, created_at
+			FROM messages
+			WHERE media_file_id IS NOT NULL
+				AND media_sha256 IS NULL
+				AND created_at < $1
+				AND (media_upload_failed_at IS NULL OR media_upload_failed_at < $1)
+				AND media_upload_attempts < $2
+			UNION ALL
+			SELECT id, TRUE, media_file_id, 'image/jpeg', media_upload_attempts, created_at
+			FROM service_messages
+			WHERE media_file_id IS NOT NULL
+				AND created_at < $1
+				AND (media_upload_failed_at IS NULL OR media_upload_failed_at < $1)
+				AND media_upload_attempts < $2
+		) pending
+		ORDER BY created_at
+		LIMIT $3
+	`, before, maxAttempts, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending media: %w", err)
+	}
+	defer rows.Close()
+
+	var pending []*PendingMedia
+	for rows.Next() {
+		var m PendingMedia
+		if err := rows.Scan(&m.MessageID, &m.ServiceMessage, &m.FileID, &m.MimeType, &m.Attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan pending media: %w", err)
+		}
+		pending = append(pending, &m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list pending media: %w", err)
+	}
+	return pending, nil
+}
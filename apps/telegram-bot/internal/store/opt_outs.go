@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// OptOutUser records that a user asked for their messages not to be stored.
+// chatID is the chat the request was made in.
+func (s *PostgresStore) OptOutUser(ctx context.Context, userID, chatID int64) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO user_opt_outs (user_id, chat_id)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO NOTHING
+	`, userID, chatID)
+	if err != nil {
+		return fmt.Errorf("failed to store opt-out: %w", err)
+	}
+	return nil
+}
+
+// ListOptedOutUserIDs returns the users who opted out
+func (s *PostgresStore) ListOptedOutUserIDs(ctx context.Context) ([]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT user_id FROM user_opt_outs`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list opted-out users: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan user id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list opted-out users: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,254 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Poll represents a Telegram poll or quiz
+type Poll struct {
+	ID                    string
+	ChatID                *int64
+	TelegramMessageID     *int64
+	CreatorUserID         *int64
+	Question              string
+	Type                  string
+	IsAnonymous           bool
+	AllowsMultipleAnswers bool
+	CorrectOptionID       *int
+	Explanation           *string
+	TotalVoterCount       int
+	IsClosed              bool
+	OpenPeriodSeconds     *int
+	CloseDate             *time.Time
+	Options               []PollOption
+}
+
+// PollOption is one answer option of a poll
+type PollOption struct {
+	Index      int
+	Text       string
+	VoterCount int
+}
+
+// PollVote is a user's current choice in a non-anonymous poll
+type PollVote struct {
+	PollID    string
+	UserID    int64
+	OptionIDs []int
+	VotedAt   time.Time
+}
+
+// PollResults is the outcome of a poll
+type PollResults struct {
+	Poll *Poll
+	// Winners are the indexes of the options with the most votes
+	Winners []int
+}
+
+// UpsertPoll creates or updates a poll and its options. Chat and message
+// references are only filled in, never cleared, since poll updates carry neither.
+func (s *PostgresStore) UpsertPoll(ctx context.Context, poll *Poll) error {
+	query := `
+		INSERT INTO polls (
+			id, chat_id, telegram_message_id, creator_user_id, question, type, is_anonymous,
+			allows_multiple_answers, correct_option_id, explanation, total_voter_count,
+			is_closed, open_period_seconds, close_date
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (id) DO UPDATE SET
+			chat_id = COALESCE(polls.chat_id, EXCLUDED.chat_id),
+			telegram_message_id = COALESCE(polls.telegram_message_id, EXCLUDED.telegram_message_id),
+			creator_user_id = COALESCE(polls.creator_user_id, EXCLUDED.creator_user_id),
+			question = EXCLUDED.question,
+			correct_option_id = COALESCE(EXCLUDED.correct_option_id, polls.correct_option_id),
+			explanation = COALESCE(EXCLUDED.explanation, polls.explanation),
+			total_voter_count = EXCLUDED.total_voter_count,
+			is_closed = polls.is_closed OR EXCLUDED.is_closed,
+			close_date = COALESCE(EXCLUDED.close_date, polls.close_date)
+	`
+	optionQuery := `
+		INSERT INTO poll_options (poll_id, option_index, text, voter_count)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (poll_id, option_index) DO UPDATE SET
+			text = EXCLUDED.text,
+			voter_count = EXCLUDED.voter_count
+	`
+
+	return s.WithTx(ctx, func(tx *Tx) error {
+		_, err := tx.db.ExecContext(ctx, query,
+			poll.ID, poll.ChatID, poll.TelegramMessageID, poll.CreatorUserID, poll.Question, poll.Type, poll.IsAnonymous,
+			poll.AllowsMultipleAnswers, poll.CorrectOptionID, poll.Explanation, poll.TotalVoterCount,
+			poll.IsClosed, poll.OpenPeriodSeconds, poll.CloseDate)
+		if err != nil {
+			return fmt.Errorf("failed to upsert poll: %w", err)
+		}
+
+		for _, opt := range poll.Options {
+			if _, err := tx.db.ExecContext(ctx, optionQuery, poll.ID, opt.Index, opt.Text, opt.VoterCount); err != nil {
+				return fmt.Errorf("failed to upsert poll option: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// PollExists reports whether a poll with the given Telegram id is stored
+func (s *PostgresStore) PollExists(ctx context.Context, pollID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM polls WHERE id = $1)`, pollID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check poll: %w", err)
+	}
+	return exists, nil
+}
+
+// GetPollChatID returns the chat a stored poll was posted in, or 0 if the poll
+// is unknown or was stored without a chat
+func (s *PostgresStore) GetPollChatID(ctx context.Context, pollID string) (int64, error) {
+	var chatID sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `SELECT chat_id FROM polls WHERE id = $1`, pollID).Scan(&chatID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get poll chat: %w", err)
+	}
+	return chatID.Int64, nil
+}
+
+// UpsertPollVote records a user's current choice; an empty choice means the vote was retracted
+func (s *PostgresStore) UpsertPollVote(ctx context.Context, vote *PollVote) error {
+	if len(vote.OptionIDs) == 0 {
+		_, err := s.db.ExecContext(ctx, `DELETE FROM poll_votes WHERE poll_id = $1 AND user_id = $2`, vote.PollID, vote.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to retract poll vote: %w", err)
+		}
+		return nil
+	}
+
+	query := `
+		INSERT INTO poll_votes (poll_id, user_id, option_ids, voted_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (poll_id, user_id) DO UPDATE SET
+			option_ids = EXCLUDED.option_ids,
+			voted_at = EXCLUDED.voted_at
+	`
+	_, err := s.db.ExecContext(ctx, query, vote.PollID, vote.UserID, pq.Array(vote.OptionIDs), vote.VotedAt)
+	if err != nil {
+		return fmt.Errorf("failed to upsert poll vote: %w", err)
+	}
+	return nil
+}
+
+// GetPollResults returns a poll with its final (or current) option counts. Counts
+// use the larger of Telegram's reported count and the tracked non-anonymous votes,
+// since Telegram only reports counts for some polls.
+func (s *PostgresStore) GetPollResults(ctx context.Context, pollID string) (*PollResults, error) {
+	query := `
+		SELECT id, chat_id, telegram_message_id, creator_user_id, question, type, is_anonymous,
+			allows_multiple_answers, correct_option_id, explanation, total_voter_count,
+			is_closed, open_period_seconds, close_date
+		FROM polls
+		WHERE id = $1
+	`
+	var p Poll
+	err := s.db.QueryRowContext(ctx, query, pollID).Scan(
+		&p.ID, &p.ChatID, &p.TelegramMessageID, &p.CreatorUserID, &p.Question, &p.Type, &p.IsAnonymous,
+		&p.AllowsMultipleAnswers, &p.CorrectOptionID, &p.Explanation, &p.TotalVoterCount,
+		&p.IsClosed, &p.OpenPeriodSeconds, &p.CloseDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("poll %s not found", pollID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get poll: %w", err)
+	}
+
+	optionQuery := `
+		SELECT o.option_index, o.text,
+			GREATEST(o.voter_count, (
+				SELECT COUNT(*) FROM poll_votes v
+				WHERE v.poll_id = o.poll_id AND o.option_index = ANY(v.option_ids)
+			))
+		FROM poll_options o
+		WHERE o.poll_id = $1
+		ORDER BY o.option_index
+	`
+	rows, err := s.db.QueryContext(ctx, optionQuery, pollID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get poll options: %w", err)
+	}
+	defer rows.Close()
+
+	results := &PollResults{Poll: &p}
+	best := 0
+	for rows.Next() {
+		var opt PollOption
+		if err := rows.Scan(&opt.Index, &opt.Text, &opt.VoterCount); err != nil {
+			return nil, fmt.Errorf("failed to scan poll option: %w", err)
+		}
+		p.Options = append(p.Options, opt)
+
+		switch {
+		case opt.VoterCount > best:
+			best = opt.VoterCount
+			results.Winners = []int{opt.Index}
+		case opt.VoterCount == best && best > 0:
+			results.Winners = append(results.Winners, opt.Index)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get poll options: %w", err)
+	}
+	return results, nil
+}
+
+// ListChatPollResults returns the results of the polls posted in a chat, including
+// under its former ids, since the given time. A non-nil threadID restricts them to one forum topic.
+func (s *PostgresStore) ListChatPollResults(ctx context.Context, chatID int64, threadID *int64, since time.Time) ([]*PollResults, error) {
+	query := `
+		SELECT p.id
+		FROM polls p
+		WHERE p.chat_id = ANY(chat_id_group($1))
+			AND p.created_at >= $2
+			AND ($3::BIGINT IS NULL OR EXISTS (
+				SELECT 1 FROM messages m
+				WHERE m.chat_id = p.chat_id
+					AND m.telegram_message_id = p.telegram_message_id
+					AND m.message_thread_id = $3
+			))
+		ORDER BY p.created_at
+	`
+	rows, err := s.db.QueryContext(ctx, query, chatID, since, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list polls: %w", err)
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan poll id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list polls: %w", err)
+	}
+
+	var results []*PollResults
+	for _, id := range ids {
+		r, err := s.GetPollResults(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,16 +12,18 @@ import (
 )
 
 type PostgresStore struct {
-	db *sql.DB
+	db querier
+	// pool is the connection pool; nil for a store bound to a transaction
+	pool *sql.DB
 }
 
-func NewPostgresStore(dsn string) (*PostgresStore, error) {
+func NewPostgresStore(ctx context.Context, dsn string) (*PostgresStore, error) {
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
@@ -29,11 +32,25 @@ func NewPostgresStore(dsn string) (*PostgresStore, error) {
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	return &PostgresStore{db: db}, nil
+	return &PostgresStore{db: observedQuerier{db}, pool: db}, nil
+}
+
+// Ping checks that the database is reachable
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	return s.pool.PingContext(ctx)
 }
 
 func (s *PostgresStore) Close() error {
-	return s.db.Close()
+	return s.pool.Close()
+}
+
+// exec runs a statement and returns the number of rows it affected
+func (s *PostgresStore) exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
 }
 
 // Chat represents a Telegram chat/group
@@ -51,8 +68,12 @@ type User struct {
 	Username  string
 	FirstName string
 	LastName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	IsBot     bool
+	// LanguageCode and IsPremium are only known from some updates; nil keeps the stored value
+	LanguageCode *string
+	IsPremium    *bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
 }
 
 // Message represents a Telegram message
@@ -70,6 +91,7 @@ type Message struct {
 	ForwardedDate       *time.Time
 	EditDate            *time.Time
 	MediaSHA256         *string
+	MediaFileID         *string // Telegram file id, kept until the media is archived
 	MediaFileName       *string
 	MediaFileSize       *int64
 	MediaMimeType       *string
@@ -82,6 +104,8 @@ type Message struct {
 	Longitude           *float64
 	VenueTitle          *string
 	VenueAddress        *string
+	MediaGroupID        *string
+	MessageThreadID     *int64 // forum topic, nil outside topics
 }
 
 // ServiceMessage represents a service message (user joined, left, etc.)
@@ -93,6 +117,7 @@ type ServiceMessage struct {
 	MessageDate       time.Time
 	Action            string
 	Metadata          json.RawMessage
+	MediaFileID       *string // Telegram file id of a chat photo to be archived
 }
 
 // Reaction represents a message reaction
@@ -122,25 +147,43 @@ func (s *PostgresStore) UpsertChat(ctx context.Context, chat *Chat) error {
 	return nil
 }
 
-// UpsertUser creates or updates a user
+// UpsertUser creates or updates a user. Name changes are kept in user_name_history
+// by a database trigger.
 func (s *PostgresStore) UpsertUser(ctx context.Context, user *User) error {
 	query := `
-		INSERT INTO users (id, username, first_name, last_name, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO users (id, username, first_name, last_name, is_bot, language_code, is_premium, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		ON CONFLICT (id) DO UPDATE SET
 			username = EXCLUDED.username,
 			first_name = EXCLUDED.first_name,
 			last_name = EXCLUDED.last_name,
+			is_bot = EXCLUDED.is_bot,
+			language_code = COALESCE(EXCLUDED.language_code, users.language_code),
+			is_premium = COALESCE(EXCLUDED.is_premium, users.is_premium),
 			updated_at = EXCLUDED.updated_at
 	`
 	_, err := s.db.ExecContext(ctx, query,
-		user.ID, user.Username, user.FirstName, user.LastName, user.CreatedAt, user.UpdatedAt)
+		user.ID, user.Username, user.FirstName, user.LastName, user.IsBot, user.LanguageCode, user.IsPremium,
+		user.CreatedAt, user.UpdatedAt)
 	if err != nil {
 		return fmt.Errorf("failed to upsert user: %w", err)
 	}
 	return nil
 }
 
+// LockUser locks a user's row until the transaction ends, so writes about the
+// user are ordered against an erasure, which takes the same lock first
+func (tx *Tx) LockUser(ctx context.Context, userID int64) error {
+	if _, err := tx.exec(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return fmt.Errorf("failed to lock user: %w", err)
+	}
+	return nil
+}
+
+// ErrMessageExists is returned by InsertMessage for a message that is already stored,
+// as when an update is processed again
+var ErrMessageExists = errors.New("message already stored")
+
 // InsertMessage creates a new message
 func (s *PostgresStore) InsertMessage(ctx context.Context, msg *Message) (int64, error) {
 	// Ensure we have valid JSON for JSONB fields
@@ -155,32 +198,18 @@ func (s *PostgresStore) InsertMessage(ctx context.Context, msg *Message) (int64,
 	}
 
 	// Build location point if coordinates are provided
-	var args []interface{}
-
+	location := "NULL"
+	args := []interface{}{
+		msg.TelegramMessageID, msg.ChatID, msg.UserID, msg.MessageDate, msg.MessageType,
+		msg.Text, msg.ReplyToMessageID, msg.ForwardedFromUserID, msg.ForwardedFromChatID,
+		msg.ForwardedDate, msg.EditDate, msg.MediaSHA256, msg.MediaFileName, msg.MediaFileSize,
+		msg.MediaMimeType, msg.MediaDuration, msg.MediaWidth, msg.MediaHeight,
+		entities, metadata, msg.VenueTitle, msg.VenueAddress, msg.MediaGroupID, msg.MessageThreadID,
+		msg.MediaFileID,
+	}
 	if msg.Latitude != nil && msg.Longitude != nil {
-		query := `
-			INSERT INTO messages (
-				telegram_message_id, chat_id, user_id, message_date, message_type,
-				text, reply_to_message_id, forwarded_from_user_id, forwarded_from_chat_id,
-				forwarded_date, edit_date, media_sha256, media_file_name, media_file_size,
-				media_mime_type, media_duration_seconds, media_width, media_height,
-				entities, metadata, location, venue_title, venue_address
-			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, ST_SetSRID(ST_MakePoint($21, $22), 4326)::geography, $23, $24)
-			RETURNING id
-		`
-		args = []interface{}{
-			msg.TelegramMessageID, msg.ChatID, msg.UserID, msg.MessageDate, msg.MessageType,
-			msg.Text, msg.ReplyToMessageID, msg.ForwardedFromUserID, msg.ForwardedFromChatID,
-			msg.ForwardedDate, msg.EditDate, msg.MediaSHA256, msg.MediaFileName, msg.MediaFileSize,
-			msg.MediaMimeType, msg.MediaDuration, msg.MediaWidth, msg.MediaHeight,
-			entities, metadata, *msg.Longitude, *msg.Latitude, msg.VenueTitle, msg.VenueAddress,
-		}
-		var id int64
-		err := s.db.QueryRowContext(ctx, query, args...).Scan(&id)
-		if err != nil {
-			return 0, fmt.Errorf("failed to insert message with location: %w", err)
-		}
-		return id, nil
+		location = "ST_SetSRID(ST_MakePoint($26, $27), 4326)::geography"
+		args = append(args, *msg.Longitude, *msg.Latitude)
 	}
 
 	query := `
@@ -189,25 +218,26 @@ func (s *PostgresStore) InsertMessage(ctx context.Context, msg *Message) (int64,
 			text, reply_to_message_id, forwarded_from_user_id, forwarded_from_chat_id,
 			forwarded_date, edit_date, media_sha256, media_file_name, media_file_size,
 			media_mime_type, media_duration_seconds, media_width, media_height,
-			entities, metadata, venue_title, venue_address
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
+			entities, metadata, venue_title, venue_address, media_group_id, message_thread_id,
+			media_file_id, location
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, ` + location + `)
+		ON CONFLICT (chat_id, telegram_message_id) DO NOTHING
 		RETURNING id
 	`
+
 	var id int64
-	err := s.db.QueryRowContext(ctx, query,
-		msg.TelegramMessageID, msg.ChatID, msg.UserID, msg.MessageDate, msg.MessageType,
-		msg.Text, msg.ReplyToMessageID, msg.ForwardedFromUserID, msg.ForwardedFromChatID,
-		msg.ForwardedDate, msg.EditDate, msg.MediaSHA256, msg.MediaFileName, msg.MediaFileSize,
-		msg.MediaMimeType, msg.MediaDuration, msg.MediaWidth, msg.MediaHeight,
-		entities, metadata, msg.VenueTitle, msg.VenueAddress,
-	).Scan(&id)
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrMessageExists
+	}
 	if err != nil {
 		return 0, fmt.Errorf("failed to insert message: %w", err)
 	}
 	return id, nil
 }
 
-// InsertServiceMessage creates a new service message
+// InsertServiceMessage creates a new service message and sets its ID; a message
+// stored already is left alone, with ID 0
 func (s *PostgresStore) InsertServiceMessage(ctx context.Context, msg *ServiceMessage) error {
 	// Ensure we have valid JSON for JSONB fields
 	metadata := msg.Metadata
@@ -217,12 +247,18 @@ func (s *PostgresStore) InsertServiceMessage(ctx context.Context, msg *ServiceMe
 
 	query := `
 		INSERT INTO service_messages (
-			telegram_message_id, chat_id, actor_user_id, message_date, action, metadata
-		) VALUES ($1, $2, $3, $4, $5, $6)
+			telegram_message_id, chat_id, actor_user_id, message_date, action, metadata, media_file_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
 		ON CONFLICT (chat_id, telegram_message_id) DO NOTHING
+		RETURNING id
 	`
-	_, err := s.db.ExecContext(ctx, query,
-		msg.TelegramMessageID, msg.ChatID, msg.ActorUserID, msg.MessageDate, msg.Action, metadata)
+	err := s.db.QueryRowContext(ctx, query,
+		msg.TelegramMessageID, msg.ChatID, msg.ActorUserID, msg.MessageDate, msg.Action, metadata, msg.MediaFileID).Scan(&msg.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Stored already
+		msg.ID = 0
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to insert service message: %w", err)
 	}
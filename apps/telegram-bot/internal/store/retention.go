@@ -0,0 +1,295 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RetentionPolicy is how long a logical chat keeps its data
+type RetentionPolicy struct {
+	ChatID  int64   // current id of the chat
+	ChatIDs []int64 // every id of the chat, including from before a supergroup migration
+	// Days messages, media files and coordinates are kept; nil keeps them forever
+	TextRetention     *int
+	MediaRetention    *int
+	LocationRetention *int
+}
+
+// PurgeKind is what a purge removes
+type PurgeKind string
+
+const (
+	PurgeMessages        PurgeKind = "messages"         // messages are deleted
+	PurgeServiceMessages PurgeKind = "service_messages" // service events are deleted
+	PurgeMedia           PurgeKind = "media"            // messages lose their media file
+	PurgeServiceMedia    PurgeKind = "service_media"    // service events lose their chat photo
+	PurgeLocations       PurgeKind = "locations"        // messages lose their coordinates and venue
+)
+
+// purgeKind describes how a kind of data is purged. Statements take the chat ids
+// ($1) and the cutoff date ($2).
+type purgeKind struct {
+	table string
+	where string // rows of table to purge
+	hash  string // media hash of a row of table
+	// batch purges at most $3 rows and returns the media hash each of them had
+	batch string
+	// cleanup removes rows left without their message by batch
+	cleanup []string
+}
+
+var purgeKinds = map[PurgeKind]purgeKind{
+	PurgeMessages: {
+		table: "messages",
+		where: "TRUE",
+		hash:  "media_sha256",
+		batch: `
+			DELETE FROM messages WHERE id IN (
+				SELECT id FROM messages
+				WHERE chat_id = ANY($1) AND message_date < $2
+				ORDER BY message_date
+				LIMIT $3
+			)
+			RETURNING media_sha256
+		`,
+		cleanup: []string{`
+			DELETE FROM albums a
+			WHERE a.chat_id = ANY($1) AND a.message_date < $2
+				AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.chat_id = a.chat_id AND m.media_group_id = a.media_group_id)
+		`, `
+			DELETE FROM polls p
+			WHERE p.chat_id = ANY($1) AND p.created_at < $2
+				AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.chat_id = p.chat_id AND m.telegram_message_id = p.telegram_message_id)
+		`},
+	},
+	PurgeServiceMessages: {
+		table: "service_messages",
+		where: "TRUE",
+		hash:  "metadata->>'media_sha256'",
+		batch: `
+			DELETE FROM service_messages WHERE id IN (
+				SELECT id FROM service_messages
+				WHERE chat_id = ANY($1) AND message_date < $2
+				ORDER BY message_date
+				LIMIT $3
+			)
+			RETURNING metadata->>'media_sha256'
+		`,
+	},
+	PurgeMedia: {
+		table: "messages",
+		where: "(media_sha256 IS NOT NULL OR media_file_id IS NOT NULL)",
+		hash:  "media_sha256",
+		// Archived files are named after their hash; a name Telegram gave is kept.
+		// Media not archived yet is dropped from the upload queue.
+		batch: `
+			WITH batch AS (
+				SELECT id, media_sha256 FROM messages
+				WHERE chat_id = ANY($1) AND message_date < $2
+					AND (media_sha256 IS NOT NULL OR media_file_id IS NOT NULL)
+				ORDER BY message_date
+				LIMIT $3
+				FOR UPDATE
+			)
+			UPDATE messages m SET
+				media_sha256 = NULL,
+				media_file_id = NULL,
+				media_file_name = NULLIF(m.media_file_name, batch.media_sha256)
+			FROM batch
+			WHERE m.id = batch.id
+			RETURNING batch.media_sha256
+		`,
+	},
+	PurgeServiceMedia: {
+		table: "service_messages",
+		where: "(metadata->>'media_sha256' IS NOT NULL OR media_file_id IS NOT NULL)",
+		hash:  "metadata->>'media_sha256'",
+		// Chat photos not archived yet are dropped from the upload queue
+		batch: `
+			WITH batch AS (
+				SELECT id, metadata->>'media_sha256' AS media_sha256 FROM service_messages
+				WHERE chat_id = ANY($1) AND message_date < $2
+					AND (metadata->>'media_sha256' IS NOT NULL OR media_file_id IS NOT NULL)
+				ORDER BY message_date
+				LIMIT $3
+				FOR UPDATE
+			)
+			UPDATE service_messages sm SET
+				metadata = sm.metadata - 'media_sha256',
+				media_file_id = NULL
+			FROM batch
+			WHERE sm.id = batch.id
+			RETURNING batch.media_sha256
+		`,
+	},
+	PurgeLocations: {
+		table: "messages",
+		where: "(location IS NOT NULL OR venue_title IS NOT NULL OR venue_address IS NOT NULL)",
+		hash:  "NULL::text",
+		batch: `
+			WITH batch AS (
+				SELECT id FROM messages
+				WHERE chat_id = ANY($1) AND message_date < $2
+					AND (location IS NOT NULL OR venue_title IS NOT NULL OR venue_address IS NOT NULL)
+				ORDER BY message_date
+				LIMIT $3
+				FOR UPDATE
+			)
+			UPDATE messages m SET
+				location = NULL,
+				venue_title = NULL,
+				venue_address = NULL,
+				metadata = CASE
+					WHEN m.message_type = 'location' AND jsonb_typeof(m.metadata) = 'object'
+						THEN m.metadata - ARRAY['horizontal_accuracy', 'live_period', 'heading']
+					ELSE m.metadata
+				END
+			FROM batch
+			WHERE m.id = batch.id
+			RETURNING NULL::text
+		`,
+	},
+}
+
+// PurgeResult is what one purge batch removed
+type PurgeResult struct {
+	Rows int64 // rows deleted or cleared
+	// UnreferencedBlobs are the MinIO objects nothing references any more; they are
+	// deleted by the caller once the batch is committed
+	UnreferencedBlobs []string
+}
+
+// PurgeEstimate is what a purge would remove
+type PurgeEstimate struct {
+	Rows  int64
+	Blobs int64 // MinIO objects left unreferenced
+}
+
+// ListRetentionPolicies returns the retention of every chat that purges anything.
+// Settings left on the old id of a migrated chat are skipped; the chat's current
+// settings cover every id.
+func (s *PostgresStore) ListRetentionPolicies(ctx context.Context) ([]*RetentionPolicy, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT chat_id, chat_id_group(chat_id), text_retention_days, media_retention_days, location_retention_days
+		FROM chat_settings
+		WHERE (text_retention_days IS NOT NULL OR media_retention_days IS NOT NULL OR location_retention_days IS NOT NULL)
+			AND chat_id NOT IN (SELECT alias_chat_id FROM chat_aliases)
+		ORDER BY chat_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list retention policies: %w", err)
+	}
+	defer rows.Close()
+
+	var list []*RetentionPolicy
+	for rows.Next() {
+		var p RetentionPolicy
+		var ids pq.Int64Array
+		if err := rows.Scan(&p.ChatID, &ids, &p.TextRetention, &p.MediaRetention, &p.LocationRetention); err != nil {
+			return nil, fmt.Errorf("failed to scan retention policy: %w", err)
+		}
+		p.ChatIDs = ids
+		list = append(list, &p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list retention policies: %w", err)
+	}
+	return list, nil
+}
+
+// PurgeBatch purges at most limit rows of kind dated before the cutoff from the
+// given chat ids, in one transaction. Transcripts and enrichments of media files
+// nothing references any more are deleted with them.
+func (s *PostgresStore) PurgeBatch(ctx context.Context, kind PurgeKind, chatIDs []int64, before time.Time, limit int) (*PurgeResult, error) {
+	k, ok := purgeKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown purge kind %q", kind)
+	}
+
+	var res PurgeResult
+	err := s.WithTx(ctx, func(tx *Tx) error {
+		n, hashes, err := tx.execReturningHashes(ctx, k.batch, pq.Array(chatIDs), before, limit)
+		if err != nil {
+			return fmt.Errorf("failed to purge %s: %w", kind, err)
+		}
+		res.Rows = n
+		if n == 0 {
+			return nil
+		}
+
+		for _, query := range k.cleanup {
+			if _, err := tx.db.ExecContext(ctx, query, pq.Array(chatIDs), before); err != nil {
+				return fmt.Errorf("failed to clean up after purging %s: %w", kind, err)
+			}
+		}
+
+		if res.UnreferencedBlobs, err = tx.unreferencedBlobs(ctx, hashes); err != nil {
+			return err
+		}
+		return tx.deleteMediaResults(ctx, res.UnreferencedBlobs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// EstimatePurge returns what purging kind before the cutoff would remove from the
+// given chat ids, without removing anything
+func (s *PostgresStore) EstimatePurge(ctx context.Context, kind PurgeKind, chatIDs []int64, before time.Time) (*PurgeEstimate, error) {
+	k, ok := purgeKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown purge kind %q", kind)
+	}
+
+	// A blob is left unreferenced when the purged rows hold all its references
+	query := `
+		WITH purged AS (
+			SELECT ` + k.hash + ` AS hash
+			FROM ` + k.table + `
+			WHERE chat_id = ANY($1) AND message_date < $2 AND ` + k.where + `
+		)
+		SELECT
+			(SELECT COUNT(*) FROM purged),
+			(SELECT COUNT(*)
+			FROM (SELECT hash, COUNT(*) AS refs FROM purged WHERE hash IS NOT NULL GROUP BY hash) p
+			JOIN blobs b ON b.sha256 = p.hash
+			WHERE b.ref_count <= p.refs)
+	`
+
+	var est PurgeEstimate
+	if err := s.db.QueryRowContext(ctx, query, pq.Array(chatIDs), before).Scan(&est.Rows, &est.Blobs); err != nil {
+		return nil, fmt.Errorf("failed to estimate purge of %s: %w", kind, err)
+	}
+	return &est, nil
+}
+
+// ftsIndex is the full-text index over message text
+const ftsIndex = "idx_messages_text_fts"
+
+// VacuumAfterPurge reclaims the space of purged rows in the given tables and
+// refreshes their statistics, so the PostGIS and full-text indexes stay compact
+// and the planner keeps using them. The pending entries of the full-text index
+// are merged as well.
+func (s *PostgresStore) VacuumAfterPurge(ctx context.Context, tables []string) error {
+	for _, table := range tables {
+		// VACUUM cannot run in a transaction, so this is never called on a Tx
+		if _, err := s.db.ExecContext(ctx, `VACUUM (ANALYZE) `+pq.QuoteIdentifier(table)); err != nil {
+			return fmt.Errorf("failed to vacuum %s: %w", table, err)
+		}
+		if table == "messages" {
+			if _, err := s.db.ExecContext(ctx, `SELECT gin_clean_pending_list($1::regclass)`, ftsIndex); err != nil {
+				return fmt.Errorf("failed to clean %s: %w", ftsIndex, err)
+			}
+		}
+	}
+	return nil
+}
+
+// PurgeTable returns the table a kind of purge removes rows from
+func PurgeTable(kind PurgeKind) string {
+	return purgeKinds[kind].table
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPurgeBatchMedia(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	testChat(t, s, -1001)
+	testChat(t, s, -1002)
+	testUser(t, s, 1, "ana")
+
+	own, shared, elsewhere := testHash('a'), testHash('s'), testHash('e')
+	now := time.Now()
+	old := now.AddDate(0, 0, -10)
+	first := testMessage(t, s, -1001, 1, 1, old, own)
+	testMessage(t, s, -1001, 2, 1, old, shared)
+	testMessage(t, s, -1001, 3, 1, old, "")
+	testMessage(t, s, -1001, 4, 1, now, shared)
+	testMessage(t, s, -1002, 1, 1, old, elsewhere)
+
+	chatIDs := []int64{-1001}
+	before := now.AddDate(0, 0, -5)
+	est, err := s.EstimatePurge(ctx, PurgeMedia, chatIDs, before)
+	if err != nil {
+		t.Fatalf("EstimatePurge: %v", err)
+	}
+	if est.Rows != 2 || est.Blobs != 1 {
+		t.Errorf("estimate = %d rows, %d blobs; want 2, 1", est.Rows, est.Blobs)
+	}
+
+	res, err := s.PurgeBatch(ctx, PurgeMedia, chatIDs, before, 10)
+	if err != nil {
+		t.Fatalf("PurgeBatch: %v", err)
+	}
+	if res.Rows != 2 {
+		t.Errorf("purged %d rows, want 2", res.Rows)
+	}
+	if !slices.Equal(res.UnreferencedBlobs, []string{own}) {
+		t.Errorf("UnreferencedBlobs = %v, want [%s]", res.UnreferencedBlobs, own)
+	}
+	if refs, _ := blobRefs(t, s, shared); refs != 1 {
+		t.Errorf("ref_count of file still posted = %d, want 1", refs)
+	}
+	if refs, _ := blobRefs(t, s, elsewhere); refs != 1 {
+		t.Errorf("ref_count of another chat's file = %d, want 1", refs)
+	}
+
+	var hash, name *string
+	if err := s.db.QueryRowContext(ctx, `SELECT media_sha256, media_file_name FROM messages WHERE id = $1`, first).
+		Scan(&hash, &name); err != nil {
+		t.Fatal(err)
+	}
+	if hash != nil || name != nil {
+		t.Errorf("purged message keeps media %v, file name %v", hash, name)
+	}
+
+	res, err = s.PurgeBatch(ctx, PurgeMedia, chatIDs, before, 10)
+	if err != nil {
+		t.Fatalf("PurgeBatch: %v", err)
+	}
+	if res.Rows != 0 {
+		t.Errorf("second purge cleared %d rows, want 0", res.Rows)
+	}
+}
+
+func TestPurgeBatchMessagesInBatches(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	testChat(t, s, -1001)
+	testUser(t, s, 1, "ana")
+
+	now := time.Now()
+	old := now.AddDate(0, 0, -10)
+	for id := int64(1); id <= 3; id++ {
+		testMessage(t, s, -1001, id, 1, old, "")
+	}
+	testMessage(t, s, -1001, 4, 1, now, "")
+
+	chatIDs := []int64{-1001}
+	before := now.AddDate(0, 0, -5)
+	for i, want := range []int64{2, 1, 0} {
+		res, err := s.PurgeBatch(ctx, PurgeMessages, chatIDs, before, 2)
+		if err != nil {
+			t.Fatalf("PurgeBatch: %v", err)
+		}
+		if res.Rows != want {
+			t.Errorf("batch %d purged %d rows, want %d", i+1, res.Rows, want)
+		}
+	}
+
+	var left int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM messages WHERE chat_id = -1001`).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 1 {
+		t.Errorf("%d messages left, want the recent one", left)
+	}
+}
+
+func TestPurgeBatchServiceMedia(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	testChat(t, s, -1001)
+
+	photo := testHash('p')
+	now := time.Now()
+	old := now.AddDate(0, 0, -10)
+	archived := &ServiceMessage{TelegramMessageID: 1, ChatID: -1001, MessageDate: old, Action: "new_chat_photo",
+		Metadata: []byte(`{"media_sha256":"` + photo + `"}`)}
+	fileID := "AgACAgEAAx0"
+	pending := &ServiceMessage{TelegramMessageID: 2, ChatID: -1001, MessageDate: old, Action: "new_chat_photo",
+		MediaFileID: &fileID}
+	for _, msg := range []*ServiceMessage{archived, pending} {
+		if err := s.InsertServiceMessage(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := s.PurgeBatch(ctx, PurgeServiceMedia, []int64{-1001}, now.AddDate(0, 0, -5), 10)
+	if err != nil {
+		t.Fatalf("PurgeBatch: %v", err)
+	}
+	if res.Rows != 2 {
+		t.Errorf("purged %d rows, want 2", res.Rows)
+	}
+	if !slices.Equal(res.UnreferencedBlobs, []string{photo}) {
+		t.Errorf("UnreferencedBlobs = %v, want [%s]", res.UnreferencedBlobs, photo)
+	}
+
+	// The pending photo is dropped from the upload queue
+	queued, err := s.ListPendingMedia(ctx, now.Add(time.Minute), 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 0 {
+		t.Errorf("%d media still pending after the purge, want 0", len(queued))
+	}
+
+	var events int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM service_messages WHERE chat_id = -1001`).Scan(&events); err != nil {
+		t.Fatal(err)
+	}
+	if events != 2 {
+		t.Errorf("%d service messages left, want both kept", events)
+	}
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SearchResult is a message matched by a full-text search
+type SearchResult struct {
+	// ChatID is the id the message was posted under, which differs from the
+	// searched id for messages sent before a group was upgraded to a supergroup
+	ChatID            int64
+	TelegramMessageID int64
+	MessageDate       time.Time
+	Author            string
+	Text              string
+	Rank              float64
+}
+
+// SearchMessages finds the posts of a single chat whose text, voice transcript or
+// image text best match the terms of query. An album is one post, found through any
+// of its items and addressed by its first one. Results never include messages from
+// other chats, but do include those posted under the chat's former ids (see
+// ResolveChatIDs). A non-nil threadID restricts the search to one forum topic.
+func (s *PostgresStore) SearchMessages(ctx context.Context, chatID int64, threadID *int64, query string, limit int) ([]*SearchResult, error) {
+	tsQuery := buildTSQuery(query)
+	if tsQuery == "" {
+		return nil, nil
+	}
+
+	// Message text, voice transcripts and image text are searched separately so each can use
+	// its FTS index. Items of an album are then collapsed into the album, addressed by its
+	// first item like in the message_posts view, keeping the best match.
+	sqlQuery := `
+		WITH q AS (SELECT to_tsquery('simple', $2) AS q),
+		matches AS (
+			SELECT m.chat_id, m.telegram_message_id, m.media_group_id, m.message_date, m.user_id, m.text AS body,
+				ts_rank_cd(to_tsvector('simple', COALESCE(m.text, '')), q.q) AS rank
+			FROM messages m, q
+			WHERE m.chat_id = ANY(chat_id_group($1))
+				AND ($4::BIGINT IS NULL OR m.message_thread_id = $4)
+				AND m.text IS NOT NULL
+				AND to_tsvector('simple', COALESCE(m.text, '')) @@ q.q
+			UNION ALL
+			SELECT m.chat_id, m.telegram_message_id, m.media_group_id, m.message_date, m.user_id,
+				'[' || m.message_type || '] ' || t.text,
+				ts_rank_cd(to_tsvector('simple', COALESCE(t.text, '')), q.q)
+			FROM media_transcripts t
+			JOIN messages m ON m.media_sha256 = t.media_sha256, q
+			WHERE m.chat_id = ANY(chat_id_group($1))
+				AND ($4::BIGINT IS NULL OR m.message_thread_id = $4)
+				AND t.text IS NOT NULL
+				AND to_tsvector('simple', COALESCE(t.text, '')) @@ q.q
+			UNION ALL
+			SELECT m.chat_id, m.telegram_message_id, m.media_group_id, m.message_date, m.user_id,
+				'[' || m.message_type || '] ' || CONCAT_WS(' — ', e.caption, e.ocr_text, m.text),
+				ts_rank_cd(to_tsvector('simple', COALESCE(e.caption, '') || ' ' || COALESCE(e.ocr_text, '')), q.q)
+			FROM media_enrichments e
+			JOIN messages m ON m.media_sha256 = e.media_sha256, q
+			WHERE m.chat_id = ANY(chat_id_group($1))
+				AND ($4::BIGINT IS NULL OR m.message_thread_id = $4)
+				AND e.status = 'done'
+				AND to_tsvector('simple', COALESCE(e.caption, '') || ' ' || COALESCE(e.ocr_text, '')) @@ q.q
+		),
+		posts AS (
+			SELECT DISTINCT ON (r.chat_id, COALESCE(a.first_telegram_message_id, r.telegram_message_id))
+				r.chat_id,
+				COALESCE(a.first_telegram_message_id, r.telegram_message_id) AS telegram_message_id,
+				COALESCE(a.message_date, r.message_date) AS message_date,
+				COALESCE(a.user_id, r.user_id) AS user_id,
+				CASE WHEN a.media_group_id IS NULL THEN r.body ELSE '[album] ' || r.body END AS body,
+				r.rank
+			FROM matches r
+			LEFT JOIN albums a ON a.chat_id = r.chat_id AND a.media_group_id = r.media_group_id
+			ORDER BY r.chat_id, COALESCE(a.first_telegram_message_id, r.telegram_message_id), r.rank DESC
+		)
+		SELECT p.chat_id, p.telegram_message_id, p.message_date,
+			COALESCE(NULLIF(TRIM(CONCAT(u.first_name, ' ', u.last_name)), ''), u.username, ''),
+			p.body, p.rank
+		FROM posts p
+		LEFT JOIN users u ON u.id = p.user_id
+		ORDER BY p.rank DESC, p.message_date DESC
+		LIMIT $3
+	`
+	rows, err := s.db.QueryContext(ctx, sqlQuery, chatID, tsQuery, limit, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*SearchResult
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.ChatID, &r.TelegramMessageID, &r.MessageDate, &r.Author, &r.Text, &r.Rank); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		results = append(results, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	return results, nil
+}
+
+// buildTSQuery turns free text into a prefix-matching OR query ("butcher:* | augusta:*").
+// Only letters and digits are kept, so the result is always valid tsquery syntax.
+func buildTSQuery(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool)
+	var terms []string
+	for _, w := range words {
+		// Short words are mostly articles and prepositions
+		if len([]rune(w)) < 3 || seen[w] {
+			continue
+		}
+		seen[w] = true
+		terms = append(terms, w+":*")
+	}
+	return strings.Join(terms, " | ")
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ChatStats summarises what was ingested from a chat
+type ChatStats struct {
+	Messages        int64
+	MessagesLastDay int64
+	LastMessageAt   *time.Time
+	Users           int64 // distinct authors of messages
+	MediaFiles      int64 // messages whose media file is archived
+	MediaBytes      int64
+	ServiceMessages int64
+}
+
+// GetChatStats returns the ingestion stats of the given chat ids, typically all
+// ids of one logical chat
+func (s *PostgresStore) GetChatStats(ctx context.Context, chatIDs []int64) (*ChatStats, error) {
+	var stats ChatStats
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*),
+			COUNT(*) FILTER (WHERE message_date > NOW() - INTERVAL '24 hours'),
+			MAX(message_date),
+			COUNT(DISTINCT user_id),
+			COUNT(media_sha256),
+			COALESCE(SUM(media_file_size) FILTER (WHERE media_sha256 IS NOT NULL), 0)
+		FROM messages
+		WHERE chat_id = ANY($1)
+	`, pq.Array(chatIDs)).Scan(&stats.Messages, &stats.MessagesLastDay, &stats.LastMessageAt,
+		&stats.Users, &stats.MediaFiles, &stats.MediaBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message stats: %w", err)
+	}
+
+	err = s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM service_messages WHERE chat_id = ANY($1)`,
+		pq.Array(chatIDs)).Scan(&stats.ServiceMessages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service message stats: %w", err)
+	}
+	return &stats, nil
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// migrationsDir holds the schema applied by the postgres container
+const migrationsDir = "../../../postgres/migrations"
+
+// newTestStore returns a store on a fresh schema with every migration applied,
+// dropped when the test ends. Tests using it are skipped unless
+// TEST_DATABASE_URL points at a PostgreSQL database with PostGIS available.
+func newTestStore(t *testing.T) *PostgresStore {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.ExecContext(ctx, `CREATE SCHEMA `+schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.ExecContext(ctx, `DROP SCHEMA `+schema+` CASCADE`); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	// Extensions installed already stay in public
+	s, err := NewPostgresStore(ctx, withSearchPath(t, dsn, schema+",public"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	files, err := filepath.Glob(filepath.Join(migrationsDir, "*.sql"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no migrations in %s: %v", migrationsDir, err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+		if _, err := s.pool.ExecContext(ctx, string(migration)); err != nil {
+			t.Fatalf("apply %s: %v", filepath.Base(file), err)
+		}
+	}
+	return s
+}
+
+// withSearchPath sets the search_path of every connection opened with dsn
+func withSearchPath(t *testing.T, dsn, path string) string {
+	t.Helper()
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + path
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("parse TEST_DATABASE_URL: %v", err)
+	}
+	q := u.Query()
+	q.Set("search_path", path)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// testChat stores an allowed supergroup
+func testChat(t *testing.T, s *PostgresStore, id int64) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+	if err := s.UpsertChat(ctx, &Chat{ID: id, Type: "supergroup", Name: "Churrasco", CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT INTO chat_settings (chat_id, allowed) VALUES ($1, TRUE) ON CONFLICT (chat_id) DO NOTHING`, id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testUser stores a user
+func testUser(t *testing.T, s *PostgresStore, id int64, name string) {
+	t.Helper()
+	now := time.Now()
+	if err := s.UpsertUser(context.Background(), &User{ID: id, Username: name, FirstName: name, CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testMessage stores a message of a user, with a media file when hash is set,
+// and returns its id
+func testMessage(t *testing.T, s *PostgresStore, chatID, telegramID, userID int64, date time.Time, hash string) int64 {
+	t.Helper()
+	msg := &Message{TelegramMessageID: telegramID, ChatID: chatID, UserID: &userID, MessageDate: date, MessageType: "text"}
+	if hash != "" {
+		msg.MessageType = "photo"
+		msg.MediaSHA256 = &hash
+		msg.MediaFileName = &hash
+	}
+	id, err := s.InsertMessage(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// blobRefs returns the reference count of a blob, and whether it has a row
+func blobRefs(t *testing.T, s *PostgresStore, hash string) (int, bool) {
+	t.Helper()
+	var refs int
+	err := s.db.QueryRowContext(context.Background(), `SELECT ref_count FROM blobs WHERE sha256 = $1`, hash).Scan(&refs)
+	if err == sql.ErrNoRows {
+		return 0, false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return refs, true
+}
+
+// testHash returns a fake SHA256 hex digest
+func testHash(c byte) string {
+	return strings.Repeat(string(c), 64)
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Transcript represents the transcription of an audio or video blob
+type Transcript struct {
+	MediaSHA256 string
+	Language    string
+	Text        string
+	Segments    []TranscriptSegment
+	Model       string
+}
+
+// TranscriptSegment is a timed piece of a transcript (times in seconds)
+type TranscriptSegment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+// MediaJob is a stored blob waiting for background processing
+type MediaJob struct {
+	MediaSHA256 string
+	MimeType    string
+	Attempts    int
+}
+
+// ListPendingTranscriptions returns blobs of the given message types that have no
+// transcript yet, or whose previous attempts failed fewer than maxAttempts times
+func (s *PostgresStore) ListPendingTranscriptions(ctx context.Context, messageTypes []string, maxAttempts, limit int) ([]*MediaJob, error) {
+	query := `
+		SELECT m.media_sha256, MAX(COALESCE(m.media_mime_type, '')), COALESCE(MAX(t.attempts), 0)
+		FROM messages m
+		LEFT JOIN media_transcripts t ON t.media_sha256 = m.media_sha256
+		WHERE m.message_type = ANY($1)
+			AND m.media_sha256 IS NOT NULL
+			AND (t.media_sha256 IS NULL OR (t.status = 'failed' AND t.attempts < $2))
+		GROUP BY m.media_sha256
+		ORDER BY MIN(m.message_date)
+		LIMIT $3
+	`
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(messageTypes), maxAttempts, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending transcriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*MediaJob
+	for rows.Next() {
+		var job MediaJob
+		if err := rows.Scan(&job.MediaSHA256, &job.MimeType, &job.Attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan transcription job: %w", err)
+		}
+		jobs = append(jobs, &job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list pending transcriptions: %w", err)
+	}
+	return jobs, nil
+}
+
+// SaveTranscript stores a completed transcript
+func (s *PostgresStore) SaveTranscript(ctx context.Context, t *Transcript) error {
+	segments, err := json.Marshal(t.Segments)
+	if err != nil {
+		return fmt.Errorf("failed to encode transcript segments: %w", err)
+	}
+
+	query := `
+		INSERT INTO media_transcripts (media_sha256, status, language, text, segments, model, attempts, error)
+		VALUES ($1, 'done', $2, $3, $4, $5, 1, NULL)
+		ON CONFLICT (media_sha256) DO UPDATE SET
+			status = 'done',
+			language = EXCLUDED.language,
+			text = EXCLUDED.text,
+			segments = EXCLUDED.segments,
+			model = EXCLUDED.model,
+			attempts = media_transcripts.attempts + 1,
+			error = NULL
+	`
+	_, err = s.db.ExecContext(ctx, query, t.MediaSHA256, t.Language, t.Text, segments, t.Model)
+	if err != nil {
+		return fmt.Errorf("failed to save transcript: %w", err)
+	}
+	return nil
+}
+
+// MarkTranscriptFailed records a failed transcription attempt
+func (s *PostgresStore) MarkTranscriptFailed(ctx context.Context, mediaSHA256, reason string) error {
+	query := `
+		INSERT INTO media_transcripts (media_sha256, status, attempts, error)
+		VALUES ($1, 'failed', 1, $2)
+		ON CONFLICT (media_sha256) DO UPDATE SET
+			status = 'failed',
+			attempts = media_transcripts.attempts + 1,
+			error = EXCLUDED.error
+	`
+	_, err := s.db.ExecContext(ctx, query, mediaSHA256, reason)
+	if err != nil {
+		return fmt.Errorf("failed to mark transcript failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/metrics"
+	"beef-briefing/apps/telegram-bot/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// querier runs statements, either on the connection pool or in a transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// observedQuerier records the latency of every statement it runs, and traces it
+// as a child span of the statement's context
+type observedQuerier struct {
+	q querier
+}
+
+func (o observedQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := observe(ctx, "exec", query)
+	result, err := o.q.ExecContext(ctx, query, args...)
+	done(err)
+	return result, err
+}
+
+func (o observedQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, done := observe(ctx, "query", query)
+	rows, err := o.q.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
+
+func (o observedQuerier) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, done := observe(ctx, "query_row", query)
+	row := o.q.QueryRowContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
+
+// observe times a statement and traces it as a child span of ctx. The returned
+// function is called with the statement's error once it completes.
+func observe(ctx context.Context, operation, query string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracing.StartChild(ctx, "postgres."+operation, attribute.String("db.system", "postgresql"))
+	if span.IsRecording() {
+		span.SetAttributes(attribute.String("db.statement", strings.Join(strings.Fields(query), " ")))
+	}
+	return ctx, func(err error) {
+		metrics.ObserveSince(metrics.DBDuration, operation, start)
+		tracing.End(span, err)
+	}
+}
+
+// Tx is a store bound to a transaction: everything written through it is
+// committed or rolled back together
+type Tx struct {
+	*PostgresStore
+}
+
+// WithTx runs fn in a transaction, committed if fn returns nil and rolled back
+// otherwise. Called on a Tx, it runs fn in that same transaction.
+func (s *PostgresStore) WithTx(ctx context.Context, fn func(tx *Tx) error) error {
+	if s.pool == nil {
+		return fn(&Tx{s})
+	}
+
+	sqlTx, err := s.pool.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer sqlTx.Rollback()
+
+	if err := fn(&Tx{&PostgresStore{db: observedQuerier{sqlTx}}}); err != nil {
+		return err
+	}
+
+	_, done := observe(ctx, "commit", "COMMIT")
+	err = sqlTx.Commit()
+	done(err)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
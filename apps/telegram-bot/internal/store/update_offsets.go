@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// UpdateOffset is the last Telegram update a bot processed, along with every update before it
+type UpdateOffset struct {
+	BotID    int64
+	UpdateID int64
+}
+
+// GetUpdateOffset returns the last update the bot processed, or 0 if none was stored
+func (s *PostgresStore) GetUpdateOffset(ctx context.Context, botID int64) (int64, error) {
+	var updateID int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT last_update_id FROM update_offsets WHERE bot_id = $1`, botID).Scan(&updateID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get update offset: %w", err)
+	}
+	return updateID, nil
+}
+
+// AdvanceUpdateOffset stores the offset, unless a later one is stored already.
+// A nil offset is ignored.
+func (s *PostgresStore) AdvanceUpdateOffset(ctx context.Context, offset *UpdateOffset) error {
+	if offset == nil {
+		return nil
+	}
+
+	query := `
+		INSERT INTO update_offsets (bot_id, last_update_id)
+		VALUES ($1, $2)
+		ON CONFLICT (bot_id) DO UPDATE SET
+			last_update_id = EXCLUDED.last_update_id
+		WHERE update_offsets.last_update_id < EXCLUDED.last_update_id
+	`
+	if _, err := s.db.ExecContext(ctx, query, offset.BotID, offset.UpdateID); err != nil {
+		return fmt.Errorf("failed to store update offset: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans created by the bot
+const tracerName = "beef-briefing/apps/telegram-bot"
+
+// Exporter is an OTLP/HTTP endpoint that spans are sent to
+type Exporter struct {
+	Name     string
+	Endpoint string            // host:port, without scheme
+	Insecure bool              // plain HTTP, e.g. for a local collector
+	Headers  map[string]string // sent with every export, e.g. New Relic's api-key
+}
+
+// Setup installs the global tracer provider, which batches spans to every
+// exporter and samples sampleRatio of the traces. The returned function flushes
+// pending spans and must be called on shutdown.
+//
+// Until Setup is called, or if it is never called, spans are no-ops.
+func Setup(ctx context.Context, serviceName, environment string, sampleRatio float64, exporters []Exporter) (func(context.Context) error, error) {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(serviceName),
+			semconv.DeploymentEnvironment(environment),
+		)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	}
+
+	for _, e := range exporters {
+		clientOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(e.Endpoint)}
+		if e.Insecure {
+			clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+		}
+		if len(e.Headers) > 0 {
+			clientOpts = append(clientOpts, otlptracehttp.WithHeaders(e.Headers))
+		}
+
+		exporter, err := otlptracehttp.New(ctx, clientOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s exporter: %w", e.Name, err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("failed to export spans", "error", err)
+	}))
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the span in ctx, if any
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartChild starts a span as a child of the span in ctx. Without one, it
+// returns a no-op span, so the queries of background jobs don't each start a
+// trace of their own.
+func StartChild(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if parent := trace.SpanFromContext(ctx); !parent.SpanContext().IsValid() {
+		return ctx, parent
+	}
+	return Start(ctx, name, attrs...)
+}
+
+// RecordError marks the span as failed with err. A nil err is ignored.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// End records err on the span, if any, and ends it
+func End(span trace.Span, err error) {
+	RecordError(span, err)
+	span.End()
+}
@@ -0,0 +1,63 @@
+package transcribe
+
+import (
+	"context"
+	"strings"
+)
+
+// Transcriber turns speech into text
+type Transcriber interface {
+	// Transcribe transcribes an audio or video file of the given MIME type
+	Transcribe(ctx context.Context, data []byte, mimeType string) (*Result, error)
+
+	// Name identifies the backend and model, stored alongside each transcript
+	Name() string
+}
+
+// Result is the output of a transcription
+type Result struct {
+	Language string
+	Text     string
+	Segments []Segment
+}
+
+// Segment is a timed piece of a transcription (times in seconds)
+type Segment struct {
+	Start float64
+	End   float64
+	Text  string
+}
+
+// joinSegments builds the full text from segments when a backend doesn't return it
+func joinSegments(segments []Segment) string {
+	parts := make([]string, 0, len(segments))
+	for _, s := range segments {
+		if text := strings.TrimSpace(s.Text); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// extensionFor returns a file extension for a MIME type, so backends relying
+// on ffmpeg can detect the container format
+func extensionFor(mimeType string) string {
+	switch mimeType {
+	case "audio/ogg", "audio/opus":
+		return ".ogg"
+	case "audio/mpeg", "audio/mp3":
+		return ".mp3"
+	case "audio/mp4", "audio/x-m4a", "audio/m4a":
+		return ".m4a"
+	case "audio/wav", "audio/x-wav":
+		return ".wav"
+	case "audio/flac":
+		return ".flac"
+	case "video/mp4":
+		return ".mp4"
+	case "video/webm", "audio/webm":
+		return ".webm"
+	default:
+		return ".bin"
+	}
+}
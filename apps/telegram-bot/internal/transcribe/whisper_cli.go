@@ -0,0 +1,108 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// WhisperCLI transcribes by running the whisper.cpp command line tool. Input is
+// converted to 16 kHz mono WAV with ffmpeg first, as whisper.cpp requires.
+type WhisperCLI struct {
+	binary   string
+	model    string
+	ffmpeg   string
+	language string
+}
+
+func NewWhisperCLI(binary, model, ffmpeg, language string) *WhisperCLI {
+	return &WhisperCLI{
+		binary:   binary,
+		model:    model,
+		ffmpeg:   ffmpeg,
+		language: language,
+	}
+}
+
+func (w *WhisperCLI) Name() string {
+	return "whisper-cli:" + filepath.Base(w.model)
+}
+
+// whisperCLIOutput is the JSON written by whisper-cli -oj
+type whisperCLIOutput struct {
+	Result struct {
+		Language string `json:"language"`
+	} `json:"result"`
+	Transcription []struct {
+		Offsets struct {
+			From int64 `json:"from"` // milliseconds
+			To   int64 `json:"to"`
+		} `json:"offsets"`
+		Text string `json:"text"`
+	} `json:"transcription"`
+}
+
+func (w *WhisperCLI) Transcribe(ctx context.Context, data []byte, mimeType string) (*Result, error) {
+	dir, err := os.MkdirTemp("", "transcribe-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input"+extensionFor(mimeType))
+	if err := os.WriteFile(input, data, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write input file: %w", err)
+	}
+
+	wav := filepath.Join(dir, "audio.wav")
+	if err := run(ctx, w.ffmpeg, "-nostdin", "-loglevel", "error", "-i", input,
+		"-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", wav); err != nil {
+		return nil, fmt.Errorf("failed to convert audio: %w", err)
+	}
+
+	language := w.language
+	if language == "" {
+		language = "auto"
+	}
+	base := filepath.Join(dir, "transcript")
+	if err := run(ctx, w.binary, "-m", w.model, "-f", wav, "-l", language, "-oj", "-of", base, "-np"); err != nil {
+		return nil, fmt.Errorf("whisper failed: %w", err)
+	}
+
+	raw, err := os.ReadFile(base + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read whisper output: %w", err)
+	}
+
+	var out whisperCLIOutput
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("failed to decode whisper output: %w", err)
+	}
+
+	result := &Result{Language: out.Result.Language}
+	for _, t := range out.Transcription {
+		result.Segments = append(result.Segments, Segment{
+			Start: float64(t.Offsets.From) / 1000,
+			End:   float64(t.Offsets.To) / 1000,
+			Text:  strings.TrimSpace(t.Text),
+		})
+	}
+	result.Text = joinSegments(result.Segments)
+	return result, nil
+}
+
+// run executes a command and includes its stderr in the returned error
+func run(ctx context.Context, name string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
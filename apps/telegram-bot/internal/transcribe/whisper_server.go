@@ -0,0 +1,103 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WhisperServer transcribes through a whisper.cpp compatible HTTP server
+// (POST /inference). The server must be started with --convert so it accepts
+// Telegram's OGG/Opus and MP4 files.
+type WhisperServer struct {
+	url        string
+	language   string
+	httpClient *http.Client
+}
+
+func NewWhisperServer(url, language string, timeout time.Duration) *WhisperServer {
+	return &WhisperServer{
+		url:        strings.TrimRight(url, "/"),
+		language:   language,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (w *WhisperServer) Name() string {
+	return "whisper-server"
+}
+
+type whisperServerResponse struct {
+	Language         string `json:"language"`
+	DetectedLanguage string `json:"detected_language"`
+	Text             string `json:"text"`
+	Segments         []struct {
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		Text  string  `json:"text"`
+	} `json:"segments"`
+}
+
+func (w *WhisperServer) Transcribe(ctx context.Context, data []byte, mimeType string) (*Result, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	file, err := form.CreateFormFile("file", "audio"+extensionFor(mimeType))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	_ = form.WriteField("response_format", "verbose_json")
+	_ = form.WriteField("temperature", "0.0")
+	if w.language != "" {
+		_ = form.WriteField("language", w.language)
+	}
+	if err := form.Close(); err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url+"/inference", &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("whisper request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("whisper server returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var out whisperServerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode whisper response: %w", err)
+	}
+
+	result := &Result{
+		Language: out.Language,
+		Text:     strings.TrimSpace(out.Text),
+	}
+	if result.Language == "" {
+		result.Language = out.DetectedLanguage
+	}
+	for _, s := range out.Segments {
+		result.Segments = append(result.Segments, Segment{Start: s.Start, End: s.End, Text: strings.TrimSpace(s.Text)})
+	}
+	if result.Text == "" {
+		result.Text = joinSegments(result.Segments)
+	}
+	return result, nil
+}
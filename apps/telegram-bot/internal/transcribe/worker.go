@@ -0,0 +1,120 @@
+package transcribe
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"beef-briefing/apps/telegram-bot/internal/batch"
+	"beef-briefing/apps/telegram-bot/internal/storage"
+	"beef-briefing/apps/telegram-bot/internal/store"
+)
+
+// batchSize is how many blobs are picked up per polling round
+const batchSize = 10
+
+// MessageTypes are the message types whose media gets transcribed
+var MessageTypes = []string{"voice", "video_note"}
+
+// Worker transcribes stored voice messages and video notes in the background.
+// Transcripts are keyed by blob hash, so a file forwarded many times is only
+// transcribed once.
+type Worker struct {
+	store       *store.PostgresStore
+	minioClient *storage.MinIOClient
+	transcriber Transcriber
+	interval    time.Duration
+	maxAttempts int
+}
+
+func NewWorker(store *store.PostgresStore, minioClient *storage.MinIOClient, transcriber Transcriber, interval time.Duration, maxAttempts int) *Worker {
+	return &Worker{
+		store:       store,
+		minioClient: minioClient,
+		transcriber: transcriber,
+		interval:    interval,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// Run processes pending blobs until ctx is cancelled
+func (w *Worker) Run(ctx context.Context) {
+	batch.Run(ctx, w.interval, batchSize, w.processBatch)
+}
+
+// processBatch transcribes one batch of pending blobs and returns how many were
+// picked up and how many of those were transcribed or recorded as failed
+func (w *Worker) processBatch(ctx context.Context) (picked, done int) {
+	jobs, err := w.store.ListPendingTranscriptions(ctx, MessageTypes, w.maxAttempts, batchSize)
+	if err != nil {
+		slog.Error("failed to list pending transcriptions", "error", err)
+		return 0, 0
+	}
+
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		if w.process(ctx, job) {
+			done++
+		}
+	}
+	return len(jobs), done
+}
+
+// process transcribes one blob and reports whether the transcript or the failed
+// attempt was recorded
+func (w *Worker) process(ctx context.Context, job *store.MediaJob) bool {
+	start := time.Now()
+
+	data, err := w.minioClient.DownloadFile(ctx, job.MediaSHA256)
+	if err != nil {
+		return w.fail(ctx, job, err)
+	}
+
+	result, err := w.transcriber.Transcribe(ctx, data, job.MimeType)
+	if err != nil {
+		return w.fail(ctx, job, err)
+	}
+
+	transcript := &store.Transcript{
+		MediaSHA256: job.MediaSHA256,
+		Language:    result.Language,
+		Text:        result.Text,
+		Model:       w.transcriber.Name(),
+	}
+	for _, s := range result.Segments {
+		transcript.Segments = append(transcript.Segments, store.TranscriptSegment{Start: s.Start, End: s.End, Text: s.Text})
+	}
+
+	if err := w.store.SaveTranscript(ctx, transcript); err != nil {
+		slog.Error("failed to save transcript", "error", err, "hash", job.MediaSHA256)
+		return false
+	}
+
+	slog.Info("media transcribed",
+		"hash", job.MediaSHA256,
+		"language", result.Language,
+		"segments", len(result.Segments),
+		"duration", time.Since(start))
+	return true
+}
+
+// fail records a failed attempt, unless the failure was caused by shutdown, and
+// reports whether it was recorded
+func (w *Worker) fail(ctx context.Context, job *store.MediaJob, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	slog.Warn("failed to transcribe media",
+		"error", err,
+		"hash", job.MediaSHA256,
+		"attempt", job.Attempts+1)
+
+	if err := w.store.MarkTranscriptFailed(ctx, job.MediaSHA256, err.Error()); err != nil {
+		slog.Error("failed to record transcription failure", "error", err, "hash", job.MediaSHA256)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// shutdownTimeout bounds how long in-flight webhook requests may take on stop
+const shutdownTimeout = 10 * time.Second
+
+// secretTokenPattern is the format Telegram accepts for secret tokens
+var secretTokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// Acks tells when updates are processed, so their requests can be answered
+type Acks interface {
+	// Await returns a channel closed once the update is processed; cancel stops waiting
+	Await(updateID int64) (done <-chan struct{}, cancel func())
+}
+
+// Poller receives updates through a Telegram webhook instead of long polling.
+// Register sets the webhook up with Telegram; it is removed again when the
+// poller stops.
+type Poller struct {
+	listen         string
+	publicURL      string
+	secretToken    string
+	certPath       string
+	keyPath        string
+	allowedUpdates []string
+	acks           Acks
+	errc           chan error
+}
+
+// NewPoller returns a webhook poller serving on listen (e.g. ":8443") for updates
+// Telegram posts to publicURL. Requests must carry secretToken, if set. certPath
+// is a self-signed public certificate to upload to Telegram; with keyPath as well,
+// the poller serves HTTPS itself instead of sitting behind a TLS-terminating proxy.
+// Requests are answered once acks reports their update processed.
+func NewPoller(listen, publicURL, secretToken, certPath, keyPath string, allowedUpdates []string, acks Acks) (*Poller, error) {
+	u, err := url.Parse(publicURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("webhook public URL must be an https URL, got %q", publicURL)
+	}
+	if secretToken != "" && !secretTokenPattern.MatchString(secretToken) {
+		return nil, errors.New("webhook secret token must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+	}
+	if keyPath != "" && certPath == "" {
+		return nil, errors.New("webhook key given without a certificate")
+	}
+
+	return &Poller{
+		listen:         listen,
+		publicURL:      publicURL,
+		secretToken:    secretToken,
+		certPath:       certPath,
+		keyPath:        keyPath,
+		allowedUpdates: allowedUpdates,
+		acks:           acks,
+		errc:           make(chan error, 1),
+	}, nil
+}
+
+// Err receives the error the webhook server failed with, such as the listen
+// address being taken. No more updates arrive after it, so the bot should stop.
+func (p *Poller) Err() <-chan error {
+	return p.errc
+}
+
+// Register points Telegram at the webhook (setWebhook)
+func (p *Poller) Register(b *tele.Bot) error {
+	hook := &tele.Webhook{
+		AllowedUpdates: p.allowedUpdates,
+		SecretToken:    p.secretToken,
+		Endpoint: &tele.WebhookEndpoint{
+			PublicURL: p.publicURL,
+			Cert:      p.certPath,
+		},
+	}
+	if err := b.SetWebhook(hook); err != nil {
+		return fmt.Errorf("failed to set webhook: %w", err)
+	}
+	return nil
+}
+
+// Poll serves webhook requests until stop is closed, then removes the webhook
+// (deleteWebhook). Telegram keeps undelivered updates until the next start.
+func (p *Poller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	srv := &http.Server{
+		Addr:              p.listen,
+		Handler:           p.handler(dest, stop),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if p.keyPath != "" {
+			serveErr <- srv.ListenAndServeTLS(p.certPath, p.keyPath)
+		} else {
+			serveErr <- srv.ListenAndServe()
+		}
+	}()
+	slog.Info("webhook server listening", "addr", p.listen, "tls", p.keyPath != "")
+
+	select {
+	case <-stop:
+	case err := <-serveErr:
+		p.errc <- fmt.Errorf("webhook server stopped: %w", err)
+		<-stop
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("failed to shut down webhook server", "error", err)
+	}
+
+	if err := b.RemoveWebhook(); err != nil {
+		slog.Warn("failed to remove webhook", "error", err)
+	}
+}
+
+// handler accepts updates posted by Telegram. An update is only acknowledged once
+// the bot processed it, so Telegram redelivers it if the bot stops or crashes first.
+func (p *Poller) handler(dest chan tele.Update, stop chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+		if p.secretToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(p.secretToken)) != 1 {
+			slog.Warn("webhook request with invalid secret token rejected", "remote_addr", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var update tele.Update
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			slog.Warn("failed to decode webhook update", "error", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		// Awaited before the handoff, so an update processed right away is not missed
+		done, cancel := p.acks.Await(int64(update.ID))
+		defer cancel()
+
+		select {
+		case dest <- update:
+		case <-stop:
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		case <-r.Context().Done():
+			return
+		}
+
+		select {
+		case <-done:
+			w.WriteHeader(http.StatusOK)
+		case <-stop:
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		case <-r.Context().Done():
+		}
+	})
+}
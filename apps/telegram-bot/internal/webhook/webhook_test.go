@@ -0,0 +1,301 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+const testToken = "secret_token-1"
+
+// fakeAcks reports updates processed once done is called for them
+type fakeAcks struct {
+	mu      sync.Mutex
+	waiters map[int64]chan struct{}
+}
+
+func newFakeAcks() *fakeAcks {
+	return &fakeAcks{waiters: make(map[int64]chan struct{})}
+}
+
+func (a *fakeAcks) wait(id int64) chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ch, ok := a.waiters[id]
+	if !ok {
+		ch = make(chan struct{})
+		a.waiters[id] = ch
+	}
+	return ch
+}
+
+func (a *fakeAcks) Await(id int64) (<-chan struct{}, func()) {
+	return a.wait(id), func() {}
+}
+
+func (a *fakeAcks) done(id int64) {
+	close(a.wait(id))
+}
+
+func newTestPoller(t *testing.T, listen string) *Poller {
+	t.Helper()
+	return newTestPollerWithAcks(t, listen, newFakeAcks())
+}
+
+func newTestPollerWithAcks(t *testing.T, listen string, acks Acks) *Poller {
+	t.Helper()
+	p, err := NewPoller(listen, "https://bot.example.com/hook", testToken, "", "", []string{"message"}, acks)
+	if err != nil {
+		t.Fatalf("NewPoller: %v", err)
+	}
+	return p
+}
+
+func post(t *testing.T, h http.Handler, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("X-Telegram-Bot-Api-Secret-Token", token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlerRejectsRequests(t *testing.T) {
+	p := newTestPoller(t, ":0")
+	dest := make(chan tele.Update, 1)
+	h := p.handler(dest, make(chan struct{}))
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		body   string
+		want   int
+	}{
+		{"missing secret token", http.MethodPost, "", `{"update_id":1}`, http.StatusUnauthorized},
+		{"wrong secret token", http.MethodPost, "other", `{"update_id":1}`, http.StatusUnauthorized},
+		{"GET", http.MethodGet, testToken, "", http.StatusMethodNotAllowed},
+		{"PUT", http.MethodPut, testToken, `{"update_id":1}`, http.StatusMethodNotAllowed},
+		{"malformed body", http.MethodPost, testToken, `{"update_id":`, http.StatusBadRequest},
+		{"not an update", http.MethodPost, testToken, `[1, 2]`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/hook", strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("X-Telegram-Bot-Api-Secret-Token", tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			select {
+			case u := <-dest:
+				t.Errorf("update %d handed off from a rejected request", u.ID)
+			default:
+			}
+		})
+	}
+}
+
+func TestHandlerAcknowledgesAfterProcessing(t *testing.T) {
+	acks := newFakeAcks()
+	p := newTestPollerWithAcks(t, ":0", acks)
+	dest := make(chan tele.Update)
+	h := p.handler(dest, make(chan struct{}))
+
+	codes := make(chan int, 1)
+	go func() {
+		codes <- post(t, h, testToken, `{"update_id":42}`).Code
+	}()
+
+	// dest is unbuffered, so nothing may be answered until the update is taken
+	select {
+	case code := <-codes:
+		t.Fatalf("answered %d before the update was handed off", code)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	select {
+	case u := <-dest:
+		if u.ID != 42 {
+			t.Errorf("update_id = %d, want 42", u.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("update not handed off")
+	}
+
+	// Taken but not processed yet
+	select {
+	case code := <-codes:
+		t.Fatalf("answered %d before the update was processed", code)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	acks.done(42)
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("status = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestHandlerRefusesWhileStopping(t *testing.T) {
+	p := newTestPoller(t, ":0")
+	stop := make(chan struct{})
+	close(stop)
+
+	rec := post(t, p.handler(make(chan tele.Update), stop), testToken, `{"update_id":1}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHandlerRefusesUnprocessedOnStop(t *testing.T) {
+	p := newTestPoller(t, ":0")
+	dest := make(chan tele.Update, 1)
+	stop := make(chan struct{})
+	h := p.handler(dest, stop)
+
+	codes := make(chan int, 1)
+	go func() {
+		codes <- post(t, h, testToken, `{"update_id":7}`).Code
+	}()
+	<-dest
+	close(stop)
+
+	// Not processed, so Telegram must deliver it again
+	select {
+	case code := <-codes:
+		if code != http.StatusServiceUnavailable {
+			t.Errorf("status = %d, want %d", code, http.StatusServiceUnavailable)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request not answered on stop")
+	}
+}
+
+// fakeAPI is a Bot API server that records the methods called and their parameters
+type fakeAPI struct {
+	mu    sync.Mutex
+	calls map[string]map[string]any
+}
+
+func newFakeAPI(t *testing.T) (*fakeAPI, *tele.Bot) {
+	t.Helper()
+	api := &fakeAPI{calls: make(map[string]map[string]any)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		body, _ := io.ReadAll(r.Body)
+		params := make(map[string]any)
+		_ = json.Unmarshal(body, &params)
+
+		api.mu.Lock()
+		api.calls[method] = params
+		api.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"ok":true,"result":true}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	b, err := tele.NewBot(tele.Settings{URL: srv.URL, Token: "123:abc", Offline: true})
+	if err != nil {
+		t.Fatalf("NewBot: %v", err)
+	}
+	return api, b
+}
+
+func (a *fakeAPI) call(method string) (map[string]any, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	params, ok := a.calls[method]
+	return params, ok
+}
+
+func TestRegister(t *testing.T) {
+	api, b := newFakeAPI(t)
+	p := newTestPoller(t, ":0")
+
+	if err := p.Register(b); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	params, ok := api.call("setWebhook")
+	if !ok {
+		t.Fatal("setWebhook not called")
+	}
+	if params["url"] != "https://bot.example.com/hook" {
+		t.Errorf("url = %v", params["url"])
+	}
+	if params["secret_token"] != testToken {
+		t.Errorf("secret_token = %v", params["secret_token"])
+	}
+	if params["allowed_updates"] != `["message"]` {
+		t.Errorf("allowed_updates = %v", params["allowed_updates"])
+	}
+}
+
+func TestPollRemovesWebhookOnStop(t *testing.T) {
+	api, b := newFakeAPI(t)
+	p := newTestPoller(t, "127.0.0.1:0")
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		p.Poll(b, make(chan tele.Update), stop)
+		close(done)
+	}()
+	close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Poll did not return after stop")
+	}
+	if _, ok := api.call("deleteWebhook"); !ok {
+		t.Error("deleteWebhook not called")
+	}
+}
+
+func TestPollReportsServerError(t *testing.T) {
+	_, b := newFakeAPI(t)
+
+	// Take the address first so the webhook server cannot listen on it
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	p := newTestPoller(t, ln.Addr().String())
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		p.Poll(b, make(chan tele.Update), stop)
+		close(done)
+	}()
+
+	select {
+	case err := <-p.Err():
+		if err == nil {
+			t.Error("nil error reported")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server error not reported")
+	}
+
+	close(stop)
+	<-done
+}